@@ -21,7 +21,6 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -29,11 +28,14 @@ import (
 	"time"
 
 	"github.com/NYTimes/gziphandler"
+	"github.com/alien-bunny/ab/lib/assets"
 	"github.com/alien-bunny/ab/lib/certcache"
 	"github.com/alien-bunny/ab/lib/collectionloader"
 	"github.com/alien-bunny/ab/lib/config"
+	"github.com/alien-bunny/ab/lib/db"
 	"github.com/alien-bunny/ab/lib/errors"
 	"github.com/alien-bunny/ab/lib/event"
+	"github.com/alien-bunny/ab/lib/health"
 	"github.com/alien-bunny/ab/lib/log"
 	"github.com/alien-bunny/ab/lib/middleware"
 	"github.com/alien-bunny/ab/lib/server"
@@ -47,6 +49,7 @@ import (
 	"github.com/alien-bunny/ab/middlewares/requestmw"
 	"github.com/alien-bunny/ab/middlewares/securitymw"
 	"github.com/alien-bunny/ab/middlewares/sessionmw"
+	"github.com/alien-bunny/ab/middlewares/timeoutmw"
 	"github.com/alien-bunny/ab/middlewares/translationmw"
 	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/text/language"
@@ -59,6 +62,26 @@ const (
 	EventCacheClear  = "cache-clear"
 	EventInstall     = "install"
 	EventMaintenance = "maintenance"
+	EventServerStart = "server-start"
+	EventServerStop  = "server-stop"
+
+	// DefaultRequestTimeout is the default deadline of the requests when HTTP.RequestTimeout is not set in the config.
+	DefaultRequestTimeout = 30 * time.Second
+	// DefaultTimeout is used for the startup and the graceful shutdown when the Timeout is not set in the config.
+	DefaultTimeout = 30 * time.Second
+	// AdminRateLimit is the number of allowed requests per minute on the admin endpoints.
+	AdminRateLimit = 10
+	// LegacyAdminKeyName is the name of the key that is created from the deprecated AdminKey setting.
+	LegacyAdminKeyName = "default"
+	// CSPReportPath is the endpoint of the Content-Security-Policy violation reports. Use it as the ReportURI of the
+	// "securityheaders" site config.
+	CSPReportPath = "/csp-report"
+	// CSPReportRateLimit is the number of accepted violation reports per minute from a client.
+	CSPReportRateLimit = 60
+	// RateLimitIdleTimeout is the time after the unused buckets are removed from the PostgreSQL rate limit store.
+	RateLimitIdleTimeout = 24 * time.Hour
+
+	adminComponent = "admin"
 )
 
 func init() {
@@ -67,6 +90,15 @@ func init() {
 	})
 }
 
+var assetFileSystem http.FileSystem
+
+// SetAssetFileSystem sets the file system that is served under /assets instead of the Directories.Assets directory.
+//
+// This can be used to serve assets that are embedded into the binary. It must be called before Pet().
+func SetAssetFileSystem(fs http.FileSystem) {
+	assetFileSystem = fs
+}
+
 type SiteProvider func(conf map[string]string, readOnly bool) config.CollectionLoader
 
 var siteProviders = make(map[string]SiteProvider)
@@ -88,9 +120,13 @@ func GetSiteProvider(name string) SiteProvider {
 //
 // The basedir parameter is in which directory the server config is. An empty value will default to ".".
 //
-// The returned channel with either return an error very soon, or it will wait until SIGKILL/SIGTERM is received. The
+// The returned channel with either return an error very soon, or it will wait until SIGINT/SIGTERM is received. The
 // channel is not read-only, so it can be closed. Sending something to the channel, or closing it will stop the server.
 // The idiomatic way to stop the server is to close the channel.
+//
+// When the listener is up, the services implementing server.Starter are started, and EventServerStart is dispatched.
+// On shutdown the in-flight requests are drained first, then the services implementing server.Stopper are stopped,
+// EventServerStop is dispatched and the database pools are closed. Both phases are limited by the Timeout config value.
 func Hop(configure func(conf *config.Store, dispatcher *event.Dispatcher, s *server.Server) error, logger log.Logger, basedir string) chan error {
 	ret := make(chan error)
 
@@ -102,7 +138,7 @@ func Hop(configure func(conf *config.Store, dispatcher *event.Dispatcher, s *ser
 		if logger == nil {
 			logger = log.NewDevLogger(os.Stdout)
 		}
-		conf := setupConfig(logger, basedir)
+		conf, configProvider := setupConfig(logger, basedir)
 		dispatcher := event.NewDispatcher()
 
 		s, err := Pet(conf, config.Default, logger, dispatcher)
@@ -128,46 +164,116 @@ func Hop(configure func(conf *config.Store, dispatcher *event.Dispatcher, s *ser
 			serverConfig.Config.Provider = "directory"
 		}
 
-		if err = setupSites(conf, serverConfig); err != nil {
+		siteLoader, err := setupSites(conf, serverConfig)
+		if err != nil {
 			ret <- err
 			return
 		}
 
 		setupHTTPS(conf, logger, serverConfig, s, dispatcher)
 
-		stopch := make(chan os.Signal)
-		signal.Notify(stopch, os.Interrupt, syscall.SIGKILL, syscall.SIGTERM)
+		if err = setupConfigWatcher(conf, logger, serverConfig, dispatcher, configProvider, siteLoader); err != nil {
+			ret <- err
+			return
+		}
+
+		stopch := make(chan os.Signal, 1)
+		signal.Notify(stopch, os.Interrupt, syscall.SIGTERM)
+
+		timeout := time.Duration(serverConfig.Timeout) * time.Second
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
 
 		addr := serverConfig.Host + ":" + serverConfig.Port
+		serverErr := make(chan error, 1)
 		go func() {
 			if err := s.StartHTTPS(addr, "", ""); err != nil && err != http.ErrServerClosed {
 				logger.Log("startserver", err)
-				ret <- err
+				serverErr <- err
 			}
 		}()
 
+		select {
+		case <-s.Ready():
+		case err := <-serverErr:
+			ret <- err
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := startServices(ctx, s, dispatcher, timeout); err != nil {
+			logger.Log("startservices", err)
+			stopServer(s, dispatcher, logger, timeout, cancel)
+			ret <- err
+			return
+		}
+
 		// Wait for either the program to get a signal or a cancellation.
 		select {
 		case <-stopch:
 			// Close the channel, so the caller waiting can exit.
 			defer close(ret)
+		case err := <-serverErr:
+			defer func() {
+				ret <- err
+			}()
 		case <-ret:
 		}
 
 		logger.Log("graceful", "received sigint")
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(serverConfig.Timeout)*time.Second)
-		if err := s.HTTPServer.Shutdown(ctx); err != nil {
-			logger.Log("graceful", "shutting down", "error", err)
-			cancel()
-		} else {
-			logger.Log("graceful", "stopped")
-		}
+		stopServer(s, dispatcher, logger, timeout, cancel)
 	}()
 
 	return ret
 }
 
-func setupConfig(logger log.Logger, basedir string) *config.Store {
+// startServices starts the services and dispatches the ServerStartEvent.
+//
+// The ctx lives until the server shuts down, so it is passed to the services. The startup itself is limited by the
+// timeout.
+func startServices(ctx context.Context, s *server.Server, dispatcher *event.Dispatcher, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		if err := s.StartServices(ctx); err != nil {
+			done <- err
+			return
+		}
+
+		done <- errors.NewMultiError(dispatcher.Dispatch(NewServerStartEvent(ctx, s)))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errors.New("starting the services timed out")
+	}
+}
+
+// stopServer waits for the in-flight requests, cancels the context of the services, and then stops them.
+func stopServer(s *server.Server, dispatcher *event.Dispatcher, logger log.Logger, timeout time.Duration, cancelServices context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.HTTPServer.Shutdown(ctx); err != nil {
+		logger.Log("graceful", "shutting down", "error", err)
+	}
+
+	cancelServices()
+
+	if err := s.StopServices(ctx); err != nil {
+		logger.Log("graceful", "stopping services", "error", err)
+	}
+
+	if errs := dispatcher.Dispatch(NewServerStopEvent(ctx, s)); len(errs) > 0 {
+		logger.Log("graceful", "server stop event", "error", errors.NewMultiError(errs))
+	}
+
+	logger.Log("graceful", "stopped")
+}
+
+func setupConfig(logger log.Logger, basedir string) (*config.Store, *config.DirectoryConfigProvider) {
 	conf := config.NewStore(logger)
 	conf.RegisterSchema("config", reflect.TypeOf(Config{}))
 	defaultCollection := config.NewCollection()
@@ -182,21 +288,53 @@ func setupConfig(logger log.Logger, basedir string) *config.Store {
 	)
 	conf.AddCollection(config.Default, defaultCollection)
 
-	return conf
+	return conf, directoryConfigProvider
 }
 
-func setupSites(conf *config.Store, serverConfig Config) error {
+func setupSites(conf *config.Store, serverConfig Config) (config.CollectionLoader, error) {
 	var loader config.CollectionLoader
 	if provider := GetSiteProvider(serverConfig.Config.Provider); provider != nil {
 		if loader = provider(serverConfig.Config.Config, serverConfig.Config.ReadOnly); loader != nil {
 			conf.AddCollectionLoaders(loader)
 		} else {
-			return errors.New("failed to initialize site config loader")
+			return nil, errors.New("failed to initialize site config loader")
 		}
 	} else {
-		return errors.New("site config provider not found")
+		return nil, errors.New("site config provider not found")
 	}
 
+	return loader, nil
+}
+
+// setupConfigWatcher reloads the server and the site configuration when the files change, if Config.Watch is set.
+//
+// The site directories are only watched if the site config provider is a config.DirectoryCollectionLoader, and its
+// directory exists.
+func setupConfigWatcher(conf *config.Store, logger log.Logger, serverConfig Config, dispatcher *event.Dispatcher, provider *config.DirectoryConfigProvider, siteLoader config.CollectionLoader) error {
+	if !serverConfig.Config.Watch {
+		return nil
+	}
+
+	watcher := config.NewWatcher(conf, dispatcher, logger).
+		WatchProvider(config.Default, provider)
+
+	if serverConfig.Config.PollInterval != "" {
+		interval, err := time.ParseDuration(serverConfig.Config.PollInterval)
+		if err != nil {
+			return err
+		}
+		watcher.SetPollInterval(interval).SetPolling(true)
+	}
+
+	if dl, ok := siteLoader.(config.DirectoryCollectionLoader); ok {
+		if info, err := os.Stat(dl.Base()); err == nil && info.IsDir() {
+			watcher.WatchLoader(dl)
+		}
+	}
+
+	watcher.Start()
+	dispatcher.Subscribe(EventServerStop, event.Action(watcher.Stop))
+
 	return nil
 }
 
@@ -222,6 +360,14 @@ func setupHTTPS(conf *config.Store, logger log.Logger, serverConfig Config, s *s
 		})
 		s.TLSConfig.GetCertificate = cc.Get
 		dispatcher.Subscribe(EventCacheClear, event.Action(cc.Clear))
+		dispatcher.Subscribe(config.EventConfigChanged, event.SubscriberFunc(func(e event.Event) error {
+			// The certificates are in the site config.
+			if key := e.(*config.ConfigChangedEvent).Key(); key == "" || key == "site" {
+				cc.Clear()
+			}
+			return nil
+		}))
+		s.Health.Add(tlsHealthChecker(conf, cc))
 	}
 
 	if s.TLSConfig != nil {
@@ -242,6 +388,33 @@ func setupHTTPS(conf *config.Store, logger log.Logger, serverConfig Config, s *s
 	}
 }
 
+func tlsHealthChecker(conf *config.Store, cc *certcache.CertCache) health.HealthChecker {
+	return health.CheckerFunc(func(ctx context.Context) map[string]error {
+		var serverNames []string
+		for _, namespace := range conf.Namespaces() {
+			if namespace == config.Default {
+				continue
+			}
+
+			siteConfigInterface, err := conf.Get(namespace).Get("site")
+			if err != nil || siteConfigInterface == nil {
+				continue
+			}
+
+			if siteConfigInterface.(Site).TLS.Certificate != "" {
+				serverNames = append(serverNames, namespace)
+			}
+		}
+
+		results := make(map[string]error)
+		for serverName, err := range cc.Check(serverNames...) {
+			results["tls:"+serverName] = err
+		}
+
+		return results
+	})
+}
+
 func hostPolicy(conf *config.Store, logger log.Logger) autocert.HostPolicy {
 	return func(ctx context.Context, host string) error {
 		if conf.Get(host) == nil {
@@ -254,15 +427,26 @@ func hostPolicy(conf *config.Store, logger log.Logger) autocert.HostPolicy {
 }
 
 type Config struct {
-	AdminKey string
-	Config   struct {
+	// Deprecated: use AdminKeys.
+	AdminKey  string
+	AdminKeys []securitymw.AdminKey
+	Config    struct {
 		Provider string
 		Config   map[string]string
 		ReadOnly bool
+		// Watch reloads the configuration when the files change. The site configuration takes effect immediately,
+		// but most of the server configuration is only read on startup.
+		Watch bool
+		// PollInterval enables polling instead of the file system notifications, e.g. "5s". This is useful on
+		// network file systems.
+		PollInterval string
 	}
 	Cookie struct {
 		Prefix       string
 		ExpiresAfter string
+		// Store is the session store: "cookie" (default), "memory" or "postgres".
+		Store            string
+		ConnectionString string
 	}
 	DB struct {
 		MaxIdleConn           int
@@ -272,6 +456,11 @@ type Config struct {
 	Directories struct {
 		Assets string
 	}
+	Assets struct {
+		Precompressed bool
+		Fingerprint   bool
+		MaxAge        int
+	}
 	Log struct {
 		Access        bool
 		DisplayErrors bool
@@ -280,6 +469,7 @@ type Config struct {
 	Gzip                 bool
 	DisableMaster        bool
 	CryptSecret          string
+	CryptSecrets         []string
 	Host                 string
 	Port                 string
 	NamespaceNegotiation struct {
@@ -291,6 +481,21 @@ type Config struct {
 		Autocert    string
 		Site        bool
 	}
+	HTTP struct {
+		ReadTimeout       int
+		ReadHeaderTimeout int
+		WriteTimeout      int
+		IdleTimeout       int
+		MaxHeaderBytes    int
+		RequestTimeout    int
+		// TrustForwardedHeaders uses the X-Forwarded-Proto and X-Forwarded-Host headers of a reverse proxy to
+		// generate absolute URLs. See server.RequestBaseURL().
+		TrustForwardedHeaders bool
+	}
+	RateLimit struct {
+		Store            string
+		ConnectionString string
+	}
 	Timeout  int
 	Language struct {
 		Default   string
@@ -299,6 +504,9 @@ type Config struct {
 }
 
 type Site struct {
+	// URL is the base URL of the site for the absolute URLs (e.g. "https://example.com"). If it is empty, the URL is
+	// taken from the request.
+	URL                string
 	SupportedLanguages []string
 	Directories        struct {
 		Public  string
@@ -320,6 +528,8 @@ func Pet(conf *config.Store, serverNamespace string, logger log.Logger, dispatch
 	}
 
 	s := server.NewServer(conf, logger)
+	s.Limits = serverLimits(serverConfig)
+	s.BaseURL = siteBaseURL(serverConfig)
 	s.Router.NotFound = simpleErrorPage(http.StatusNotFound)
 	s.Router.MethodNotAllowed = simpleErrorPage(http.StatusMethodNotAllowed)
 
@@ -345,13 +555,19 @@ func Pet(conf *config.Store, serverNamespace string, logger log.Logger, dispatch
 		setupConfigMiddleware(conf),
 		setupLogMiddleware(s),
 		setupHSTSMiddleware,
-		setupCookieMiddleware,
+		setupCORSMiddleware,
+		setupSecurityHeadersMiddleware,
+		setupCookieMiddleware(dispatcher),
 		setupLanguageMiddleware(s),
 		setupErrorMiddleware,
+		setupTimeoutMiddleware,
+		setupRateLimitStoreMiddleware(dispatcher),
+		setupRateLimitMiddleware,
 		setupRenderMiddleware,
-		setupCSRFMiddleware,
-		setupDBMiddleware(s, dispatcher),
+		setupDBMiddleware(s, conf, dispatcher),
 		setupCryptMiddleware,
+		// The CSRF middleware runs after the database middleware, so the bearer tokens can be validated.
+		setupCSRFMiddleware(s),
 	}
 
 	for _, mf := range middlewareFactories {
@@ -366,6 +582,12 @@ func Pet(conf *config.Store, serverNamespace string, logger log.Logger, dispatch
 		}
 	}
 
+	s.PostF(CSPReportPath, securitymw.CSPReportHandler, &securitymw.RateLimitMiddleware{
+		Requests: CSPReportRateLimit,
+		Period:   "1m",
+		Scope:    "csp-report",
+	})
+
 	s.GetF("/api/token", func(w http.ResponseWriter, r *http.Request) {
 		token := securitymw.GetCSRFToken(r)
 
@@ -374,19 +596,34 @@ func Pet(conf *config.Store, serverNamespace string, logger log.Logger, dispatch
 			Text(token)
 	})
 
-	if serverConfig.Directories.Assets != "-" {
-		if serverConfig.Directories.Assets == "" {
-			serverConfig.Directories.Assets = "assets"
+	assetOptions := assets.Options{
+		Precompressed: serverConfig.Assets.Precompressed,
+		Fingerprint:   serverConfig.Assets.Fingerprint,
+		MaxAge:        time.Duration(serverConfig.Assets.MaxAge) * time.Second,
+	}
+
+	if assetFileSystem != nil || serverConfig.Directories.Assets != "-" {
+		fs := assetFileSystem
+		if fs == nil {
+			if serverConfig.Directories.Assets == "" {
+				serverConfig.Directories.Assets = "assets"
+			}
+			fs = http.Dir(serverConfig.Directories.Assets)
 		}
 
-		s.AddStaticLocalDir("/assets", serverConfig.Directories.Assets)
+		s.AddStaticDir("/assets", fs, assetOptions)
+		dispatcher.Subscribe(EventCacheClear, event.Action(s.Assets("/assets").Clear))
 
 		if serverConfig.Root {
-			s.AddFile("/", filepath.Join(serverConfig.Directories.Assets, "index.html"))
+			fileServer := s.Assets("/assets")
+			s.GetF("/", func(w http.ResponseWriter, r *http.Request) {
+				r.URL.Path = "/index.html"
+				fileServer.ServeHTTP(w, r)
+			})
 		}
 	}
 
-	s.AddDynamicLocalDir("/public", func(r *http.Request) http.FileSystem {
+	s.AddDynamicDir("/public", func(r *http.Request) http.FileSystem {
 		s, err := configmw.GetConfig(r).Get("site")
 		if err != nil {
 			logmw.Warn(r, "public directory", configmw.CategoryConfigNotFound).Log("error", err)
@@ -399,13 +636,110 @@ func Pet(conf *config.Store, serverNamespace string, logger log.Logger, dispatch
 		}
 
 		return http.Dir(d)
-	})
+	}, assetOptions)
+
+	setupHealth(s, conf, serverConfig)
 
-	maybeSetupAdmin(s, serverConfig.AdminKey)
+	maybeSetupAdmin(s, adminKeyring(serverConfig, s.Logger), healthTimeout(serverConfig))
 
 	return s, nil
 }
 
+// setupHealth registers the /healthz (liveness) and /readyz (readiness) endpoints.
+//
+// Both endpoints bypass the middleware stack, so they work without a session, a CSRF token or a site configuration.
+// The readiness endpoint only reports the status of the checks; the errors are logged, and the detailed report is
+// available on the /health admin endpoint.
+func setupHealth(s *server.Server, conf *config.Store, serverConfig Config) {
+	s.Health.Add(configHealthChecker(conf, serverConfig))
+
+	s.HandleRaw("/healthz", health.LivenessHandler()).SetName("healthz")
+	s.HandleRaw("/readyz", s.Health.ReadinessHandler(healthTimeout(serverConfig), s.Logger)).SetName("readyz")
+}
+
+func healthTimeout(serverConfig Config) time.Duration {
+	timeout := time.Duration(serverConfig.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return timeout
+}
+
+// configHealthChecker checks if the server config and the site configs of the known namespaces can be loaded.
+func configHealthChecker(conf *config.Store, serverConfig Config) health.HealthChecker {
+	return health.CheckerFunc(func(ctx context.Context) map[string]error {
+		results := make(map[string]error)
+
+		_, err := conf.Get(config.Default).Get("config")
+		results["config"] = err
+
+		namespaces := make(map[string]struct{})
+		for _, namespace := range conf.Namespaces() {
+			namespaces[namespace] = struct{}{}
+		}
+		for _, namespace := range serverConfig.NamespaceNegotiation.HostMap {
+			namespaces[namespace] = struct{}{}
+		}
+		delete(namespaces, config.Default)
+
+		for namespace := range namespaces {
+			name := "config:" + namespace
+			c := conf.Get(namespace)
+			if c == nil {
+				results[name] = errors.New("namespace not found")
+				continue
+			}
+
+			_, results[name] = c.Get("site")
+		}
+
+		return results
+	})
+}
+
+// siteBaseURL returns the base URL of the absolute URLs: the URL of the site if it is configured, or the URL of the
+// request.
+func siteBaseURL(serverConfig Config) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if c := configmw.MaybeGetConfig(r); c != nil {
+			if si, err := c.Get("site"); err == nil && si != nil && si.(Site).URL != "" {
+				return si.(Site).URL
+			}
+		}
+
+		return server.RequestBaseURL(r, serverConfig.HTTP.TrustForwardedHeaders)
+	}
+}
+
+// serverLimits converts the HTTP config values (in seconds) to server.Limits.
+//
+// Zero values fall back to server.DefaultLimits, negative values disable the limit.
+func serverLimits(serverConfig Config) server.Limits {
+	limits := server.DefaultLimits
+
+	setDuration := func(d *time.Duration, seconds int) {
+		if seconds > 0 {
+			*d = time.Duration(seconds) * time.Second
+		} else if seconds < 0 {
+			*d = 0
+		}
+	}
+
+	setDuration(&limits.ReadTimeout, serverConfig.HTTP.ReadTimeout)
+	setDuration(&limits.ReadHeaderTimeout, serverConfig.HTTP.ReadHeaderTimeout)
+	setDuration(&limits.WriteTimeout, serverConfig.HTTP.WriteTimeout)
+	setDuration(&limits.IdleTimeout, serverConfig.HTTP.IdleTimeout)
+
+	if serverConfig.HTTP.MaxHeaderBytes > 0 {
+		limits.MaxHeaderBytes = serverConfig.HTTP.MaxHeaderBytes
+	} else if serverConfig.HTTP.MaxHeaderBytes < 0 {
+		limits.MaxHeaderBytes = 0
+	}
+
+	return limits
+}
+
 func setupRequestIDMiddleware(serverConfig Config) (middleware.Middleware, error) {
 	return requestmw.NewRequestIDMiddleware(), nil
 }
@@ -426,7 +760,17 @@ func setupGzipMiddleware(serverConfig Config) (middleware.Middleware, error) {
 		if err != nil {
 			return nil, err
 		}
-		return middleware.Func(handler), nil
+		return middleware.Func(func(next http.Handler) http.Handler {
+			gzipped := handler(next)
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The gzip handler buffers small writes, which would hold back the events of a stream.
+				if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+					next.ServeHTTP(w, r)
+					return
+				}
+				gzipped.ServeHTTP(w, r)
+			})
+		}), nil
 	}
 
 	return nil, nil
@@ -465,19 +809,59 @@ func setupHSTSMiddleware(serverConfig Config) (middleware.Middleware, error) {
 	return configmw.WrapMiddleware("hsts", reflect.TypeOf(securitymw.HSTSMiddleware{})), nil
 }
 
-func setupCookieMiddleware(serverConfig Config) (middleware.Middleware, error) {
-	var expiresAfter time.Duration
-	if serverConfig.Cookie.ExpiresAfter == "" {
-		expiresAfter = time.Hour * 24 * 365
-	} else {
-		var err error
-		expiresAfter, err = time.ParseDuration(serverConfig.Cookie.ExpiresAfter)
-		if err != nil {
-			return nil, err
+func setupCORSMiddleware(serverConfig Config) (middleware.Middleware, error) {
+	return configmw.WrapMiddleware("cors", reflect.TypeOf(securitymw.CORSMiddleware{})), nil
+}
+
+func setupSecurityHeadersMiddleware(serverConfig Config) (middleware.Middleware, error) {
+	return configmw.WrapMiddleware("securityheaders", reflect.TypeOf(securitymw.SecurityHeadersMiddleware{})), nil
+}
+
+func setupCookieMiddleware(dispatcher *event.Dispatcher) func(serverConfig Config) (middleware.Middleware, error) {
+	return func(serverConfig Config) (middleware.Middleware, error) {
+		var expiresAfter time.Duration
+		if serverConfig.Cookie.ExpiresAfter == "" {
+			expiresAfter = time.Hour * 24 * 365
+		} else {
+			var err error
+			expiresAfter, err = time.ParseDuration(serverConfig.Cookie.ExpiresAfter)
+			if err != nil {
+				return nil, err
+			}
 		}
-	}
 
-	return sessionmw.New(serverConfig.Cookie.Prefix, expiresAfter), nil
+		smw := sessionmw.New(serverConfig.Cookie.Prefix, expiresAfter).SetDispatcher(dispatcher)
+
+		var store interface {
+			sessionmw.Store
+			sessionmw.SessionIDStore
+		}
+
+		switch serverConfig.Cookie.Store {
+		case "", "cookie":
+			return smw, nil
+		case "memory":
+			store = sessionmw.NewMemoryStore()
+		case "postgres":
+			if serverConfig.Cookie.ConnectionString == "" {
+				return nil, errors.New("empty session connection string")
+			}
+
+			pgstore := sessionmw.NewPostgresStore(db.RetryDBConn(serverConfig.Cookie.ConnectionString, 10))
+			if err := pgstore.CreateTable(); err != nil {
+				return nil, err
+			}
+			store = pgstore
+		default:
+			return nil, errors.New("unknown session store: " + serverConfig.Cookie.Store)
+		}
+
+		dispatcher.Subscribe(EventMaintenance, event.SubscriberFunc(func(e event.Event) error {
+			return store.DeleteExpired()
+		}))
+
+		return smw.SetStore(store).SetIDStore(store), nil
+	}
 }
 
 func setupLanguageMiddleware(s *server.Server) func(serverConfig Config) (middleware.Middleware, error) {
@@ -534,11 +918,64 @@ func setupRenderMiddleware(serverConfig Config) (middleware.Middleware, error) {
 	return rendermw.New(), nil
 }
 
-func setupCSRFMiddleware(serverConfig Config) (middleware.Middleware, error) {
-	return securitymw.NewCSRFMiddleware(), nil
+func setupTimeoutMiddleware(serverConfig Config) (middleware.Middleware, error) {
+	timeout := DefaultRequestTimeout
+	if serverConfig.HTTP.RequestTimeout > 0 {
+		timeout = time.Duration(serverConfig.HTTP.RequestTimeout) * time.Second
+	} else if serverConfig.HTTP.RequestTimeout < 0 {
+		return nil, nil
+	}
+
+	return timeoutmw.New(timeout), nil
 }
 
-func setupDBMiddleware(s *server.Server, dispatcher *event.Dispatcher) func(serverConfig Config) (middleware.Middleware, error) {
+func setupRateLimitStoreMiddleware(dispatcher *event.Dispatcher) func(serverConfig Config) (middleware.Middleware, error) {
+	return func(serverConfig Config) (middleware.Middleware, error) {
+		switch serverConfig.RateLimit.Store {
+		case "", "memory":
+			return securitymw.NewRateLimitStoreMiddleware(securitymw.NewMemoryRateLimitStore()), nil
+		case "postgres":
+			if serverConfig.RateLimit.ConnectionString == "" {
+				return nil, errors.New("empty rate limit connection string")
+			}
+
+			store := securitymw.NewPostgresRateLimitStore(db.RetryDBConn(serverConfig.RateLimit.ConnectionString, 10))
+			if err := store.CreateTable(); err != nil {
+				return nil, err
+			}
+
+			dispatcher.Subscribe(EventMaintenance, event.SubscriberFunc(func(e event.Event) error {
+				return store.DeleteIdle(RateLimitIdleTimeout)
+			}))
+
+			return securitymw.NewRateLimitStoreMiddleware(store), nil
+		default:
+			return nil, errors.New("unknown rate limit store: " + serverConfig.RateLimit.Store)
+		}
+	}
+}
+
+func setupRateLimitMiddleware(serverConfig Config) (middleware.Middleware, error) {
+	return configmw.WrapMiddleware("ratelimit", reflect.TypeOf(securitymw.RateLimitMiddleware{})), nil
+}
+
+// setupCSRFMiddleware creates the CSRF middleware. The bearer tokens are validated with the BearerAuthenticator of
+// the server, which is set after Pet() returns.
+func setupCSRFMiddleware(s *server.Server) func(serverConfig Config) (middleware.Middleware, error) {
+	return func(serverConfig Config) (middleware.Middleware, error) {
+		return securitymw.NewCSRFMiddleware().
+			Exempt(CSPReportPath).
+			SetBearerAuthenticator(func(w http.ResponseWriter, r *http.Request, token string) *http.Request {
+				if s.BearerAuthenticator == nil {
+					return nil
+				}
+
+				return s.BearerAuthenticator(w, r, token)
+			}), nil
+	}
+}
+
+func setupDBMiddleware(s *server.Server, conf *config.Store, dispatcher *event.Dispatcher) func(serverConfig Config) (middleware.Middleware, error) {
 	return func(serverConfig Config) (middleware.Middleware, error) {
 		dbMiddleware := dbmw.NewMiddleware(s)
 		dbMiddleware.MaxIdleConnections = serverConfig.DB.MaxIdleConn
@@ -546,22 +983,34 @@ func setupDBMiddleware(s *server.Server, dispatcher *event.Dispatcher) func(serv
 		dbMiddleware.ConnectionMaxLifetime = time.Duration(serverConfig.DB.ConnectionMaxLifetime) * time.Second
 
 		dispatcher.Subscribe(EventInstall, dbMiddleware)
+		dispatcher.Subscribe(EventServerStop, event.Action(dbMiddleware.Close))
+		s.Health.Add(dbmw.NewHealthChecker(dbMiddleware, conf))
 
 		return dbMiddleware, nil
 	}
 }
 
+// setupCryptMiddleware creates the crypt middleware from the CryptSecrets and the CryptSecret.
+//
+// The first secret encrypts, and every secret decrypts. CryptSecret is appended to CryptSecrets.
 func setupCryptMiddleware(serverConfig Config) (middleware.Middleware, error) {
-	if serverConfig.CryptSecret == "" {
+	encoded := serverConfig.CryptSecrets
+	if serverConfig.CryptSecret != "" {
+		encoded = append(encoded[:len(encoded):len(encoded)], serverConfig.CryptSecret)
+	}
+	if len(encoded) == 0 {
 		return nil, errors.New("empty crypt secret")
 	}
 
-	cryptSecret, err := hex.DecodeString(serverConfig.CryptSecret)
-	if err != nil {
-		return nil, err
+	secrets := make([][]byte, len(encoded))
+	for i, e := range encoded {
+		var err error
+		if secrets[i], err = hex.DecodeString(e); err != nil {
+			return nil, err
+		}
 	}
 
-	cmw, err := cryptmw.NewCryptMiddleware(cryptSecret)
+	cmw, err := cryptmw.NewCryptMiddleware(secrets[0], secrets[1:]...)
 	if err != nil {
 		return nil, err
 	}
@@ -569,27 +1018,68 @@ func setupCryptMiddleware(serverConfig Config) (middleware.Middleware, error) {
 	return cmw, nil
 }
 
-func maybeSetupAdmin(s *server.Server, adminKey string) {
-	if adminKey != "" {
-		keymw := securitymw.AdminKeyMiddleware(adminKey)
+// adminKeyring creates the keyring of the admin endpoints.
+//
+// The deprecated AdminKey setting is converted to a key named "default" with every scope.
+func adminKeyring(serverConfig Config, logger log.Logger) *securitymw.AdminKeyring {
+	keys := serverConfig.AdminKeys
+	if serverConfig.AdminKey != "" {
+		log.Warn(logger).Log("admin", "the AdminKey setting is deprecated, use AdminKeys")
+		keys = append(keys, securitymw.AdminKey{
+			Name:   LegacyAdminKeyName,
+			Hash:   securitymw.HashAdminKey(serverConfig.AdminKey),
+			Scopes: securitymw.AdminScopes,
+		})
+	}
 
-		if s.IsMaster() {
-			s.GetF("/install", func(w http.ResponseWriter, r *http.Request) {
-				errs := eventmw.GetDispatcher(r).Dispatch(NewInstallEvent(r))
-				MaybeFail(http.StatusInternalServerError, errors.NewMultiError(errs))
-			}, keymw)
+	return securitymw.NewAdminKeyring(keys...)
+}
 
-			s.GetF("/maintenance", func(w http.ResponseWriter, r *http.Request) {
-				errs := eventmw.GetDispatcher(r).Dispatch(NewMaintenanceEvent(r))
-				MaybeFail(http.StatusInternalServerError, errors.NewMultiError(errs))
-			}, keymw)
-		}
+// adminAction wraps an admin endpoint with audit logging.
+func adminAction(action string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logmw.Info(r, adminComponent, securitymw.CategoryAudit).Log(
+			"key", securitymw.GetAdminKeyName(r),
+			"action", action,
+		)
+		handler(w, r)
+	}
+}
+
+func maybeSetupAdmin(s *server.Server, keyring *securitymw.AdminKeyring, healthTimeout time.Duration) {
+	if keyring.Empty() {
+		return
+	}
+
+	limitmw := &securitymw.RateLimitMiddleware{
+		Requests: AdminRateLimit,
+		Period:   "1m",
+		Scope:    "admin",
+	}
 
-		s.GetF("/cache-clear", func(w http.ResponseWriter, r *http.Request) {
-			errs := eventmw.GetDispatcher(r).Dispatch(&CacheClearEvent{})
+	if s.IsMaster() {
+		s.GetF("/install", adminAction("install", func(w http.ResponseWriter, r *http.Request) {
+			errs := eventmw.GetDispatcher(r).Dispatch(NewInstallEvent(r))
 			MaybeFail(http.StatusInternalServerError, errors.NewMultiError(errs))
-		}, keymw)
+		}), limitmw, securitymw.NewAdminKeyMiddleware(keyring, securitymw.AdminScopeInstall), timeoutmw.New(0))
+
+		s.GetF("/maintenance", adminAction("maintenance", func(w http.ResponseWriter, r *http.Request) {
+			errs := eventmw.GetDispatcher(r).Dispatch(NewMaintenanceEvent(r))
+			MaybeFail(http.StatusInternalServerError, errors.NewMultiError(errs))
+		}), limitmw, securitymw.NewAdminKeyMiddleware(keyring, securitymw.AdminScopeMaintenance), timeoutmw.New(0))
 	}
+
+	s.GetF("/cache-clear", adminAction("cache-clear", func(w http.ResponseWriter, r *http.Request) {
+		errs := eventmw.GetDispatcher(r).Dispatch(&CacheClearEvent{})
+		MaybeFail(http.StatusInternalServerError, errors.NewMultiError(errs))
+	}), limitmw, securitymw.NewAdminKeyMiddleware(keyring, securitymw.AdminScopeCacheClear))
+
+	s.GetF("/routes", adminAction("routes", func(w http.ResponseWriter, r *http.Request) {
+		Render(r).JSON(s.Routes())
+	}), limitmw, securitymw.NewAdminKeyMiddleware(keyring, securitymw.AdminScopeConfigRead))
+
+	s.GetF("/health", adminAction("health", s.Health.DetailedReadinessHandler(healthTimeout).ServeHTTP),
+		limitmw, securitymw.NewAdminKeyMiddleware(keyring, securitymw.AdminScopeConfigRead))
 }
 
 func getConfig(conf *config.Store, namespace string, logger log.Logger) (Config, error) {
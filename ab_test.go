@@ -119,13 +119,13 @@ var _ = Describe("Empty endpoint", func() {
 var _ = Describe("Admin tasks", func() {
 	It("should perform maintenance", func() {
 		c := clientFactory()
-		c.Request("GET", "/maintenance?key="+abtest.FakeAdminKey, nil, nil, nil, http.StatusNoContent)
+		c.Request("GET", "/maintenance", nil, abtest.AdminRequest, nil, http.StatusNoContent)
 		Expect(maintenanceRan).To(BeTrue())
 	})
 
 	It("should clear caches", func() {
 		c := clientFactory()
-		c.Request("GET", "/cache-clear?key="+abtest.FakeAdminKey, nil, nil, nil, http.StatusNoContent)
+		c.Request("GET", "/cache-clear", nil, abtest.AdminRequest, nil, http.StatusNoContent)
 		Expect(cacheCleared).To(BeTrue())
 	})
 })
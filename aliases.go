@@ -88,6 +88,11 @@ func GetParams(r *http.Request) httprouter.Params {
 	return server.GetParams(r)
 }
 
+// URLFor generates an absolute URL of a named route for the current request.
+func URLFor(r *http.Request, name string, params ...string) (string, error) {
+	return server.URLFor(r, name, params...)
+}
+
 // MustDecode decodes the the request body into v.
 func MustDecode(r *http.Request, v interface{}) {
 	decoder.MustDecode(r, v)
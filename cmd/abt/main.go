@@ -21,6 +21,7 @@ import (
 	"github.com/alien-bunny/ab/tools/decrypt"
 	"github.com/alien-bunny/ab/tools/gencert"
 	"github.com/alien-bunny/ab/tools/gensecret"
+	"github.com/alien-bunny/ab/tools/routes"
 	"github.com/alien-bunny/ab/tools/scaffold"
 	"github.com/alien-bunny/ab/tools/session"
 	"github.com/alien-bunny/ab/tools/version"
@@ -42,6 +43,7 @@ func main() {
 		scaffoldcmd.CreateScaffoldCMD(logger),
 		versioncmd.CreateVersionCMD(logger),
 		gencert.CreateGencertCMD(logger),
+		routescmd.CreateRoutesCMD(logger),
 	)
 
 	abtCmd.Execute()
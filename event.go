@@ -15,9 +15,11 @@
 package ab
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/alien-bunny/ab/lib/event"
+	"github.com/alien-bunny/ab/lib/server"
 )
 
 // CacheClearEvent fires when some cache should be cleared.
@@ -88,3 +90,71 @@ func (e *MaintenanceEvent) Name() string {
 func (e *MaintenanceEvent) ErrorStrategy() event.ErrorStrategy {
 	return event.ErrorStrategyAggregate
 }
+
+// ServerStartEvent fires when the server is listening, and the services are started.
+type ServerStartEvent struct {
+	ctx context.Context
+	s   *server.Server
+}
+
+// NewServerStartEvent constructs a ServerStartEvent.
+func NewServerStartEvent(ctx context.Context, s *server.Server) *ServerStartEvent {
+	return &ServerStartEvent{
+		ctx: ctx,
+		s:   s,
+	}
+}
+
+// Context returns a context that is cancelled when the server shuts down.
+func (e *ServerStartEvent) Context() context.Context {
+	return e.ctx
+}
+
+// Server returns the server that is started.
+func (e *ServerStartEvent) Server() *server.Server {
+	return e.s
+}
+
+// Name of the event. Always returns EventServerStart.
+func (e *ServerStartEvent) Name() string {
+	return EventServerStart
+}
+
+// ErrorStrategy of the event. Always returns event.ErrorStrategyStop.
+func (e *ServerStartEvent) ErrorStrategy() event.ErrorStrategy {
+	return event.ErrorStrategyStop
+}
+
+// ServerStopEvent fires when the server is stopped, after the in-flight requests are finished.
+type ServerStopEvent struct {
+	ctx context.Context
+	s   *server.Server
+}
+
+// NewServerStopEvent constructs a ServerStopEvent.
+func NewServerStopEvent(ctx context.Context, s *server.Server) *ServerStopEvent {
+	return &ServerStopEvent{
+		ctx: ctx,
+		s:   s,
+	}
+}
+
+// Context returns a context that is cancelled when the shutdown timeout expires.
+func (e *ServerStopEvent) Context() context.Context {
+	return e.ctx
+}
+
+// Server returns the server that is stopped.
+func (e *ServerStopEvent) Server() *server.Server {
+	return e.s
+}
+
+// Name of the event. Always returns EventServerStop.
+func (e *ServerStopEvent) Name() string {
+	return EventServerStop
+}
+
+// ErrorStrategy of the event. Always returns event.ErrorStrategyAggregate.
+func (e *ServerStopEvent) ErrorStrategy() event.ErrorStrategy {
+	return event.ErrorStrategyAggregate
+}
@@ -36,6 +36,7 @@ import (
 	"github.com/alien-bunny/ab/lib/util"
 	"github.com/alien-bunny/ab/middlewares/configmw"
 	"github.com/alien-bunny/ab/middlewares/dbmw"
+	"github.com/alien-bunny/ab/middlewares/securitymw"
 	"github.com/alien-bunny/ab/middlewares/sessionmw"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -47,9 +48,10 @@ const (
 )
 
 var (
-	FakeKey      = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 2}
-	FakeAdminKey = "00000000000000000000000000000000"
-	LoggerWriter = ioutil.Discard
+	FakeKey          = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 2}
+	FakeAdminKey     = "00000000000000000000000000000000"
+	FakeAdminKeyName = "test"
+	LoggerWriter     = ioutil.Discard
 
 	cleanupRegistered = false
 	removeDirectories []string
@@ -174,6 +176,11 @@ func TestMiddleware(stack *middleware.Stack, handler http.HandlerFunc) *httptest
 	return w
 }
 
+// AdminRequest sets the Authorization header of the fake admin key.
+func AdminRequest(r *http.Request) {
+	r.Header.Set("Authorization", securitymw.AdminKeyHeader(FakeAdminKeyName, FakeAdminKey))
+}
+
 func NewRequest(method, url string, body io.Reader) (*http.Request, error) {
 	r, err := http.NewRequest(method, url, body)
 	if err != nil {
@@ -188,12 +195,19 @@ func NewRequest(method, url string, body io.Reader) (*http.Request, error) {
 
 func serverConfig() ab.Config {
 	c := ab.Config{
-		AdminKey:    FakeAdminKey,
 		Root:        true,
 		Gzip:        true,
 		CryptSecret: hex.EncodeToString(FakeKey),
 	}
 
+	c.AdminKeys = []securitymw.AdminKey{
+		{
+			Name:   FakeAdminKeyName,
+			Hash:   securitymw.HashAdminKey(FakeAdminKey),
+			Scopes: securitymw.AdminScopes,
+		},
+	}
+
 	c.NamespaceNegotiation.HostMap = map[string]string{
 		"testhost": "test",
 	}
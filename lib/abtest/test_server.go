@@ -101,5 +101,5 @@ func (s *TestServer) Start(setup SetupFunc) (string, func()) {
 
 func installSite(c *TestClient) {
 	c.panic = true
-	c.Request("GET", "/install?key="+FakeAdminKey, nil, nil, nil, http.StatusNoContent)
+	c.Request("GET", "/install", nil, AdminRequest, nil, http.StatusNoContent)
 }
@@ -0,0 +1,502 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package assets serves static files with precompression, strong ETags and fingerprinted file names.
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxAge is the default cache lifetime of the fingerprinted files.
+const DefaultMaxAge = 365 * 24 * time.Hour
+
+// hashLength is the length of the hash in the fingerprinted file names.
+const hashLength = 10
+
+// encodings are the precompressed variants in the order of preference.
+var encodings = []struct {
+	name      string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Options configures a FileServer.
+type Options struct {
+	// Precompressed enables serving the .br and .gz siblings of the files when the client accepts them.
+	Precompressed bool
+	// Fingerprint enables the fingerprinted file names (app.js -> app.3f2a1c9d0b.js).
+	// Fingerprinted files are served with a long Cache-Control header.
+	Fingerprint bool
+	// MaxAge is the cache lifetime of the fingerprinted files. DefaultMaxAge is used if it is 0.
+	MaxAge time.Duration
+}
+
+// Manifest maps file names to fingerprinted file names.
+type Manifest map[string]string
+
+// Path returns the fingerprinted name of a file, or the name itself if the file is not in the manifest.
+func (m Manifest) Path(name string) string {
+	if fingerprinted, ok := m[strings.TrimPrefix(name, "/")]; ok {
+		return fingerprinted
+	}
+
+	return strings.TrimPrefix(name, "/")
+}
+
+type fileHash struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+// FileServer serves the files of an http.FileSystem.
+//
+// Compared to http.FileServer, it does not list directories, it sends strong ETags, and it can serve precompressed
+// and fingerprinted files. The FileSystem can be a local directory (http.Dir) or any virtual file system, e.g. files
+// embedded into the binary.
+type FileServer struct {
+	fs   http.FileSystem
+	opts Options
+
+	// Prefix is the URL prefix where the FileServer is mounted. It is used by URL().
+	Prefix string
+
+	mtx      sync.RWMutex
+	hashes   map[string]fileHash
+	manifest Manifest
+}
+
+// New creates a FileServer.
+func New(fs http.FileSystem, opts Options) *FileServer {
+	if opts.MaxAge == 0 {
+		opts.MaxAge = DefaultMaxAge
+	}
+
+	return &FileServer{
+		fs:     fs,
+		opts:   opts,
+		hashes: make(map[string]fileHash),
+	}
+}
+
+// Clear clears the cached hashes and the manifest.
+func (s *FileServer) Clear() {
+	s.mtx.Lock()
+	s.hashes = make(map[string]fileHash)
+	s.manifest = nil
+	s.mtx.Unlock()
+}
+
+// Hash returns the hex encoded SHA-256 hash of a file.
+//
+// The hashes are cached until the modification time or the size of the file changes.
+func (s *FileServer) Hash(name string) (string, error) {
+	name = cleanPath(name)
+
+	f, err := s.fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	return s.hash(name, f, stat)
+}
+
+func (s *FileServer) hash(name string, f http.File, stat os.FileInfo) (string, error) {
+	s.mtx.RLock()
+	h, ok := s.hashes[name]
+	s.mtx.RUnlock()
+	if ok && h.modTime.Equal(stat.ModTime()) && h.size == stat.Size() {
+		return h.sum, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+
+	s.mtx.Lock()
+	s.hashes[name] = fileHash{
+		modTime: stat.ModTime(),
+		size:    stat.Size(),
+		sum:     sum,
+	}
+	s.mtx.Unlock()
+
+	return sum, nil
+}
+
+// BuildManifest walks the file system, and builds the fingerprint manifest.
+func (s *FileServer) BuildManifest() (Manifest, error) {
+	manifest := make(Manifest)
+
+	if err := s.walk("/", func(name string) error {
+		if isCompressed(name) {
+			return nil
+		}
+
+		sum, err := s.Hash(name)
+		if err != nil {
+			return err
+		}
+
+		manifest[strings.TrimPrefix(name, "/")] = strings.TrimPrefix(fingerprint(name, sum), "/")
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	s.manifest = manifest
+	s.mtx.Unlock()
+
+	return manifest, nil
+}
+
+// Manifest returns the fingerprint manifest. The manifest is built on the first call if BuildManifest() has not been called.
+func (s *FileServer) Manifest() (Manifest, error) {
+	s.mtx.RLock()
+	manifest := s.manifest
+	s.mtx.RUnlock()
+
+	if manifest != nil {
+		return manifest, nil
+	}
+
+	return s.BuildManifest()
+}
+
+// URL returns the URL of a file. The fingerprinted name is used if fingerprinting is enabled.
+func (s *FileServer) URL(name string) string {
+	if s.opts.Fingerprint {
+		if manifest, err := s.Manifest(); err == nil {
+			return s.Prefix + "/" + manifest.Path(name)
+		}
+	}
+
+	return s.Prefix + "/" + strings.TrimPrefix(name, "/")
+}
+
+// FuncMap returns the template helpers of the FileServer.
+//
+// The "asset" function resolves a file name to its URL:
+//
+//		<script src="{{ asset "app.js" }}"></script>
+func (s *FileServer) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"asset": s.URL,
+	}
+}
+
+func (s *FileServer) walk(name string, fn func(name string) error) error {
+	f, err := s.fs.Open(name)
+	if err != nil {
+		return err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if !stat.IsDir() {
+		f.Close()
+		return fn(name)
+	}
+
+	children, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+
+	for _, child := range children {
+		if err := s.walk(path.Join(name, child.Name()), fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ServeHTTP serves the file in r.URL.Path.
+func (s *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := cleanPath(r.URL.Path)
+	immutable := false
+
+	f, stat, err := s.open(name)
+	if os.IsNotExist(err) && s.opts.Fingerprint {
+		if original, hash, ok := splitFingerprint(name); ok {
+			if sum, herr := s.Hash(original); herr == nil && sum[:hashLength] == hash {
+				name = original
+				immutable = true
+				f, stat, err = s.open(name)
+			}
+		}
+	}
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer f.Close()
+
+	sum, err := s.hash(name, f, stat)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	if immutable {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(s.opts.MaxAge/time.Second))+", immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	if s.opts.Precompressed && !isCompressed(name) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		for _, enc := range encodings {
+			if !acceptsEncoding(r, enc.name) {
+				continue
+			}
+
+			cf, cstat, cerr := s.open(name + enc.extension)
+			if cerr != nil {
+				continue
+			}
+			defer cf.Close()
+
+			if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+				w.Header().Set("Content-Type", ct)
+			}
+			w.Header().Set("Content-Encoding", enc.name)
+			w.Header().Set("ETag", `"`+sum+"-"+enc.name+`"`)
+			http.ServeContent(w, r, name, cstat.ModTime(), cf)
+
+			return
+		}
+	}
+
+	w.Header().Set("ETag", `"`+sum+`"`)
+	http.ServeContent(w, r, name, stat.ModTime(), f)
+}
+
+// open opens a file. Directories are resolved to their index.html.
+func (s *FileServer) open(name string) (http.File, os.FileInfo, error) {
+	f, err := s.fs.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	if stat.IsDir() {
+		f.Close()
+		if strings.HasSuffix(name, "/index.html") {
+			return nil, nil, os.ErrNotExist
+		}
+		return s.open(path.Join(name, "index.html"))
+	}
+
+	return f, stat, nil
+}
+
+var _ http.Handler = &DynamicFileServer{}
+
+// DynamicFileServer selects the file system for each request.
+//
+// The FileServers are cached for each file system, if the file system values are comparable (e.g. http.Dir).
+type DynamicFileServer struct {
+	getFileSystem func(*http.Request) http.FileSystem
+	opts          Options
+
+	mtx     sync.Mutex
+	servers map[http.FileSystem]*FileServer
+}
+
+// NewDynamic creates a DynamicFileServer.
+//
+// If getFileSystem returns nil, the response is 404.
+func NewDynamic(getFileSystem func(*http.Request) http.FileSystem, opts Options) *DynamicFileServer {
+	return &DynamicFileServer{
+		getFileSystem: getFileSystem,
+		opts:          opts,
+		servers:       make(map[http.FileSystem]*FileServer),
+	}
+}
+
+// Clear removes the cached FileServers.
+func (d *DynamicFileServer) Clear() {
+	d.mtx.Lock()
+	d.servers = make(map[http.FileSystem]*FileServer)
+	d.mtx.Unlock()
+}
+
+// Get returns the FileServer of a file system.
+func (d *DynamicFileServer) Get(fs http.FileSystem) *FileServer {
+	if !isComparable(fs) {
+		return New(fs, d.opts)
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	s, ok := d.servers[fs]
+	if !ok {
+		s = New(fs, d.opts)
+		d.servers[fs] = s
+	}
+
+	return s
+}
+
+// ServeHTTP serves the file in r.URL.Path from the file system of the request.
+func (d *DynamicFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs := d.getFileSystem(r)
+	if fs == nil {
+		http.Error(w, "file system not found", http.StatusNotFound)
+		return
+	}
+
+	d.Get(fs).ServeHTTP(w, r)
+}
+
+func isComparable(v interface{}) (comparable bool) {
+	defer func() {
+		if recover() != nil {
+			comparable = false
+		}
+	}()
+
+	return v == v
+}
+
+func cleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+func isCompressed(name string) bool {
+	for _, enc := range encodings {
+		if strings.HasSuffix(name, enc.extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fingerprint inserts the hash before the extension of the file name.
+func fingerprint(name, sum string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + sum[:hashLength] + ext
+}
+
+// splitFingerprint extracts the original file name and the hash from a fingerprinted file name.
+func splitFingerprint(name string) (string, string, bool) {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	if isHash(ext) {
+		return stem, ext[1:], true
+	}
+
+	if hashExt := path.Ext(stem); isHash(hashExt) {
+		return strings.TrimSuffix(stem, hashExt) + ext, hashExt[1:], true
+	}
+
+	return "", "", false
+}
+
+func isHash(ext string) bool {
+	if len(ext) != hashLength+1 {
+		return false
+	}
+
+	_, err := hex.DecodeString(ext[1:])
+	return err == nil
+}
+
+// acceptsEncoding tells if the client accepts a content encoding.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, part := range strings.Split(header, ",") {
+			fields := strings.Split(part, ";")
+			if strings.TrimSpace(fields[0]) != encoding {
+				continue
+			}
+
+			for _, param := range fields[1:] {
+				param = strings.Replace(param, " ", "", -1)
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); strings.HasPrefix(param, "q=") && err == nil && q == 0 {
+					return false
+				}
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func serveError(w http.ResponseWriter, err error) {
+	if os.IsNotExist(err) {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	if os.IsPermission(err) {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+
+	http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assets_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alien-bunny/ab/lib/assets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const appjs = "console.log('hello');"
+
+var _ = Describe("Assets", func() {
+	var dir string
+
+	sum := sha256.Sum256([]byte(appjs))
+	hash := hex.EncodeToString(sum[:])
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "assets")
+		Expect(err).NotTo(HaveOccurred())
+
+		files := map[string]string{
+			"app.js":            appjs,
+			"app.js.br":         "brotli",
+			"app.js.gz":         "gzip",
+			"LICENSE":           "license",
+			"docs/index.html":   "<p>docs</p>",
+			"empty/placeholder": "",
+		}
+		for name, content := range files {
+			Expect(os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	get := func(h http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	It("should serve a file with a strong ETag", func() {
+		s := assets.New(http.Dir(dir), assets.Options{})
+
+		rr := get(s, "/app.js", nil)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(Equal(appjs))
+		Expect(rr.Header().Get("ETag")).To(Equal(`"` + hash + `"`))
+		Expect(rr.Header().Get("Cache-Control")).To(Equal("no-cache"))
+
+		rr = get(s, "/app.js", map[string]string{"If-None-Match": `"` + hash + `"`})
+		Expect(rr.Code).To(Equal(http.StatusNotModified))
+	})
+
+	It("should not list directories", func() {
+		s := assets.New(http.Dir(dir), assets.Options{})
+
+		Expect(get(s, "/empty/", nil).Code).To(Equal(http.StatusNotFound))
+		Expect(get(s, "/nonexistent.js", nil).Code).To(Equal(http.StatusNotFound))
+
+		rr := get(s, "/docs/", nil)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(Equal("<p>docs</p>"))
+	})
+
+	It("should serve the precompressed variants", func() {
+		s := assets.New(http.Dir(dir), assets.Options{Precompressed: true})
+
+		rr := get(s, "/app.js", map[string]string{"Accept-Encoding": "gzip, br"})
+		Expect(rr.Body.String()).To(Equal("brotli"))
+		Expect(rr.Header().Get("Content-Encoding")).To(Equal("br"))
+		Expect(rr.Header().Get("Content-Type")).To(Equal(mime.TypeByExtension(".js")))
+		Expect(rr.Header().Get("ETag")).To(Equal(`"` + hash + `-br"`))
+		Expect(rr.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+
+		rr = get(s, "/app.js", map[string]string{"Accept-Encoding": "gzip, br;q=0"})
+		Expect(rr.Body.String()).To(Equal("gzip"))
+		Expect(rr.Header().Get("Content-Encoding")).To(Equal("gzip"))
+
+		rr = get(s, "/app.js", nil)
+		Expect(rr.Body.String()).To(Equal(appjs))
+		Expect(rr.Header().Get("Content-Encoding")).To(BeEmpty())
+	})
+
+	It("should serve the fingerprinted files", func() {
+		s := assets.New(http.Dir(dir), assets.Options{Fingerprint: true})
+		s.Prefix = "/assets"
+
+		manifest, err := s.BuildManifest()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest).NotTo(HaveKey("app.js.gz"))
+		Expect(manifest.Path("app.js")).To(Equal("app." + hash[:10] + ".js"))
+		Expect(manifest.Path("LICENSE")).To(MatchRegexp(`^LICENSE\.[0-9a-f]{10}$`))
+		Expect(manifest.Path("unknown.css")).To(Equal("unknown.css"))
+
+		buf := &strings.Builder{}
+		tpl := template.Must(template.New("").Funcs(s.FuncMap()).Parse(`{{ asset "app.js" }}`))
+		Expect(tpl.Execute(buf, nil)).To(Succeed())
+		Expect(buf.String()).To(Equal("/assets/app." + hash[:10] + ".js"))
+
+		rr := get(s, "/"+manifest.Path("app.js"), nil)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(Equal(appjs))
+		Expect(rr.Header().Get("Cache-Control")).To(Equal("public, max-age=31536000, immutable"))
+
+		rr = get(s, "/"+manifest.Path("LICENSE"), nil)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(Equal("license"))
+
+		Expect(get(s, "/app.0123456789.js", nil).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should select the file system for each request", func() {
+		s := assets.NewDynamic(func(r *http.Request) http.FileSystem {
+			if r.Host == "nofs" {
+				return nil
+			}
+
+			return http.Dir(dir)
+		}, assets.Options{})
+
+		Expect(get(s, "/app.js", nil).Body.String()).To(Equal(appjs))
+		Expect(s.Get(http.Dir(dir))).To(BeIdenticalTo(s.Get(http.Dir(dir))))
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		req.Host = "nofs"
+		s.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -16,8 +16,11 @@ package certcache
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"sync"
+	"time"
 
+	"github.com/alien-bunny/ab/lib/errors"
 	"github.com/alien-bunny/ab/lib/log"
 )
 
@@ -88,3 +91,40 @@ func (c *CertCache) load(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 
 	return &kp, nil
 }
+
+// Check loads the certificates of the server names, and verifies that they are valid at the moment.
+//
+// The result contains an error (or nil) for each server name.
+func (c *CertCache) Check(serverNames ...string) map[string]error {
+	results := make(map[string]error, len(serverNames))
+	now := time.Now()
+
+	for _, serverName := range serverNames {
+		cert, err := c.load(&tls.ClientHelloInfo{ServerName: serverName})
+		if err != nil {
+			results[serverName] = err
+			continue
+		}
+
+		if len(cert.Certificate) == 0 {
+			results[serverName] = errors.New("empty certificate chain")
+			continue
+		}
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			results[serverName] = err
+			continue
+		}
+
+		if now.Before(leaf.NotBefore) {
+			results[serverName] = errors.New("certificate is not valid yet")
+		} else if now.After(leaf.NotAfter) {
+			results[serverName] = errors.New("certificate has expired")
+		} else {
+			results[serverName] = nil
+		}
+	}
+
+	return results
+}
@@ -82,6 +82,14 @@ var _ = Describe("Certcache", func() {
 		Expect(err).To(HaveOccurred())
 	})
 
+	It("should check the availability of the certificates", func() {
+		results := cc.Check("0.example.com", "xxx.example.com", "error.example.com")
+		Expect(results).To(HaveLen(3))
+		Expect(results["0.example.com"]).NotTo(HaveOccurred())
+		Expect(results["xxx.example.com"]).To(HaveOccurred())
+		Expect(results["error.example.com"]).To(HaveOccurred())
+	})
+
 	It("should return the value from cache", func() {
 		cert, err := cc.Get(&tls.ClientHelloInfo{
 			ServerName: "1.example.com",
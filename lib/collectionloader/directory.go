@@ -17,11 +17,14 @@ package collectionloader
 import (
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/alien-bunny/ab/lib/config"
 )
 
+var _ config.DirectoryCollectionLoader = &Directory{}
+
 type Directory struct {
 	base     string
 	conf     map[string]string
@@ -36,6 +39,24 @@ func NewDirectory(base string, conf map[string]string, readOnly bool) *Directory
 	}
 }
 
+// Base returns the directory that contains the site directories.
+func (d *Directory) Base() string {
+	return d.base
+}
+
+// Namespaces returns the namespaces that are loaded from a site directory: the name of the directory and its aliases.
+func (d *Directory) Namespaces(dir string) []string {
+	namespaces := []string{dir}
+	for name, alias := range d.conf {
+		if alias == dir && name != dir {
+			namespaces = append(namespaces, name)
+		}
+	}
+	sort.Strings(namespaces[1:])
+
+	return namespaces
+}
+
 func (d *Directory) Load(name string) (*config.Collection, error) {
 	if alias, found := d.conf[name]; found {
 		name = alias
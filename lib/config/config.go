@@ -16,6 +16,7 @@ package config
 
 import (
 	"reflect"
+	"sort"
 	"sync"
 
 	"github.com/alien-bunny/ab/lib/errors"
@@ -130,6 +131,44 @@ func (s *Store) ClearAllCaches() {
 	}
 }
 
+// ClearCache clears the cache of a loaded namespace.
+func (s *Store) ClearCache(namespace string) {
+	s.mtx.RLock()
+	collection := s.namespaces[namespace]
+	s.mtx.RUnlock()
+
+	if collection != nil {
+		collection.ClearCache()
+	}
+}
+
+// Load loads a namespace with the collection loaders, unless it is already loaded. It returns false if the namespace
+// is not found.
+func (s *Store) Load(namespace string) bool {
+	return s.ensureNamespace(namespace) != nil
+}
+
+// Evict removes a loaded namespace. The collection loaders will load it again when it is requested.
+func (s *Store) Evict(namespace string) {
+	s.mtx.Lock()
+	delete(s.namespaces, namespace)
+	s.mtx.Unlock()
+}
+
+// Namespaces returns the names of the loaded namespaces.
+func (s *Store) Namespaces() []string {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	namespaces := make([]string, 0, len(s.namespaces))
+	for namespace := range s.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	return namespaces
+}
+
 func (s *Store) ensureNamespace(namespace string) *Collection {
 	s.mtx.RLock()
 	collection, exists := s.namespaces[namespace]
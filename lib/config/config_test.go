@@ -21,8 +21,12 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/alien-bunny/ab/lib/collectionloader"
 	"github.com/alien-bunny/ab/lib/config"
+	"github.com/alien-bunny/ab/lib/event"
 	"github.com/alien-bunny/ab/lib/log"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -215,6 +219,100 @@ var _ = Describe("Schema can't be added twice", func() {
 	})
 })
 
+var _ = Describe("Watcher", func() {
+	DescribeTable("reload the changed configuration",
+		func(poll bool) {
+			tmpdir, err := ioutil.TempDir("", "abtest")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			confdir := filepath.Join(tmpdir, "config")
+			sitesdir := filepath.Join(tmpdir, "sites")
+			Expect(os.Mkdir(confdir, 0755)).To(Succeed())
+			Expect(os.Mkdir(sitesdir, 0755)).To(Succeed())
+			write := func(fn, content string) {
+				Expect(ioutil.WriteFile(fn, []byte(content), 0644)).To(Succeed())
+			}
+			write(filepath.Join(confdir, "test.json"), `{"A": 1}`)
+
+			logger := log.NewDevLogger(ioutil.Discard)
+			c := config.NewStore(logger)
+			c.RegisterSchema("test", reflect.TypeOf(test{}))
+			dp := config.NewDirectoryConfigProvider(confdir, true)
+			registerFileTypes(dp)
+			collection := config.NewCollection()
+			collection.AddProviders(dp)
+			c.AddCollection("config", collection)
+			loader := collectionloader.NewDirectory(sitesdir, map[string]string{
+				"alias": "site",
+			}, true)
+			c.AddCollectionLoaders(loader)
+
+			var mtx sync.Mutex
+			var changes []string
+			dispatcher := event.NewDispatcher()
+			dispatcher.Subscribe(config.EventConfigChanged, event.SubscriberFunc(func(e event.Event) error {
+				ce := e.(*config.ConfigChangedEvent)
+				mtx.Lock()
+				changes = append(changes, ce.Namespace()+":"+ce.Key())
+				mtx.Unlock()
+				return nil
+			}))
+			consumeChanges := func() []string {
+				mtx.Lock()
+				defer mtx.Unlock()
+				ret := changes
+				changes = nil
+				return ret
+			}
+			getA := func(namespace string) func() int {
+				return func() int {
+					v, err := c.Get(namespace).Get("test")
+					Expect(err).NotTo(HaveOccurred())
+					return v.(test).A
+				}
+			}
+
+			watcher := config.NewWatcher(c, dispatcher, logger).
+				SetPolling(poll).
+				SetPollInterval(10*time.Millisecond).
+				WatchProvider("config", dp).
+				WatchLoader(loader)
+			watcher.Start()
+			defer watcher.Stop()
+
+			Expect(getA("config")()).To(Equal(1))
+
+			By("changing a file")
+			write(filepath.Join(confdir, "test.json"), `{"A": 22}`)
+			Eventually(consumeChanges).Should(Equal([]string{"config:test"}))
+			Expect(getA("config")()).To(Equal(22))
+
+			By("adding a site")
+			Expect(os.Mkdir(filepath.Join(tmpdir, "site"), 0755)).To(Succeed())
+			write(filepath.Join(tmpdir, "site", "test.json"), `{"A": 3}`)
+			Expect(os.Rename(filepath.Join(tmpdir, "site"), filepath.Join(sitesdir, "site"))).To(Succeed())
+			Eventually(consumeChanges).Should(Equal([]string{"alias:", "site:"}))
+			Expect(c.Namespaces()).To(ContainElement("site"))
+			Expect(getA("alias")()).To(Equal(3))
+
+			By("changing a file of the site")
+			write(filepath.Join(sitesdir, "site", "test.json"), `{"A": 44}`)
+			Eventually(consumeChanges).Should(Equal([]string{"alias:test", "site:test"}))
+			Expect(getA("alias")()).To(Equal(44))
+			Expect(getA("site")()).To(Equal(44))
+
+			By("removing the site")
+			Expect(os.RemoveAll(filepath.Join(sitesdir, "site"))).To(Succeed())
+			Eventually(consumeChanges).Should(Equal([]string{"alias:", "site:"}))
+			Expect(c.Namespaces()).NotTo(ContainElement("site"))
+			Expect(c.Get("site")).To(BeNil())
+		},
+		Entry("file system notifications", false),
+		Entry("polling", true),
+	)
+})
+
 func testExample() test {
 	example := test{
 		A: 5,
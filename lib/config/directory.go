@@ -19,6 +19,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/alien-bunny/ab/lib/errors"
 )
@@ -66,6 +67,19 @@ func (d *DirectoryConfigProvider) exists(key string) (FileType, string) {
 	return nil, ""
 }
 
+// keyForFile returns the key of a file in the base directory, or an empty string if the file type is not registered.
+func (d *DirectoryConfigProvider) keyForFile(name string) string {
+	for _, t := range d.fileTypes {
+		for _, ext := range t.Extensions() {
+			if strings.HasSuffix(name, "."+ext) {
+				return strings.TrimSuffix(name, "."+ext)
+			}
+		}
+	}
+
+	return ""
+}
+
 func (d *DirectoryConfigProvider) Has(key string) bool {
 	_, fn := d.exists(key)
 	return fn != ""
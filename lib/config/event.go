@@ -0,0 +1,60 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"github.com/alien-bunny/ab/lib/event"
+)
+
+// EventConfigChanged is dispatched by the Watcher when a configuration file changes.
+const EventConfigChanged = "config-changed"
+
+var _ event.Event = &ConfigChangedEvent{}
+
+// ConfigChangedEvent is dispatched when a configuration file changes, or a namespace is added or removed.
+//
+// The cache of the namespace is already cleared when the event is dispatched, so the subscribers see the new values.
+type ConfigChangedEvent struct {
+	namespace string
+	key       string
+}
+
+// NewConfigChangedEvent creates a ConfigChangedEvent. An empty key means that the whole namespace is changed.
+func NewConfigChangedEvent(namespace, key string) *ConfigChangedEvent {
+	return &ConfigChangedEvent{
+		namespace: namespace,
+		key:       key,
+	}
+}
+
+// Namespace returns the changed namespace.
+func (e *ConfigChangedEvent) Namespace() string {
+	return e.namespace
+}
+
+// Key returns the changed key. It is empty if the namespace is added or removed.
+func (e *ConfigChangedEvent) Key() string {
+	return e.key
+}
+
+// Name of the event. Always returns EventConfigChanged.
+func (e *ConfigChangedEvent) Name() string {
+	return EventConfigChanged
+}
+
+// ErrorStrategy of the event. Always returns event.ErrorStrategyAggregate.
+func (e *ConfigChangedEvent) ErrorStrategy() event.ErrorStrategy {
+	return event.ErrorStrategyAggregate
+}
@@ -0,0 +1,404 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/event"
+	"github.com/alien-bunny/ab/lib/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// DefaultPollInterval is the interval of the polling when the file system notifications are not available.
+	DefaultPollInterval = 2 * time.Second
+
+	// watchDelay collects the notifications of a change, since saving a file usually causes multiple notifications.
+	watchDelay = 100 * time.Millisecond
+)
+
+// DirectoryCollectionLoader is a CollectionLoader that loads the namespaces from the subdirectories of a directory.
+type DirectoryCollectionLoader interface {
+	CollectionLoader
+	// Base returns the directory that contains the namespace directories.
+	Base() string
+	// Namespaces returns the namespaces that are loaded from a subdirectory of the base directory.
+	Namespaces(dir string) []string
+}
+
+type watchedProvider struct {
+	namespace string
+	provider  *DirectoryConfigProvider
+}
+
+// watchedFile is an entry of a file system snapshot.
+type watchedFile struct {
+	dir        bool
+	modTime    time.Time
+	size       int64
+	namespaces []string
+	key        string
+}
+
+// Watcher reloads the configuration when the files of the watched DirectoryConfigProviders and
+// DirectoryCollectionLoaders change.
+//
+// The watcher uses file system notifications (inotify on Linux), and falls back to polling when they are not
+// available. When a file changes, the cache of its namespace is cleared. When a namespace directory of a
+// DirectoryCollectionLoader is added or removed, the namespace is loaded or evicted. In both cases a
+// ConfigChangedEvent is dispatched.
+type Watcher struct {
+	store        *Store
+	dispatcher   *event.Dispatcher
+	logger       log.Logger
+	pollInterval time.Duration
+	poll         bool
+	providers    []watchedProvider
+	loaders      []DirectoryCollectionLoader
+	files        map[string]watchedFile
+	notify       *fsnotify.Watcher
+	watched      map[string]bool
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// NewWatcher creates a Watcher. The dispatcher is optional.
+func NewWatcher(store *Store, dispatcher *event.Dispatcher, logger log.Logger) *Watcher {
+	return &Watcher{
+		store:        store,
+		dispatcher:   dispatcher,
+		logger:       logger,
+		pollInterval: DefaultPollInterval,
+		watched:      make(map[string]bool),
+	}
+}
+
+// SetPollInterval sets the interval of the polling.
+func (w *Watcher) SetPollInterval(interval time.Duration) *Watcher {
+	w.pollInterval = interval
+	return w
+}
+
+// SetPolling disables the file system notifications. This is useful for the network file systems, where the
+// notifications do not work.
+func (w *Watcher) SetPolling(poll bool) *Watcher {
+	w.poll = poll
+	return w
+}
+
+// WatchProvider watches the files of a DirectoryConfigProvider that provides a namespace.
+func (w *Watcher) WatchProvider(namespace string, provider *DirectoryConfigProvider) *Watcher {
+	w.providers = append(w.providers, watchedProvider{
+		namespace: namespace,
+		provider:  provider,
+	})
+	return w
+}
+
+// WatchLoader watches the namespace directories of a DirectoryCollectionLoader.
+func (w *Watcher) WatchLoader(loader DirectoryCollectionLoader) *Watcher {
+	w.loaders = append(w.loaders, loader)
+	return w
+}
+
+// Start starts watching the files in the background.
+func (w *Watcher) Start() {
+	w.files = w.scan()
+
+	var tick <-chan time.Time
+	if !w.poll {
+		notify, err := fsnotify.NewWatcher()
+		if err == nil {
+			w.notify = notify
+			err = w.addWatches()
+		}
+		if err != nil {
+			log.Warn(w.logger).Log("config watcher", "file system notifications are not available, polling", "error", err)
+			if w.notify != nil {
+				w.notify.Close()
+				w.notify = nil
+			}
+		}
+	}
+
+	var ticker *time.Ticker
+	if w.notify == nil {
+		ticker = time.NewTicker(w.pollInterval)
+		tick = ticker.C
+	}
+
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.run(tick, ticker)
+}
+
+// Stop stops the watcher.
+func (w *Watcher) Stop() {
+	if w.stop == nil {
+		return
+	}
+
+	close(w.stop)
+	<-w.done
+	w.stop = nil
+}
+
+func (w *Watcher) run(tick <-chan time.Time, ticker *time.Ticker) {
+	defer close(w.done)
+
+	if ticker != nil {
+		defer ticker.Stop()
+	}
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if w.notify != nil {
+		defer w.notify.Close()
+		events = w.notify.Events
+		errs = w.notify.Errors
+	}
+
+	var delay <-chan time.Time
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-events:
+			if delay == nil {
+				delay = time.After(watchDelay)
+			}
+		case err := <-errs:
+			log.Warn(w.logger).Log("config watcher", "notification error", "error", err)
+		case <-delay:
+			delay = nil
+			w.rescan()
+		case <-tick:
+			w.rescan()
+		}
+	}
+}
+
+// watchedDirs returns the directories that contain the watched files. The file system notifications are not
+// recursive, so every namespace directory is watched separately.
+func (w *Watcher) watchedDirs() map[string]bool {
+	dirs := make(map[string]bool)
+	for _, p := range w.providers {
+		dirs[p.provider.base] = true
+	}
+	for _, l := range w.loaders {
+		dirs[l.Base()] = true
+	}
+	for path, f := range w.files {
+		if f.dir {
+			dirs[path] = true
+		}
+	}
+
+	return dirs
+}
+
+// addWatches adds the new watched directories to the file system notifications.
+func (w *Watcher) addWatches() error {
+	dirs := w.watchedDirs()
+	for dir := range w.watched {
+		if !dirs[dir] {
+			delete(w.watched, dir)
+		}
+	}
+
+	for _, dir := range sortedKeys(dirs) {
+		if w.watched[dir] {
+			continue
+		}
+		if err := w.notify.Add(dir); err != nil {
+			return err
+		}
+		w.watched[dir] = true
+	}
+
+	return nil
+}
+
+// rescan compares the files with the previous snapshot, and applies the changes.
+func (w *Watcher) rescan() {
+	files := w.scan()
+	previous := w.files
+	w.files = files
+
+	if w.notify != nil {
+		if err := w.addWatches(); err != nil {
+			log.Warn(w.logger).Log("config watcher", "failed to watch directory", "error", err)
+		}
+	}
+
+	added := make(map[string]bool)
+	removed := make(map[string]bool)
+	changed := make(map[string]map[string]bool)
+	markChanged := func(f watchedFile) {
+		for _, namespace := range f.namespaces {
+			if changed[namespace] == nil {
+				changed[namespace] = make(map[string]bool)
+			}
+			changed[namespace][f.key] = true
+		}
+	}
+
+	for path, f := range files {
+		old, exists := previous[path]
+		switch {
+		case !exists && f.dir:
+			for _, namespace := range f.namespaces {
+				added[namespace] = true
+			}
+		case !exists || (!f.dir && (!old.modTime.Equal(f.modTime) || old.size != f.size)):
+			markChanged(f)
+		}
+	}
+
+	for path, f := range previous {
+		if _, exists := files[path]; exists {
+			continue
+		}
+		if f.dir {
+			for _, namespace := range f.namespaces {
+				removed[namespace] = true
+			}
+		} else {
+			markChanged(f)
+		}
+	}
+
+	for _, namespace := range sortedKeys(removed) {
+		log.Debug(w.logger).Log("config watcher", "namespace removed", "namespace", namespace)
+		w.store.Evict(namespace)
+		w.dispatch(namespace, "")
+	}
+
+	for _, namespace := range sortedKeys(added) {
+		log.Debug(w.logger).Log("config watcher", "namespace added", "namespace", namespace)
+		if !w.store.Load(namespace) {
+			log.Warn(w.logger).Log("config watcher", "failed to load namespace", "namespace", namespace)
+		}
+		w.dispatch(namespace, "")
+	}
+
+	namespaces := make([]string, 0, len(changed))
+	for namespace := range changed {
+		if !added[namespace] && !removed[namespace] {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		w.store.ClearCache(namespace)
+		for _, key := range sortedKeys(changed[namespace]) {
+			log.Debug(w.logger).Log("config watcher", "config changed", "namespace", namespace, "key", key)
+			w.dispatch(namespace, key)
+		}
+	}
+}
+
+func (w *Watcher) dispatch(namespace, key string) {
+	if w.dispatcher == nil {
+		return
+	}
+
+	if errs := w.dispatcher.Dispatch(NewConfigChangedEvent(namespace, key)); len(errs) > 0 {
+		log.Error(w.logger).Log("config watcher", "config changed event", "error", errs)
+	}
+}
+
+// scan takes a snapshot of the watched files.
+func (w *Watcher) scan() map[string]watchedFile {
+	files := make(map[string]watchedFile)
+
+	for _, p := range w.providers {
+		w.scanDir(files, p.provider.base, []string{p.namespace}, p.provider.keyForFile)
+	}
+
+	for _, l := range w.loaders {
+		base := l.Base()
+		infos, err := ioutil.ReadDir(base)
+		if err != nil {
+			log.Warn(w.logger).Log("config watcher", "failed to read directory", "error", err)
+			continue
+		}
+
+		for _, info := range infos {
+			if !info.IsDir() || ignoredFile(info.Name()) {
+				continue
+			}
+
+			dir := filepath.Join(base, info.Name())
+			namespaces := l.Namespaces(info.Name())
+			files[dir] = watchedFile{
+				dir:        true,
+				namespaces: namespaces,
+			}
+			w.scanDir(files, dir, namespaces, func(name string) string {
+				return strings.TrimSuffix(name, filepath.Ext(name))
+			})
+		}
+	}
+
+	return files
+}
+
+func (w *Watcher) scanDir(files map[string]watchedFile, dir string, namespaces []string, key func(name string) string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Warn(w.logger).Log("config watcher", "failed to read directory", "error", err)
+		return
+	}
+
+	for _, info := range infos {
+		if info.IsDir() || ignoredFile(info.Name()) {
+			continue
+		}
+
+		k := key(info.Name())
+		if k == "" {
+			continue
+		}
+
+		files[filepath.Join(dir, info.Name())] = watchedFile{
+			modTime:    info.ModTime(),
+			size:       info.Size(),
+			namespaces: namespaces,
+			key:        k,
+		}
+	}
+}
+
+// ignoredFile tells if a file is hidden or a backup, e.g. the swap files of the editors.
+func ignoredFile(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~")
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
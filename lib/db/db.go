@@ -15,6 +15,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net"
@@ -55,6 +56,62 @@ type DB interface {
 	Prepare(string) (*sql.Stmt, error)
 }
 
+// ContextDB is the context-aware counterpart of DB. It is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type ContextDB interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+}
+
+type contextDB struct {
+	ctx  context.Context
+	conn DB
+	cdb  ContextDB
+}
+
+func (c *contextDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.cdb.ExecContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.cdb.QueryContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.cdb.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) Prepare(query string) (*sql.Stmt, error) {
+	return c.cdb.PrepareContext(c.ctx, query)
+}
+
+// WithContext binds a context to a connection.
+//
+// The queries of the returned DB are cancelled when the context is done, e.g. when the deadline of a request is exceeded.
+// If conn does not support contexts, it is returned as is.
+func WithContext(ctx context.Context, conn DB) DB {
+	conn = Unwrap(conn)
+	if cdb, ok := conn.(ContextDB); ok {
+		return &contextDB{
+			ctx:  ctx,
+			conn: conn,
+			cdb:  cdb,
+		}
+	}
+
+	return conn
+}
+
+// Unwrap returns the underlying connection (e.g. *sql.DB or *sql.Tx) of a DB returned by WithContext().
+func Unwrap(conn DB) DB {
+	if c, ok := conn.(*contextDB); ok {
+		return c.conn
+	}
+
+	return conn
+}
+
 func ConnectToDB(connectString string) (*sql.DB, error) {
 	return sql.Open("postgres", connectString)
 }
@@ -0,0 +1,124 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// The parameters of the new argon2id hashes. The defaults are the second recommended option of RFC 9106.
+var (
+	ARGON2_TIME        uint32 = 3
+	ARGON2_MEMORY      uint32 = 64 * 1024
+	ARGON2_THREADS     uint8  = 4
+	ARGON2_SALT_LENGTH        = 16
+	ARGON2_KEY_LENGTH  uint32 = 32
+)
+
+var _ Hasher = &Argon2Hasher{}
+
+// Argon2Hasher hashes with argon2id, using the ARGON2_* parameters.
+type Argon2Hasher struct{}
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (h *Argon2Hasher) Hash(pw string) (string, error) {
+	salt := make([]byte, ARGON2_SALT_LENGTH)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(pw), salt, ARGON2_TIME, ARGON2_MEMORY, ARGON2_THREADS, ARGON2_KEY_LENGTH)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		ARGON2_MEMORY, ARGON2_TIME, ARGON2_THREADS,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2Hasher) Verify(pw, hash string) (bool, error) {
+	p, err := parseArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(pw), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+
+	return subtle.ConstantTimeCompare(p.key, key) == 1, nil
+}
+
+func (h *Argon2Hasher) NeedsRehash(hash string) bool {
+	p, err := parseArgon2(hash)
+	if err != nil {
+		return true
+	}
+
+	return p.time < ARGON2_TIME ||
+		p.memory < ARGON2_MEMORY ||
+		p.threads < ARGON2_THREADS ||
+		len(p.salt) < ARGON2_SALT_LENGTH ||
+		uint32(len(p.key)) < ARGON2_KEY_LENGTH
+}
+
+func parseArgon2(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != Argon2ID {
+		return nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version: %d", version)
+	}
+
+	p := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, ErrInvalidHash
+	}
+	if p.time == 0 || p.threads == 0 {
+		return nil, ErrInvalidHash
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if len(p.key) == 0 {
+		return nil, ErrInvalidHash
+	}
+
+	return p, nil
+}
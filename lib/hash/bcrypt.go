@@ -0,0 +1,52 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hash
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BCRYPT_COST is the cost of the new bcrypt hashes.
+var BCRYPT_COST = 12
+
+var _ Hasher = &BcryptHasher{}
+
+// BcryptHasher hashes with bcrypt, using BCRYPT_COST.
+//
+// Bcrypt only uses the first 72 bytes of the password. Longer passwords are rejected instead of being truncated.
+type BcryptHasher struct{}
+
+func (h *BcryptHasher) Hash(pw string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pw), BCRYPT_COST)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(pw, hash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < BCRYPT_COST
+}
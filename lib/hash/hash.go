@@ -12,16 +12,29 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// Package hash hashes and verifies passwords.
+//
+// The hashes are self-describing: the algorithm and its parameters are stored in the hash, so the algorithm or
+// the parameters can be changed without invalidating the existing hashes. The supported formats are:
+//
+//		$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>: argon2id in the PHC string format (the default)
+//		$2a$10$<salt and key>: bcrypt in its standard format
+//		scrypt$<salt>$<n>$<r>$<p>$<key>: scrypt, the original format of this package
+//
+// Use NeedsRehash() after a successful verification to find the hashes that should be upgraded to the default
+// algorithm and parameters.
 package hash
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/scrypt"
 )
@@ -33,21 +46,107 @@ var (
 	PASSWORD_HASH_P           = 1
 	PASSWORD_HASH_KEYLEN      = 64
 
-	hashVerifiers = map[string]func(pw, hash string) (bool, error){
-		"scrypt": scryptVerify,
-	}
+	ErrInvalidHash      = errors.New("invalid hash format")
+	ErrUnknownAlgorithm = errors.New("unknown hash algorithm")
+	ErrVerifyOnly       = errors.New("the hash algorithm can only verify passwords")
+
+	mtx              sync.RWMutex
+	hashers          = map[string]Hasher{}
+	defaultAlgorithm = Argon2ID
+)
+
+// Names of the built-in algorithms.
+const (
+	Argon2ID = "argon2id"
+	Bcrypt   = "bcrypt"
+	Scrypt   = "scrypt"
 )
 
+func init() {
+	Register(Argon2ID, &Argon2Hasher{})
+	bcryptHasher := &BcryptHasher{}
+	Register(Bcrypt, bcryptHasher)
+	Register("2a", bcryptHasher)
+	Register("2b", bcryptHasher)
+	Register("2y", bcryptHasher)
+	Register(Scrypt, &ScryptHasher{})
+}
+
+// Hasher is a password hashing algorithm.
+type Hasher interface {
+	// Hash hashes a password with a random salt.
+	Hash(pw string) (string, error)
+	// Verify checks a password against a hash. The comparison must take constant time.
+	Verify(pw, hash string) (bool, error)
+	// NeedsRehash checks if a hash was created with weaker parameters than the current ones.
+	NeedsRehash(hash string) bool
+}
+
+// Register adds a hash algorithm.
+//
+// The name is the identifier of the algorithm in the hashes: the part before the first "$", or the part between the
+// first two "$" characters if the hash starts with "$". Registering a name again replaces the algorithm.
+func Register(name string, h Hasher) {
+	mtx.Lock()
+	hashers[name] = h
+	mtx.Unlock()
+}
+
+// SetDefault sets the algorithm that hashes the new passwords.
+func SetDefault(name string) error {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	h, ok := hashers[name]
+	if !ok {
+		return ErrUnknownAlgorithm
+	}
+	if _, ok = h.(verifier); ok {
+		return ErrVerifyOnly
+	}
+
+	defaultAlgorithm = name
+
+	return nil
+}
+
+// DefaultAlgorithm returns the name of the algorithm that hashes the new passwords.
+func DefaultAlgorithm() string {
+	mtx.RLock()
+	defer mtx.RUnlock()
+
+	return defaultAlgorithm
+}
+
+func getHasher(name string) (Hasher, bool) {
+	mtx.RLock()
+	h, ok := hashers[name]
+	mtx.RUnlock()
+
+	return h, ok
+}
+
+// Algorithm returns the name of the algorithm of a hash.
+func Algorithm(hash string) string {
+	if strings.HasPrefix(hash, "$") {
+		hash = hash[1:]
+	}
+
+	parts := strings.SplitN(hash, "$", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+
+	return parts[0]
+}
+
+// DefaultHashPassword hashes a password with the default algorithm.
 func DefaultHashPassword(pw string) (string, error) {
-	return HashPassword(pw,
-		PASSWORD_HASH_SALT_LENGTH,
-		PASSWORD_HASH_N,
-		PASSWORD_HASH_R,
-		PASSWORD_HASH_P,
-		PASSWORD_HASH_KEYLEN,
-	)
+	h, _ := getHasher(DefaultAlgorithm())
+	return h.Hash(pw)
 }
 
+// HashPassword hashes a password with scrypt and the given parameters.
 func HashPassword(pw string, saltlen, n, r, p, keylen int) (string, error) {
 	salt := make([]byte, saltlen)
 	_, err := io.ReadFull(rand.Reader, salt)
@@ -67,65 +166,133 @@ func HashPassword(pw string, saltlen, n, r, p, keylen int) (string, error) {
 	), nil
 }
 
+// VerifyPassword checks a password against a hash of any registered algorithm.
 func VerifyPassword(pw, hash string) (bool, error) {
-	parts := strings.SplitN(hash, "$", 2)
-	if len(parts) != 2 {
-		return false, errors.New("invalid hash")
+	alg := Algorithm(hash)
+	if alg == "" {
+		return false, ErrInvalidHash
 	}
 
-	alg := parts[0]
-
-	if fn, ok := hashVerifiers[alg]; ok {
-		return fn(pw, hash)
-	} else {
-		return false, errors.New("unknown hash algorithm: " + alg)
+	if h, ok := getHasher(alg); ok {
+		return h.Verify(pw, hash)
 	}
+
+	return false, errors.New("unknown hash algorithm: " + alg)
 }
 
-func scryptVerify(pw, hash string) (bool, error) {
-	parts := strings.Split(hash, "$")
-	if len(parts) != 6 {
-		return false, errors.New("invalid hash format")
+// NeedsRehash checks if a hash should be replaced with a new hash of the same password.
+//
+// This is the case when the hash was made with an algorithm other than the default one, or with weaker parameters.
+// Call it after a successful verification, when the plain text password is available.
+func NeedsRehash(hash string) bool {
+	alg := Algorithm(hash)
+	h, ok := getHasher(alg)
+	if !ok {
+		return true
 	}
 
-	salt, err := hex.DecodeString(parts[1])
-	if err != nil {
-		return false, err
+	if _, ok = h.(verifier); ok {
+		return true
 	}
 
-	n, err := strconv.Atoi(parts[2])
-	if err != nil {
-		return false, err
+	def, _ := getHasher(DefaultAlgorithm())
+	if h != def {
+		return true
 	}
 
-	r, err := strconv.Atoi(parts[3])
-	if err != nil {
-		return false, err
-	}
+	return h.NeedsRehash(hash)
+}
+
+type verifier func(pw, hash string) (bool, error)
+
+func (v verifier) Hash(pw string) (string, error) {
+	return "", ErrVerifyOnly
+}
+
+func (v verifier) Verify(pw, hash string) (bool, error) {
+	return v(pw, hash)
+}
+
+func (v verifier) NeedsRehash(hash string) bool {
+	return true
+}
+
+// NewVerifier creates a Hasher that can only verify passwords.
+//
+// This is meant for migrating the hashes of imported sites (e.g. salted MD5 or PHPass): register the verifier, and
+// the hashes are replaced on the next login of the users, because NeedsRehash() is always true for them. The
+// function must compare in constant time, e.g. with crypto/subtle.
+func NewVerifier(verify func(pw, hash string) (bool, error)) Hasher {
+	return verifier(verify)
+}
+
+var _ Hasher = &ScryptHasher{}
+
+// ScryptHasher hashes with scrypt, using the PASSWORD_HASH_* parameters.
+type ScryptHasher struct{}
 
-	p, err := strconv.Atoi(parts[4])
+func (h *ScryptHasher) Hash(pw string) (string, error) {
+	return HashPassword(pw,
+		PASSWORD_HASH_SALT_LENGTH,
+		PASSWORD_HASH_N,
+		PASSWORD_HASH_R,
+		PASSWORD_HASH_P,
+		PASSWORD_HASH_KEYLEN,
+	)
+}
+
+func (h *ScryptHasher) Verify(pw, hash string) (bool, error) {
+	salt, n, r, p, pwhash, err := parseScrypt(hash)
 	if err != nil {
 		return false, err
 	}
 
-	pwhash, err := hex.DecodeString(parts[5])
+	newhash, err := scrypt.Key([]byte(pw), salt, n, r, p, len(pwhash))
 	if err != nil {
 		return false, err
 	}
 
-	newhash, err := scrypt.Key([]byte(pw), salt, n, r, p, len(pwhash))
+	return subtle.ConstantTimeCompare(pwhash, newhash) == 1, nil
+}
+
+func (h *ScryptHasher) NeedsRehash(hash string) bool {
+	salt, n, r, p, pwhash, err := parseScrypt(hash)
 	if err != nil {
-		return false, err
+		return true
 	}
 
-	if len(pwhash) != len(newhash) {
-		return false, nil
+	return len(salt) < PASSWORD_HASH_SALT_LENGTH ||
+		n < PASSWORD_HASH_N ||
+		r < PASSWORD_HASH_R ||
+		p < PASSWORD_HASH_P ||
+		len(pwhash) < PASSWORD_HASH_KEYLEN
+}
+
+func parseScrypt(hash string) (salt []byte, n, r, p int, pwhash []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != Scrypt {
+		err = ErrInvalidHash
+		return
 	}
 
-	ok := true
-	for i := 0; i < len(pwhash); i++ {
-		ok = ok && (pwhash[i] == newhash[i])
+	if salt, err = hex.DecodeString(parts[1]); err != nil {
+		return
+	}
+	if n, err = strconv.Atoi(parts[2]); err != nil {
+		return
+	}
+	if r, err = strconv.Atoi(parts[3]); err != nil {
+		return
+	}
+	if p, err = strconv.Atoi(parts[4]); err != nil {
+		return
+	}
+	if pwhash, err = hex.DecodeString(parts[5]); err != nil {
+		return
+	}
+	if len(pwhash) == 0 {
+		err = ErrInvalidHash
 	}
 
-	return ok, nil
+	return
 }
@@ -15,8 +15,14 @@
 package hash_test
 
 import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
 	"github.com/alien-bunny/ab/lib/hash"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -30,4 +36,93 @@ var _ = Describe("Hash", func() {
 		Expect(verr).NotTo(HaveOccurred())
 		Expect(ok).To(BeTrue())
 	})
+
+	DescribeTable("the algorithms",
+		func(h hash.Hasher, prefix string) {
+			hashed, err := h.Hash(pw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hashed).To(HavePrefix(prefix))
+
+			ok, err := hash.VerifyPassword(pw, hashed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+
+			ok, err = hash.VerifyPassword(pw+"x", hashed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeFalse())
+
+			Expect(h.NeedsRehash(hashed)).To(BeFalse())
+		},
+		Entry("argon2id", &hash.Argon2Hasher{}, "$argon2id$v=19$m=65536,t=3,p=4$"),
+		Entry("bcrypt", &hash.BcryptHasher{}, "$2a$12$"),
+		Entry("scrypt", &hash.ScryptHasher{}, "scrypt$"),
+	)
+
+	It("should use argon2id by default", func() {
+		Expect(hash.DefaultAlgorithm()).To(Equal(hash.Argon2ID))
+
+		h, err := hash.DefaultHashPassword(pw)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hash.Algorithm(h)).To(Equal(hash.Argon2ID))
+		Expect(hash.NeedsRehash(h)).To(BeFalse())
+	})
+
+	It("should detect the outdated hashes", func() {
+		scrypt, err := hash.HashPassword(pw, 16, 16384, 8, 1, 32)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hash.NeedsRehash(scrypt)).To(BeTrue())
+		Expect((&hash.ScryptHasher{}).NeedsRehash(scrypt)).To(BeTrue())
+
+		bcrypt, err := (&hash.BcryptHasher{}).Hash(pw)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hash.NeedsRehash(bcrypt)).To(BeTrue())
+
+		weak := "$argon2id$v=19$m=4096,t=1,p=1$c2FsdHNhbHRzYWx0$" + strings.Repeat("A", 43)
+		Expect(hash.NeedsRehash(weak)).To(BeTrue())
+
+		Expect(hash.NeedsRehash("unknown$abc")).To(BeTrue())
+		Expect(hash.NeedsRehash("garbage")).To(BeTrue())
+	})
+
+	It("should reject the invalid hashes", func() {
+		_, err := hash.VerifyPassword(pw, "garbage")
+		Expect(err).To(HaveOccurred())
+
+		_, err = hash.VerifyPassword(pw, "unknown$abc")
+		Expect(err).To(HaveOccurred())
+
+		_, err = hash.VerifyPassword(pw, "$argon2id$v=19$m=65536,t=3,p=4$abc")
+		Expect(err).To(HaveOccurred())
+
+		_, err = hash.VerifyPassword(pw, "scrypt$zz$1$1$1$zz")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should switch the default algorithm", func() {
+		Expect(hash.SetDefault("unknown")).To(HaveOccurred())
+		Expect(hash.SetDefault(hash.Bcrypt)).To(Succeed())
+		defer hash.SetDefault(hash.Argon2ID)
+
+		h, err := hash.DefaultHashPassword(pw)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(h).To(HavePrefix("$2a$"))
+		Expect(hash.NeedsRehash(h)).To(BeFalse())
+	})
+
+	It("should migrate custom legacy hashes", func() {
+		hash.Register("md5", hash.NewVerifier(func(pw, h string) (bool, error) {
+			sum := md5.Sum([]byte(pw))
+			expected := "md5$" + hex.EncodeToString(sum[:])
+			return subtle.ConstantTimeCompare([]byte(expected), []byte(h)) == 1, nil
+		}))
+		Expect(hash.SetDefault("md5")).To(MatchError(hash.ErrVerifyOnly))
+
+		sum := md5.Sum([]byte(pw))
+		legacy := "md5$" + hex.EncodeToString(sum[:])
+
+		ok, err := hash.VerifyPassword(pw, legacy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(hash.NeedsRehash(legacy)).To(BeTrue())
+	})
 })
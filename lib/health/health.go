@@ -0,0 +1,198 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health collects health checks, and exposes them as liveness and readiness HTTP endpoints.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/log"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// HealthChecker can be implemented by services and middlewares to take part in the readiness checks.
+type HealthChecker interface {
+	// HealthCheck runs the checks, and returns the result for each named check. A nil error means that the check has passed.
+	HealthCheck(ctx context.Context) map[string]error
+}
+
+// CheckerFunc is a HealthChecker that is a function.
+type CheckerFunc func(ctx context.Context) map[string]error
+
+// HealthCheck runs the function.
+func (f CheckerFunc) HealthCheck(ctx context.Context) map[string]error {
+	return f(ctx)
+}
+
+// CheckResult is the result of a single check.
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report contains the results of all checks.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Healthy tells if all checks have passed.
+func (r Report) Healthy() bool {
+	return r.Status == StatusOK
+}
+
+// Summary returns a copy of the report without the error messages.
+func (r Report) Summary() Report {
+	summary := Report{
+		Status: r.Status,
+		Checks: make(map[string]CheckResult, len(r.Checks)),
+	}
+
+	for name, result := range r.Checks {
+		summary.Checks[name] = CheckResult{
+			Status: result.Status,
+		}
+	}
+
+	return summary
+}
+
+// Registry is a collection of HealthCheckers.
+type Registry struct {
+	mtx      sync.RWMutex
+	checkers []HealthChecker
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Add adds a HealthChecker to the registry.
+func (r *Registry) Add(c HealthChecker) {
+	r.mtx.Lock()
+	r.checkers = append(r.checkers, c)
+	r.mtx.Unlock()
+}
+
+// MaybeAdd adds v to the registry if it implements HealthChecker.
+func (r *Registry) MaybeAdd(v interface{}) {
+	if c, ok := v.(HealthChecker); ok {
+		r.Add(c)
+	}
+}
+
+// Check runs all checks.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mtx.RLock()
+	checkers := make([]HealthChecker, len(r.checkers))
+	copy(checkers, r.checkers)
+	r.mtx.RUnlock()
+
+	report := Report{
+		Status: StatusOK,
+		Checks: make(map[string]CheckResult),
+	}
+
+	for _, c := range checkers {
+		for name, err := range c.HealthCheck(ctx) {
+			if err != nil {
+				report.Status = StatusFail
+				report.Checks[name] = CheckResult{
+					Status: StatusFail,
+					Error:  err.Error(),
+				}
+			} else {
+				report.Checks[name] = CheckResult{
+					Status: StatusOK,
+				}
+			}
+		}
+	}
+
+	return report
+}
+
+// ReadinessHandler returns a handler that runs the checks, and responds with the status of each check.
+//
+// The error messages are not exposed, because the endpoint is public. They are logged with the logger instead.
+// The response status is 503 if any of the checks has failed. The checks are cancelled after the timeout.
+func (r *Registry) ReadinessHandler(timeout time.Duration, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.checkRequest(req, timeout)
+		if logger != nil {
+			for name, result := range report.Checks {
+				if result.Status != StatusOK {
+					log.Error(logger).Log("health", name, "error", result.Error)
+				}
+			}
+		}
+
+		writeJSON(w, reportCode(report), report.Summary())
+	})
+}
+
+// DetailedReadinessHandler returns a handler that runs the checks, and responds with the full report, including the
+// error messages.
+//
+// This handler should only be exposed to administrators.
+func (r *Registry) DetailedReadinessHandler(timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.checkRequest(req, timeout)
+		writeJSON(w, reportCode(report), report)
+	})
+}
+
+func (r *Registry) checkRequest(req *http.Request, timeout time.Duration) Report {
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	return r.Check(ctx)
+}
+
+func reportCode(report Report) int {
+	if !report.Healthy() {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusOK
+}
+
+// LivenessHandler returns a handler that always responds with 200.
+//
+// Liveness means that the process is able to serve requests; it does not depend on external resources.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Report{
+			Status: StatusOK,
+			Checks: map[string]CheckResult{},
+		})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
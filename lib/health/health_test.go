@@ -0,0 +1,103 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/health"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	passing := health.CheckerFunc(func(ctx context.Context) map[string]error {
+		return map[string]error{
+			"a": nil,
+		}
+	})
+
+	failing := health.CheckerFunc(func(ctx context.Context) map[string]error {
+		return map[string]error{
+			"b": errors.New("b is down"),
+		}
+	})
+
+	It("should report ok when all checks pass", func() {
+		r := health.NewRegistry()
+		r.Add(passing)
+		r.MaybeAdd(struct{}{})
+
+		rec := httptest.NewRecorder()
+		r.ReadinessHandler(time.Second, nil).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		report := health.Report{}
+		Expect(json.NewDecoder(rec.Body).Decode(&report)).To(Succeed())
+		Expect(report.Healthy()).To(BeTrue())
+		Expect(report.Checks).To(Equal(map[string]health.CheckResult{
+			"a": {Status: health.StatusOK},
+		}))
+	})
+
+	It("should report the failing checks without the errors", func() {
+		r := health.NewRegistry()
+		r.Add(passing)
+		r.MaybeAdd(failing)
+
+		rec := httptest.NewRecorder()
+		r.ReadinessHandler(time.Second, abtest.GetLogger()).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Body.String()).NotTo(ContainSubstring("b is down"))
+
+		report := health.Report{}
+		Expect(json.NewDecoder(rec.Body).Decode(&report)).To(Succeed())
+		Expect(report.Status).To(Equal(health.StatusFail))
+		Expect(report.Checks).To(Equal(map[string]health.CheckResult{
+			"a": {Status: health.StatusOK},
+			"b": {Status: health.StatusFail},
+		}))
+	})
+
+	It("should report the errors in the detailed report", func() {
+		r := health.NewRegistry()
+		r.Add(passing)
+		r.MaybeAdd(failing)
+
+		rec := httptest.NewRecorder()
+		r.DetailedReadinessHandler(time.Second).ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+
+		report := health.Report{}
+		Expect(json.NewDecoder(rec.Body).Decode(&report)).To(Succeed())
+		Expect(report.Status).To(Equal(health.StatusFail))
+		Expect(report.Checks).To(Equal(map[string]health.CheckResult{
+			"a": {Status: health.StatusOK},
+			"b": {Status: health.StatusFail, Error: "b is down"},
+		}))
+	})
+
+	It("should always be live", func() {
+		rec := httptest.NewRecorder()
+		health.LivenessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+	})
+})
@@ -18,24 +18,37 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"encoding/xml"
+	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/alien-bunny/ab/lib"
 	"github.com/alien-bunny/ab/lib/hal"
+	"github.com/alien-bunny/ab/lib/util"
 	"github.com/golang/gddo/httputil"
 	"github.com/pelletier/go-toml"
 	"gopkg.in/yaml.v2"
 )
 
-const JSONSecurityPrefix = ")]}',\n"
+const (
+	JSONSecurityPrefix = ")]}',\n"
+
+	nonceKey = "abcspnonce"
+)
 
 // JSONPrefix is a global switch for the ")]}',\n" JSON response prefix.
 //
 // This prefix increases security for browser-based applications, but requires extra support on the client side.
 var JSONPrefix = true
 
+// EventStreamHeartbeat is the interval of the heartbeat comments in an event stream.
+//
+// The heartbeats keep the connection open through proxies that close idle connections.
+var EventStreamHeartbeat = 15 * time.Second
+
 // Renderer is a per-request struct for the Render API.
 //
 // The Render API handles content negotiation with the client. The server's preference is the order how the offers are added by either the AddOffer() low-level method or the JSON()/HTML()/Text() higher level methods.
@@ -54,6 +67,7 @@ type Renderer struct {
 	handlers map[string]func(w http.ResponseWriter)
 	offers   []string
 	rendered bool
+	nonce    string
 	Code     int // HTTP status code.
 }
 
@@ -127,9 +141,15 @@ func (r *Renderer) HALJSON(v interface{}) *Renderer {
 }
 
 // HTML adds an HTML offer to the Renderer struct.
+//
+// If v implements NonceSetter, it receives the Content-Security-Policy nonce of the request before the template is
+// executed.
 func (r *Renderer) HTML(t *template.Template, v interface{}) *Renderer {
 	return r.AddOffer("text/html", func(w http.ResponseWriter) {
 		maybeSanitize(v)
+		if ns, ok := v.(NonceSetter); ok {
+			ns.SetNonce(r.nonce)
+		}
 		if terr := t.Execute(w, v); terr != nil {
 			panic(terr)
 		}
@@ -239,6 +259,105 @@ func (r *Renderer) CSVGenerator(recgen func(http.Flusher) ([]string, error)) *Re
 	})
 }
 
+// Event is a message of an event stream.
+//
+// See https://html.spec.whatwg.org/multipage/server-sent-events.html
+type Event struct {
+	// ID is the id of the event. The client sends the last received id in the Last-Event-ID header when it reconnects.
+	ID string
+	// Event is the type of the event. The client dispatches the event with this name. Defaults to "message".
+	Event string
+	// Data is the payload of the event. Multiline data is split into multiple data fields.
+	// Events without data are not dispatched by the client, but their ID and Retry fields are processed.
+	Data string
+	// Retry is the reconnection time that the client should use.
+	Retry time.Duration
+}
+
+// WriteTo writes the event in the text/event-stream format.
+func (e Event) WriteTo(w io.Writer) (int64, error) {
+	buf := strings.Builder{}
+
+	if e.ID != "" {
+		buf.WriteString("id: " + sanitizeEventField(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		buf.WriteString("event: " + sanitizeEventField(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		buf.WriteString(fmt.Sprintf("retry: %d\n", e.Retry/time.Millisecond))
+	}
+	if e.Data != "" {
+		for _, line := range strings.Split(strings.Replace(e.Data, "\r\n", "\n", -1), "\n") {
+			buf.WriteString("data: " + line + "\n")
+		}
+	}
+	buf.WriteString("\n")
+
+	n, err := io.WriteString(w, buf.String())
+
+	return int64(n), err
+}
+
+func sanitizeEventField(field string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(field)
+}
+
+// LastEventID returns the id of the last event that the client has received.
+//
+// Browsers send it in the Last-Event-ID header when they reconnect. The lastEventId query parameter is supported for
+// clients that cannot set headers.
+func LastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+
+	return r.URL.Query().Get("lastEventId")
+}
+
+// EventStream adds a text/event-stream (Server-Sent Events) offer to the Renderer object.
+//
+// The events are streamed through a channel, and each event is flushed to the client immediately.
+// A heartbeat comment is sent in every EventStreamHeartbeat. The stream ends when the channel is closed or when the
+// client is gone. Resuming the stream is up to the producer; see LastEventID().
+func (r *Renderer) EventStream(events <-chan Event) *Renderer {
+	return r.AddOffer("text/event-stream", func(w http.ResponseWriter) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+
+		flush := func() {
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+
+		if _, err := io.WriteString(w, ": stream\n\n"); err != nil {
+			return
+		}
+		flush()
+
+		heartbeat := time.NewTicker(EventStreamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				if _, err := e.WriteTo(w); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			}
+			flush()
+		}
+	})
+}
+
 // maybePrefixCSVField helps avoiding a CSV injection attack.
 //
 // When a field begins with =, -, +, or @, it is possible to coerce
@@ -275,6 +394,8 @@ func (r *Renderer) Render(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	r.nonce = GetNonce(req)
+
 	ct := r.offers[0]
 	if len(r.offers) > 1 {
 		ct = httputil.NegotiateContentType(req, r.offers, ct)
@@ -301,6 +422,43 @@ func (r *Renderer) SetRendered() {
 	r.rendered = true
 }
 
+// WithNonce stores the Content-Security-Policy nonce of the request.
+func WithNonce(r *http.Request, nonce string) *http.Request {
+	return util.SetContext(r, nonceKey, nonce)
+}
+
+// GetNonce returns the Content-Security-Policy nonce of the request.
+//
+// Inline scripts and styles must have this value in their nonce attribute.
+func GetNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceKey).(string)
+	return nonce
+}
+
+// NonceSetter is implemented by the template data that needs the Content-Security-Policy nonce.
+//
+// See Renderer.HTML().
+type NonceSetter interface {
+	SetNonce(nonce string)
+}
+
+// CSPNonce can be embedded into template data to receive the Content-Security-Policy nonce:
+//
+//		type pageData struct {
+//			render.CSPNonce
+//			Title string
+//		}
+//
+//		<script nonce="{{.Nonce}}">...</script>
+type CSPNonce struct {
+	Nonce string
+}
+
+// SetNonce sets the nonce.
+func (n *CSPNonce) SetNonce(nonce string) {
+	n.Nonce = nonce
+}
+
 func maybeSanitize(v interface{}) {
 	if sanitizer, ok := v.(lib.Sanitizer); ok {
 		sanitizer.Sanitize()
@@ -21,6 +21,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	"github.com/alien-bunny/ab/lib/hal"
 	"github.com/alien-bunny/ab/lib/render"
@@ -35,6 +36,11 @@ type test struct {
 	B string
 }
 
+type nonceTest struct {
+	render.CSPNonce
+	B string
+}
+
 type testEL struct {
 	A int
 	B string
@@ -199,6 +205,19 @@ var _ = Describe("Render", func() {
 		})
 	})
 
+	Describe("A render object with an HTML offer that needs a nonce", func() {
+		tpl := template.Must(template.New("test").Parse(`<script nonce="{{.Nonce}}">{{.B}}</script>`))
+		r, rr, req := create()
+		req = render.WithNonce(req, "abcd")
+		r.HTML(tpl, &nonceTest{B: "x"})
+		r.Render(rr, req)
+
+		It("should pass the nonce of the request to the template", func() {
+			Expect(render.GetNonce(req)).To(Equal("abcd"))
+			Expect(string(rr.Body.Bytes())).To(Equal(`<script nonce="abcd">"x"</script>`))
+		})
+	})
+
 	Describe("A render object with a CSV offer", func() {
 		data := [][]string{
 			{"a", "b", "@c"},
@@ -264,6 +283,38 @@ var _ = Describe("Render", func() {
 		})
 	})
 
+	Describe("A render object with an event stream offer", func() {
+		ch := make(chan render.Event)
+		go func() {
+			ch <- render.Event{ID: "1", Event: "update", Data: "a\nb"}
+			ch <- render.Event{Data: "c", Retry: 3 * time.Second}
+			close(ch)
+		}()
+
+		r, rr, req := create()
+		req.Header.Set("Accept", "text/event-stream")
+		r.JSON(t).EventStream(ch)
+		r.Render(rr, req)
+
+		It("should stream the events", func() {
+			Expect(ch).To(BeClosed())
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("Content-Type")).To(Equal("text/event-stream"))
+			Expect(rr.Header().Get("Cache-Control")).To(Equal("no-cache"))
+			Expect(rr.Flushed).To(BeTrue())
+			Expect(string(rr.Body.Bytes())).To(Equal(": stream\n\nid: 1\nevent: update\ndata: a\ndata: b\n\nretry: 3000\ndata: c\n\n"))
+		})
+	})
+
+	Describe("The last event id of a request", func() {
+		It("should be read from the header or the query", func() {
+			req := httptest.NewRequest("GET", "/?lastEventId=5", nil)
+			Expect(render.LastEventID(req)).To(Equal("5"))
+			req.Header.Set("Last-Event-ID", "6")
+			Expect(render.LastEventID(req)).To(Equal("6"))
+		})
+	})
+
 	Describe("A render object with the rendered property set", func() {
 		r, rr, req := create()
 		r.SetRendered()
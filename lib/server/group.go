@@ -0,0 +1,155 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/alien-bunny/ab/lib/middleware"
+)
+
+var _ Registrar = &Group{}
+
+// Group is a set of routes that share a path prefix and a middleware chain.
+//
+// The middlewares of the group are validated against the middleware stack of the parent (the server or the parent group).
+type Group struct {
+	server          *Server
+	parent          *Group
+	prefix          string
+	middlewares     []middleware.Middleware
+	middlewareStack *middleware.Stack
+}
+
+func newGroup(s *Server, parent *Group, prefix string, parentStack *middleware.Stack, middlewares []middleware.Middleware) *Group {
+	g := &Group{
+		server:          s,
+		parent:          parent,
+		prefix:          prefix,
+		middlewareStack: middleware.NewStack(parentStack),
+	}
+
+	if parent != nil {
+		g.middlewares = append(g.middlewares, parent.middlewares...)
+	}
+
+	for _, m := range middlewares {
+		if merr := g.middlewareStack.Push(m); merr != nil {
+			panic(merr)
+		}
+		s.config.MaybeRegisterSchema(m)
+		g.middlewares = append(g.middlewares, m)
+	}
+
+	return g
+}
+
+// Prefix returns the full path prefix of the group.
+func (g *Group) Prefix() string {
+	return g.prefix
+}
+
+// Group creates a nested group.
+//
+// The prefix is relative to the prefix of this group.
+func (g *Group) Group(prefix string, middlewares ...middleware.Middleware) *Group {
+	return newGroup(g.server, g, g.prefix+prefix, g.middlewareStack, middlewares)
+}
+
+func (g *Group) wrap(h http.Handler) http.Handler {
+	h = g.middlewareStack.Wrap(h)
+	if g.parent != nil {
+		return g.parent.wrap(h)
+	}
+
+	return h
+}
+
+// Handle adds a handler to the group.
+//
+// The middleware list will be applied to this handler only.
+func (g *Group) Handle(method, path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return g.server.handle(method, g.prefix+path, handler, g.middlewareStack, g.wrap, g.middlewares, middlewares)
+}
+
+// Head adds a HEAD handler to the group.
+func (g *Group) Head(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("HEAD", path, handler, middlewares...)
+}
+
+// Get adds a GET handler to the group.
+func (g *Group) Get(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("GET", path, handler, middlewares...)
+}
+
+// Post adds a POST handler to the group.
+func (g *Group) Post(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("POST", path, handler, middlewares...)
+}
+
+// Put adds a PUT handler to the group.
+func (g *Group) Put(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("PUT", path, handler, middlewares...)
+}
+
+// Delete adds a DELETE handler to the group.
+func (g *Group) Delete(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("DELETE", path, handler, middlewares...)
+}
+
+// Patch adds a PATCH handler to the group.
+func (g *Group) Patch(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("PATCH", path, handler, middlewares...)
+}
+
+// Options adds an OPTIONS handler to the group.
+func (g *Group) Options(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("OPTIONS", path, handler, middlewares...)
+}
+
+// HeadF adds a HEAD HandlerFunc to the group.
+func (g *Group) HeadF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("HEAD", path, handler, middlewares...)
+}
+
+// GetF adds a GET HandlerFunc to the group.
+func (g *Group) GetF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("GET", path, handler, middlewares...)
+}
+
+// PostF adds a POST HandlerFunc to the group.
+func (g *Group) PostF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("POST", path, handler, middlewares...)
+}
+
+// PutF adds a PUT HandlerFunc to the group.
+func (g *Group) PutF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("PUT", path, handler, middlewares...)
+}
+
+// DeleteF adds a DELETE HandlerFunc to the group.
+func (g *Group) DeleteF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("DELETE", path, handler, middlewares...)
+}
+
+// PatchF adds a PATCH HandlerFunc to the group.
+func (g *Group) PatchF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("PATCH", path, handler, middlewares...)
+}
+
+// OptionsF adds an OPTIONS HandlerFunc to the group.
+func (g *Group) OptionsF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return g.Handle("OPTIONS", path, handler, middlewares...)
+}
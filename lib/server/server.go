@@ -15,17 +15,26 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"io/ioutil"
 	stdlog "log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/alien-bunny/ab/lib/assets"
 	"github.com/alien-bunny/ab/lib/config"
 	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/health"
 	"github.com/alien-bunny/ab/lib/log"
 	"github.com/alien-bunny/ab/lib/middleware"
 	"github.com/alien-bunny/ab/lib/util"
@@ -34,7 +43,10 @@ import (
 	"golang.org/x/crypto/acme/autocert"
 )
 
-const paramKey = "abparam"
+const (
+	paramKey  = "abparam"
+	serverKey = "abserver"
+)
 
 // Service is a collection of endpoints that logically belong together or operate on the same part of the database schema.
 type Service interface {
@@ -44,12 +56,116 @@ type Service interface {
 	Register(*Server) error
 }
 
+// Starter can be implemented by a Service to run code when the server starts.
+//
+// Start is called after the listener of the server is up. The context is cancelled when the server shuts down, so it
+// can be used by the background goroutines of the service. Start itself should return before the startup timeout
+// expires.
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// Stopper can be implemented by a Service to run code when the server stops.
+//
+// Stop is called after the in-flight requests are finished. Services should stop their background goroutines here.
+// The context is cancelled when the shutdown timeout expires.
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
 type ServiceName string
 
 func (n ServiceName) Name() string {
 	return string(n)
 }
 
+// Route describes an endpoint that is registered on the server.
+type Route struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Name is the optional name of the route. Named routes can be used to generate URLs.
+	//
+	// See SetName(), Server.URL() and URLFor().
+	Name string `json:"name,omitempty"`
+	// Service is the name of the service that registered the route. It is empty for routes that are registered outside of a service.
+	Service string `json:"service,omitempty"`
+	// Middlewares is the route-specific middleware chain. The middlewares of the server are not included.
+	//
+	// The middlewares are listed by their types, except the function middlewares (e.g. middleware.Func), which are
+	// listed by the names of their functions.
+	Middlewares []string `json:"middlewares"`
+	// Dependencies are the middleware dependencies that the handler declares with middleware.HasMiddlewareDependencies.
+	Dependencies []string `json:"dependencies"`
+
+	server *Server
+}
+
+// SetName sets the name of the route.
+//
+// The name must be unique on the server.
+func (r *Route) SetName(name string) *Route {
+	if r.server != nil {
+		if _, exists := r.server.names[name]; exists {
+			panic(`route name "` + name + `" is already in use`)
+		}
+		delete(r.server.names, r.Name)
+		r.server.names[name] = r
+	}
+
+	r.Name = name
+
+	return r
+}
+
+// Registrar registers handlers on a router.
+//
+// Both Server and Group implement this interface.
+type Registrar interface {
+	// Prefix returns the path prefix of the handlers registered through this Registrar.
+	Prefix() string
+	Handle(method, path string, handler http.Handler, middlewares ...middleware.Middleware) *Route
+	Head(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route
+	Get(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route
+	Post(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route
+	Put(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route
+	Delete(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route
+	Patch(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route
+	Options(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route
+	HeadF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route
+	GetF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route
+	PostF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route
+	PutF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route
+	DeleteF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route
+	PatchF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route
+	OptionsF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route
+	Group(prefix string, middlewares ...middleware.Middleware) *Group
+}
+
+var _ Registrar = &Server{}
+
+// Limits contains the timeouts and size limits of the HTTP server.
+//
+// See http.Server for the meaning of the fields. Zero values mean no limit.
+type Limits struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+}
+
+// DefaultLimits are the limits of a new Server.
+//
+// WriteTimeout is disabled, because it would cut long-running responses (e.g. event streams and exports).
+// Use timeoutmw to limit the processing time of the requests.
+var DefaultLimits = Limits{
+	ReadTimeout:       time.Minute,
+	ReadHeaderTimeout: 10 * time.Second,
+	WriteTimeout:      0,
+	IdleTimeout:       2 * time.Minute,
+	MaxHeaderBytes:    1 << 20,
+}
+
 // Server is the main server struct.
 type Server struct {
 	Router          *httprouter.Router
@@ -59,7 +175,27 @@ type Server struct {
 	Logger          log.Logger
 	TLSConfig       *tls.Config
 	HTTPServer      *http.Server
+	Limits          Limits
+	Health          *health.Registry
 	services        []Service
+	started         []Service
+	routes          []*Route
+	rawHandlers     map[string]http.Handler
+	fileServers     map[string]*assets.FileServer
+	names           map[string]*Route
+	currentService  string
+	ready           chan struct{}
+	readyOnce       sync.Once
+
+	// BaseURL returns the scheme and the host (e.g. "https://example.com") of the absolute URLs generated by
+	// URLFor(). If it is nil, RequestBaseURL() is used without trusting the proxy headers.
+	BaseURL func(r *http.Request) string
+
+	// BearerAuthenticator validates the tokens of the "Authorization: Bearer" requests. The CSRF middleware of the
+	// server created by ab.Pet() does not check the requests that it accepts. See securitymw.BearerAuthenticator.
+	//
+	// It must be set before the server starts.
+	BearerAuthenticator func(w http.ResponseWriter, r *http.Request, token string) *http.Request
 }
 
 // NewServer creates a new server with a database connection.
@@ -69,6 +205,12 @@ func NewServer(config *config.Store, logger log.Logger) *Server {
 		config:          config,
 		middlewareStack: middleware.NewStack(nil),
 		Logger:          logger,
+		names:           make(map[string]*Route),
+		rawHandlers:     make(map[string]http.Handler),
+		fileServers:     make(map[string]*assets.FileServer),
+		Health:          health.NewRegistry(),
+		Limits:          DefaultLimits,
+		ready:           make(chan struct{}),
 	}
 	s.Router.RedirectTrailingSlash = true
 	s.Router.RedirectFixedPath = true
@@ -102,6 +244,7 @@ func (s *Server) Use(m middleware.Middleware) {
 		panic(merr)
 	}
 	s.config.MaybeRegisterSchema(m)
+	s.Health.MaybeAdd(m)
 }
 
 // UseF adds a middleware function to the top of the middleware stack.
@@ -115,6 +258,7 @@ func (s *Server) UseTop(m middleware.Middleware) {
 		panic(merr)
 	}
 	s.config.MaybeRegisterSchema(m)
+	s.Health.MaybeAdd(m)
 }
 
 // UseTopF adds a middleware function to the bottom of the middleware stack.
@@ -134,14 +278,37 @@ func (s *Server) UseHandler(h http.Handler) {
 
 // Handler creates a http.Handler from the server (using the middlewares and the router).
 func (s *Server) Handler() http.Handler {
-	return s.middlewareStack.Wrap(s.Router)
+	wrapped := s.middlewareStack.Wrap(s.Router)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := s.rawHandlers[r.URL.Path]; ok && (r.Method == "GET" || r.Method == "HEAD") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		wrapped.ServeHTTP(w, r)
+	})
+}
+
+// HandleRaw adds a GET handler that bypasses the middleware stack and the router.
+//
+// The path is matched exactly. This is useful for endpoints that must work without any site configuration,
+// e.g. health checks of a load balancer. Raw handlers must be added before the server starts.
+func (s *Server) HandleRaw(path string, handler http.Handler) *Route {
+	s.rawHandlers[path] = handler
+
+	return s.addRoute("GET", path, handler, nil)
 }
 
 // Handle adds a handler to the router.
 //
 // The middleware list will be applied to this handler only.
-func (s *Server) Handle(method, path string, handler http.Handler, middlewares ...middleware.Middleware) {
-	ms := s.middlewareStack
+func (s *Server) Handle(method, path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return s.handle(method, path, handler, s.middlewareStack, nil, nil, middlewares)
+}
+
+func (s *Server) handle(method, path string, handler http.Handler, stack *middleware.Stack, wrap func(http.Handler) http.Handler, groupMiddlewares, middlewares []middleware.Middleware) *Route {
+	ms := stack
 	h := handler
 
 	// callstack cleanup
@@ -150,7 +317,7 @@ func (s *Server) Handle(method, path string, handler http.Handler, middlewares .
 	}
 
 	if len(middlewares) > 0 {
-		ms = middleware.NewStack(s.middlewareStack)
+		ms = middleware.NewStack(stack)
 		for _, m := range middlewares {
 			if merr := ms.Push(m); merr != nil {
 				panic(merr)
@@ -164,82 +331,153 @@ func (s *Server) Handle(method, path string, handler http.Handler, middlewares .
 		panic(verr)
 	}
 
+	if wrap != nil {
+		h = wrap(h)
+	}
+
 	s.config.MaybeRegisterSchema(handler)
 
+	chain := make([]middleware.Middleware, 0, len(groupMiddlewares)+len(middlewares))
+	chain = append(chain, groupMiddlewares...)
+	chain = append(chain, middlewares...)
+	route := s.addRoute(method, path, handler, chain)
+
 	s.Router.Handle(method, path, httprouter.Handle(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		r = util.SetContext(r, paramKey, p)
+		r = util.SetContext(r, serverKey, s)
 		h.ServeHTTP(w, r)
 	}))
+
+	return route
+}
+
+func (s *Server) addRoute(method, path string, handler http.Handler, middlewares []middleware.Middleware) *Route {
+	route := &Route{
+		Method:       method,
+		Path:         path,
+		Service:      s.currentService,
+		Middlewares:  make([]string, 0, len(middlewares)),
+		Dependencies: []string{},
+		server:       s,
+	}
+
+	for _, m := range middlewares {
+		route.Middlewares = append(route.Middlewares, middlewareName(m))
+	}
+
+	if d, ok := handler.(middleware.HasMiddlewareDependencies); ok {
+		route.Dependencies = append(route.Dependencies, d.Dependencies()...)
+	}
+
+	s.routes = append(s.routes, route)
+
+	return route
+}
+
+// middlewareName returns the name of a middleware for the route list.
+func middlewareName(m middleware.Middleware) string {
+	v := reflect.ValueOf(m)
+	if v.Kind() == reflect.Func && !v.IsNil() {
+		if f := runtime.FuncForPC(v.Pointer()); f != nil {
+			name := f.Name()
+			return name[strings.LastIndex(name, "/")+1:]
+		}
+	}
+
+	return v.Type().String()
+}
+
+// Routes returns the routes registered on the server, in the order of registration.
+func (s *Server) Routes() []Route {
+	routes := make([]Route, len(s.routes))
+	for i, route := range s.routes {
+		routes[i] = *route
+		routes[i].server = nil
+	}
+
+	return routes
+}
+
+// Prefix returns an empty string, because the handlers of the server are registered from the root.
+func (s *Server) Prefix() string {
+	return ""
+}
+
+// Group creates a route group.
+//
+// The handlers of the group will be registered under the prefix, and the middlewares will be applied to all handlers of the group.
+func (s *Server) Group(prefix string, middlewares ...middleware.Middleware) *Group {
+	return newGroup(s, nil, prefix, s.middlewareStack, middlewares)
 }
 
 // Head adds a HEAD handler to the router.
-func (s *Server) Head(path string, handler http.Handler, middlewares ...middleware.Middleware) {
-	s.Handle("HEAD", path, handler, middlewares...)
+func (s *Server) Head(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("HEAD", path, handler, middlewares...)
 }
 
 // Get adds a GET handler to the router.
-func (s *Server) Get(path string, handler http.Handler, middlewares ...middleware.Middleware) {
-	s.Handle("GET", path, handler, middlewares...)
+func (s *Server) Get(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("GET", path, handler, middlewares...)
 }
 
 // Post adds a POST handler to the router.
-func (s *Server) Post(path string, handler http.Handler, middlewares ...middleware.Middleware) {
-	s.Handle("POST", path, handler, middlewares...)
+func (s *Server) Post(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("POST", path, handler, middlewares...)
 }
 
 // Put adds a PUT handler to the router.
-func (s *Server) Put(path string, handler http.Handler, middlewares ...middleware.Middleware) {
-	s.Handle("PUT", path, handler, middlewares...)
+func (s *Server) Put(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("PUT", path, handler, middlewares...)
 }
 
 // Delete adds a DELETE handler to the router.
-func (s *Server) Delete(path string, handler http.Handler, middlewares ...middleware.Middleware) {
-	s.Handle("DELETE", path, handler, middlewares...)
+func (s *Server) Delete(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("DELETE", path, handler, middlewares...)
 }
 
 // Patch adds a PATCH handler to the router.
-func (s *Server) Patch(path string, handler http.Handler, middlewares ...middleware.Middleware) {
-	s.Handle("PATCH", path, handler, middlewares...)
+func (s *Server) Patch(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("PATCH", path, handler, middlewares...)
 }
 
 // Options adds an OPTIONS handler to the router.
-func (s *Server) Options(path string, handler http.Handler, middlewares ...middleware.Middleware) {
-	s.Handle("OPTIONS", path, handler, middlewares...)
+func (s *Server) Options(path string, handler http.Handler, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("OPTIONS", path, handler, middlewares...)
 }
 
 // HeadF adds a HEAD HandlerFunc to the router.
-func (s *Server) HeadF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) {
-	s.Handle("HEAD", path, handler, middlewares...)
+func (s *Server) HeadF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("HEAD", path, handler, middlewares...)
 }
 
 // GetF adds a GET HandlerFunc to the router.
-func (s *Server) GetF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) {
-	s.Handle("GET", path, handler, middlewares...)
+func (s *Server) GetF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("GET", path, handler, middlewares...)
 }
 
 // PostF adds a POST HandlerFunc to the router.
-func (s *Server) PostF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) {
-	s.Handle("POST", path, handler, middlewares...)
+func (s *Server) PostF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("POST", path, handler, middlewares...)
 }
 
 // PutF adds a PUT HandlerFunc to the router.
-func (s *Server) PutF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) {
-	s.Handle("PUT", path, handler, middlewares...)
+func (s *Server) PutF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("PUT", path, handler, middlewares...)
 }
 
 // DeleteF adds a DELETE HandlerFunc to the router.
-func (s *Server) DeleteF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) {
-	s.Handle("DELETE", path, handler, middlewares...)
+func (s *Server) DeleteF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("DELETE", path, handler, middlewares...)
 }
 
 // PatchF adds a PATCH HandlerFunc to the router.
-func (s *Server) PatchF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) {
-	s.Handle("PATCH", path, handler, middlewares...)
+func (s *Server) PatchF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("PATCH", path, handler, middlewares...)
 }
 
 // OptionsF adds an OPTIONS HandlerFunc to the router.
-func (s *Server) OptionsF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) {
-	s.Handle("OPTIONS", path, handler, middlewares...)
+func (s *Server) OptionsF(path string, handler http.HandlerFunc, middlewares ...middleware.Middleware) *Route {
+	return s.Handle("OPTIONS", path, handler, middlewares...)
 }
 
 // GetParams returns the path parameter values from the request.
@@ -248,28 +486,60 @@ func GetParams(r *http.Request) httprouter.Params {
 }
 
 // AddStaticLocalDir adds a local directory to the router.
+//
+// This is a shortcut for AddStaticDir() with http.Dir and the default options.
 func (s *Server) AddStaticLocalDir(prefix, path string) *Server {
-	s.Router.ServeFiles(prefix+"/*filepath", http.Dir(path))
+	return s.AddStaticDir(prefix, http.Dir(path), assets.Options{})
+}
+
+// AddStaticDir adds a file system to the router.
+//
+// The file system can be a local directory or a virtual file system, e.g. files embedded into the binary.
+// The files are served with an assets.FileServer, which is available with Assets(prefix) afterwards.
+// If fingerprinting is enabled, the manifest is built here.
+func (s *Server) AddStaticDir(prefix string, fs http.FileSystem, opts assets.Options) *Server {
+	fileServer := assets.New(fs, opts)
+	fileServer.Prefix = prefix
+	if opts.Fingerprint {
+		if _, err := fileServer.BuildManifest(); err != nil {
+			s.Logger.Log("assets", prefix, "manifest", err)
+		}
+	}
+	s.fileServers[prefix] = fileServer
+
+	s.GetF(prefix+"/*filepath", serveFilepath(fileServer))
 
 	return s
 }
 
+// Assets returns the file server that is added with AddStaticDir() or AddStaticLocalDir() with the given prefix.
+func (s *Server) Assets(prefix string) *assets.FileServer {
+	return s.fileServers[prefix]
+}
+
+// AddDynamicLocalDir adds a file system to the router that is selected for each request.
+//
+// This is a shortcut for AddDynamicDir() with the default options.
 func (s *Server) AddDynamicLocalDir(prefix string, getPath func(*http.Request) http.FileSystem) *Server {
-	s.GetF(prefix+"/*filepath", func(w http.ResponseWriter, r *http.Request) {
-		fileSystem := getPath(r)
-		if fileSystem == nil {
-			http.Error(w, "file system not found", http.StatusNotFound)
-			return
-		}
+	return s.AddDynamicDir(prefix, getPath, assets.Options{})
+}
 
-		fileServer := http.FileServer(fileSystem)
-		r.URL.Path = GetParams(r).ByName("filepath")
-		fileServer.ServeHTTP(w, r)
-	})
+// AddDynamicDir adds a file system to the router that is selected for each request, e.g. the public directory of a site.
+//
+// If getFileSystem returns nil, the response is 404.
+func (s *Server) AddDynamicDir(prefix string, getFileSystem func(*http.Request) http.FileSystem, opts assets.Options) *Server {
+	s.GetF(prefix+"/*filepath", serveFilepath(assets.NewDynamic(getFileSystem, opts)))
 
 	return s
 }
 
+func serveFilepath(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = GetParams(r).ByName("filepath")
+		handler.ServeHTTP(w, r)
+	}
+}
+
 // AddFile adds a local file to the router.
 func (s *Server) AddFile(path, file string) *Server {
 	s.GetF(path, func(w http.ResponseWriter, r *http.Request) {
@@ -289,6 +559,13 @@ func (s *Server) RegisterService(svc Service) {
 
 	s.services = append(s.services, svc)
 	s.config.MaybeRegisterSchema(svc)
+	s.Health.MaybeAdd(svc)
+
+	s.currentService = svc.Name()
+	defer func() {
+		s.currentService = ""
+	}()
+
 	svc.Register(s)
 }
 
@@ -296,6 +573,47 @@ func (s *Server) GetServices() []Service {
 	return s.services[:]
 }
 
+// StartServices calls Start() on the services that implement Starter, in the order of registration.
+//
+// The first error stops the startup. The services after the failing one are not started.
+func (s *Server) StartServices(ctx context.Context) error {
+	for _, svc := range s.services[len(s.started):] {
+		if starter, ok := svc.(Starter); ok {
+			if err := starter.Start(ctx); err != nil {
+				return errors.NewError(svc.Name()+": "+err.Error(), "", nil)
+			}
+		}
+
+		s.started = append(s.started, svc)
+	}
+
+	return nil
+}
+
+// StopServices calls Stop() on the started services that implement Stopper, in the reverse order of registration.
+//
+// All started services are stopped, even if some of them return an error. The services that were not started, or
+// failed to start are skipped.
+func (s *Server) StopServices(ctx context.Context) error {
+	var errs []error
+
+	for i := len(s.started) - 1; i >= 0; i-- {
+		if stopper, ok := s.started[i].(Stopper); ok {
+			if err := stopper.Stop(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	s.started = nil
+
+	return errors.NewMultiError(errs)
+}
+
+// Ready returns a channel that is closed when the listener of the server is up.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
 // StartHTTPS starts the server.
 func (s *Server) StartHTTPS(addr, certFile, keyFile string) error {
 	return s.startServer(addr, certFile, keyFile, false)
@@ -303,20 +621,33 @@ func (s *Server) StartHTTPS(addr, certFile, keyFile string) error {
 
 func (s *Server) startServer(addr, certFile, keyFile string, forceHTTP bool) error {
 	s.HTTPServer = &http.Server{
-		Addr:      addr,
-		Handler:   s.Handler(),
-		TLSConfig: s.TLSConfig,
+		Addr:              addr,
+		Handler:           s.Handler(),
+		TLSConfig:         s.TLSConfig,
+		ReadTimeout:       s.Limits.ReadTimeout,
+		ReadHeaderTimeout: s.Limits.ReadHeaderTimeout,
+		WriteTimeout:      s.Limits.WriteTimeout,
+		IdleTimeout:       s.Limits.IdleTimeout,
+		MaxHeaderBytes:    s.Limits.MaxHeaderBytes,
 	}
 
 	s.Logger.Log("serveraddr", addr)
 
 	s.HTTPServer.ErrorLog = stdlog.New(log.NewStdlibAdapter(s.Logger), "", stdlog.LstdFlags)
 
-	var err error
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.readyOnce.Do(func() {
+		close(s.ready)
+	})
+
 	if !forceHTTP && ((certFile != "" && keyFile != "") || s.HTTPServer.TLSConfig != nil) {
-		err = s.HTTPServer.ListenAndServeTLS(certFile, keyFile)
+		err = s.HTTPServer.ServeTLS(ln, certFile, keyFile)
 	} else {
-		err = s.HTTPServer.ListenAndServe()
+		err = s.HTTPServer.Serve(ln)
 	}
 
 	return err
@@ -34,20 +34,31 @@ func TestServer(t *testing.T) {
 
 var addr = util.TestServerAddress()
 
+var srv *server.Server
+
 func setupServer() {
 	logger := abtest.GetLogger()
 	s := server.NewServer(nil, logger)
+	srv = s
 	s.SetMaster()
 	s.UseF(testMiddleware)
 	s.GetF("/context", contextHandler)
 	s.GetF("/contextChanged", contextHandler, middleware.Func(testMiddlewareChanged))
-	s.GetF("/echo/:param", echoParam)
+	s.GetF("/echo/:param", echoParam).SetName("echo")
+	s.GetF("/url/:param/*rest", urlHandler).SetName("url")
+	s.RegisterService(&testService{})
+	s.HandleRaw("/raw", http.HandlerFunc(contextHandler))
+	g := s.Group("/group", middleware.Func(testMiddlewareChanged))
+	g.GetF("/context", contextHandler)
+	g.Group("/nested", middleware.Func(testMiddleware)).GetF("/context", contextHandler)
 
 	go func() {
 		if err := s.StartHTTP(addr); err != nil {
 			panic(err)
 		}
 	}()
+
+	<-s.Ready()
 }
 
 const testmwkey = "test"
@@ -92,3 +103,24 @@ func echoParam(w http.ResponseWriter, r *http.Request) {
 	p := server.GetParams(r).ByName("param")
 	w.Write([]byte(p))
 }
+
+type testService struct{}
+
+func (ts *testService) Name() string {
+	return "test"
+}
+
+func (ts *testService) Register(s *server.Server) error {
+	s.Get("/service", middleware.WrapHandlerFunc(echoParam, "middleware.Func"), middleware.Func(testMiddlewareChanged))
+	return nil
+}
+
+func urlHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := server.URLFor(r, "echo", "param", server.GetParams(r).ByName("param"), "q", "1")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte(u))
+}
@@ -15,9 +15,14 @@
 package server_test
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/http/httptest"
 
 	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/server"
 	"github.com/alien-bunny/ab/lib/util"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -47,4 +52,203 @@ var _ = Describe("Server", func() {
 		}, http.StatusOK)
 	})
 
+	It("should apply the middlewares of a group", func() {
+		c.Request("GET", "/group/context", nil, nil, func(resp *http.Response) {
+			body := c.ReadBody(resp, false)
+			Expect(body).To(Equal("false"))
+		}, http.StatusOK)
+
+		c.Request("GET", "/group/nested/context", nil, nil, func(resp *http.Response) {
+			body := c.ReadBody(resp, false)
+			Expect(body).To(Equal("true"))
+		}, http.StatusOK)
+	})
+
+	It("should apply the limits to the HTTP server", func() {
+		Expect(srv.HTTPServer.ReadHeaderTimeout).To(Equal(server.DefaultLimits.ReadHeaderTimeout))
+		Expect(srv.HTTPServer.IdleTimeout).To(Equal(server.DefaultLimits.IdleTimeout))
+		Expect(srv.HTTPServer.MaxHeaderBytes).To(Equal(server.DefaultLimits.MaxHeaderBytes))
+	})
+
+	It("should bypass the middlewares with a raw handler", func() {
+		c.Request("GET", "/raw", nil, nil, nil, http.StatusNotFound)
+	})
+
+	It("should validate the dependencies of a group", func() {
+		s := server.NewServer(nil, abtest.GetLogger())
+		g := s.Group("/group")
+		Expect(func() {
+			g.Get("/missing", middleware.WrapHandlerFunc(contextHandler, "missing"))
+		}).To(Panic())
+
+		g = s.Group("/group", middleware.Func(testMiddleware))
+		Expect(func() {
+			g.Group("/nested").Get("/ok", middleware.WrapHandlerFunc(contextHandler, "middleware.Func"))
+		}).NotTo(Panic())
+		Expect(s.Routes()).To(ContainElement(server.Route{
+			Method:       "GET",
+			Path:         "/group/nested/ok",
+			Middlewares:  []string{"server_test.testMiddleware"},
+			Dependencies: []string{"middleware.Func"},
+		}))
+	})
+
+	Describe("URL generation", func() {
+		It("should expand the parameters of a named route", func() {
+			u, err := srv.URL("url", "param", "a b", "rest", "/c/d", "page", "2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(u).To(Equal("/url/a%20b/c/d?page=2"))
+		})
+
+		It("should return an error for unknown routes and missing parameters", func() {
+			_, err := srv.URL("nonexistent")
+			Expect(err).To(Equal(server.ErrRouteNotFound))
+
+			_, err = srv.URL("echo")
+			Expect(err).To(HaveOccurred())
+
+			_, err = srv.URL("echo", "param")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should generate an absolute URL for the request", func() {
+			c.Request("GET", "/url/foo/bar", nil, nil, func(resp *http.Response) {
+				body := c.ReadBody(resp, false)
+				Expect(body).To(Equal(baseurl + "/echo/foo?q=1"))
+			}, http.StatusOK)
+		})
+
+		It("should use the base URL of the server", func() {
+			srv.BaseURL = func(r *http.Request) string {
+				return "https://example.com/"
+			}
+			defer func() {
+				srv.BaseURL = nil
+			}()
+
+			c.Request("GET", "/url/foo/bar", nil, nil, func(resp *http.Response) {
+				Expect(c.ReadBody(resp, false)).To(Equal("https://example.com/echo/foo?q=1"))
+			}, http.StatusOK)
+		})
+
+		It("should only use the forwarded headers when they are trusted", func() {
+			r := httptest.NewRequest("GET", "http://internal:8080/", nil)
+			r.Header.Set("X-Forwarded-Proto", "https")
+			r.Header.Set("X-Forwarded-Host", "spoofed.example.com, example.com")
+
+			Expect(server.RequestBaseURL(r, false)).To(Equal("http://internal:8080"))
+			Expect(server.RequestBaseURL(r, true)).To(Equal("https://example.com"))
+
+			r.Header.Set("X-Forwarded-Proto", "javascript")
+			Expect(server.RequestBaseURL(r, true)).To(Equal("http://example.com"))
+		})
+
+		It("should not allow duplicate route names", func() {
+			s := server.NewServer(nil, abtest.GetLogger())
+			s.GetF("/a", contextHandler).SetName("a")
+			Expect(func() {
+				s.GetF("/b", contextHandler).SetName("a")
+			}).To(Panic())
+		})
+	})
+
+	Describe("Service lifecycle", func() {
+		It("should be ready when the listener is up", func() {
+			Eventually(srv.Ready()).Should(BeClosed())
+		})
+
+		It("should start the services in order and stop them in reverse order", func() {
+			s := server.NewServer(nil, abtest.GetLogger())
+			var calls []string
+			s.RegisterService(&lifecycleService{name: "a", calls: &calls})
+			s.RegisterService(&testService{})
+			s.RegisterService(&lifecycleService{name: "b", calls: &calls})
+
+			Expect(s.StartServices(context.Background())).To(Succeed())
+			Expect(s.StopServices(context.Background())).To(Succeed())
+			Expect(calls).To(Equal([]string{"start a", "start b", "stop b", "stop a"}))
+		})
+
+		It("should stop at the first startup error, and only stop the started services", func() {
+			s := server.NewServer(nil, abtest.GetLogger())
+			var calls []string
+			s.RegisterService(&lifecycleService{name: "a", calls: &calls})
+			s.RegisterService(&lifecycleService{name: "b", calls: &calls, fail: true})
+			s.RegisterService(&lifecycleService{name: "c", calls: &calls})
+
+			Expect(s.StartServices(context.Background())).NotTo(Succeed())
+			Expect(s.StopServices(context.Background())).To(Succeed())
+			Expect(calls).To(Equal([]string{"start a", "start b", "stop a"}))
+		})
+
+		It("should return the errors of the stopped services", func() {
+			s := server.NewServer(nil, abtest.GetLogger())
+			var calls []string
+			failing := &lifecycleService{name: "a", calls: &calls}
+			s.RegisterService(failing)
+			s.RegisterService(&lifecycleService{name: "b", calls: &calls})
+
+			Expect(s.StartServices(context.Background())).To(Succeed())
+			failing.fail = true
+			Expect(s.StopServices(context.Background())).NotTo(Succeed())
+			Expect(calls).To(Equal([]string{"start a", "start b", "stop b", "stop a"}))
+		})
+	})
+
+	It("should list the registered routes", func() {
+		routes := srv.Routes()
+		Expect(routes).To(ContainElement(server.Route{
+			Method:       "GET",
+			Path:         "/echo/:param",
+			Name:         "echo",
+			Middlewares:  []string{},
+			Dependencies: []string{},
+		}))
+		Expect(routes).To(ContainElement(server.Route{
+			Method:       "GET",
+			Path:         "/contextChanged",
+			Middlewares:  []string{"server_test.testMiddlewareChanged"},
+			Dependencies: []string{},
+		}))
+		Expect(routes).To(ContainElement(server.Route{
+			Method:       "GET",
+			Path:         "/service",
+			Service:      "test",
+			Middlewares:  []string{"server_test.testMiddlewareChanged"},
+			Dependencies: []string{"middleware.Func"},
+		}))
+	})
+
 })
+
+type lifecycleService struct {
+	name  string
+	calls *[]string
+	fail  bool
+}
+
+func (ls *lifecycleService) Name() string {
+	return ls.name
+}
+
+func (ls *lifecycleService) Register(s *server.Server) error {
+	return nil
+}
+
+func (ls *lifecycleService) Start(ctx context.Context) error {
+	*ls.calls = append(*ls.calls, "start "+ls.name)
+	if ls.fail {
+		return errors.New("start failed")
+	}
+
+	return nil
+}
+
+func (ls *lifecycleService) Stop(ctx context.Context) error {
+	*ls.calls = append(*ls.calls, "stop "+ls.name)
+	if ls.fail {
+		return errors.New("stop failed")
+	}
+
+	return nil
+}
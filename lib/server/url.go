@@ -0,0 +1,148 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alien-bunny/ab/lib/errors"
+)
+
+// ErrRouteNotFound is returned when a URL is requested for a route name that does not exist.
+var ErrRouteNotFound = errors.New("route not found")
+
+// URL generates the path of a named route.
+//
+// The params are key-value pairs. The values of the ":param" and "*catchall" segments of the route path are taken
+// from the params, and the rest of the params are added as query parameters.
+//
+// Example:
+//
+//		s.GetF("/api/article/:id", articleHandler).SetName("article")
+//		path, err := s.URL("article", "id", "5", "format", "full") // path == "/api/article/5?format=full"
+func (s *Server) URL(name string, params ...string) (string, error) {
+	route, ok := s.names[name]
+	if !ok {
+		return "", ErrRouteNotFound
+	}
+
+	if len(params)%2 != 0 {
+		return "", errors.New("the number of the params must be even")
+	}
+
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+
+	path, err := expandPath(route.Path, values)
+	if err != nil {
+		return "", err
+	}
+
+	if len(values) > 0 {
+		query := url.Values{}
+		for k, v := range values {
+			query.Set(k, v)
+		}
+		path += "?" + query.Encode()
+	}
+
+	return path, nil
+}
+
+// URLFor generates an absolute URL of a named route for the current request.
+//
+// The scheme and the host are returned by Server.BaseURL. By default they are taken from the request, so the URL
+// points to the host that was used for the namespace negotiation. See Server.URL() for the params.
+func URLFor(r *http.Request, name string, params ...string) (string, error) {
+	s, ok := r.Context().Value(serverKey).(*Server)
+	if !ok {
+		return "", errors.New("server is not found in the request context")
+	}
+
+	path, err := s.URL(name, params...)
+	if err != nil {
+		return "", err
+	}
+
+	if s.BaseURL != nil {
+		return strings.TrimSuffix(s.BaseURL(r), "/") + path, nil
+	}
+
+	return RequestBaseURL(r, false) + path, nil
+}
+
+// RequestBaseURL returns the scheme and the host of the request.
+//
+// If trustForwarded is set, the X-Forwarded-Proto and X-Forwarded-Host headers are used, which are set by a reverse
+// proxy (e.g. a TLS terminating load balancer). Only enable it when the server is only reachable through the proxy,
+// because the clients can set these headers too. Like with X-Forwarded-For, the last value of the headers is used.
+func RequestBaseURL(r *http.Request, trustForwarded bool) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+
+	if trustForwarded {
+		if proto := strings.ToLower(lastHeaderValue(r, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := lastHeaderValue(r, "X-Forwarded-Host"); forwardedHost != "" {
+			host = forwardedHost
+		}
+	}
+
+	return scheme + "://" + host
+}
+
+func lastHeaderValue(r *http.Request, header string) string {
+	values := strings.Split(r.Header.Get(header), ",")
+	return strings.TrimSpace(values[len(values)-1])
+}
+
+// expandPath replaces the parameters in a httprouter path pattern.
+//
+// The used values are removed from the map.
+func expandPath(pattern string, values map[string]string) (string, error) {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if len(segment) < 2 || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+
+		name := segment[1:]
+		value, ok := values[name]
+		if !ok {
+			return "", errors.New(`missing route parameter "` + name + `"`)
+		}
+		delete(values, name)
+
+		if segment[0] == '*' {
+			parts := strings.Split(strings.TrimPrefix(value, "/"), "/")
+			for j := range parts {
+				parts[j] = url.PathEscape(parts[j])
+			}
+			segments[i] = strings.Join(parts, "/")
+		} else {
+			segments[i] = url.PathEscape(value)
+		}
+	}
+
+	return strings.Join(segments, "/"), nil
+}
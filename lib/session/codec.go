@@ -0,0 +1,151 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/alien-bunny/ab/lib/util"
+)
+
+// The formats of the encoded sessions. The format is the prefix of the encoded session, separated with a dot.
+const (
+	// FormatLegacy is the original format: the signature and the data, hex encoded. It has no prefix.
+	FormatLegacy = ""
+	// FormatSigned is the signature and the data, base64url encoded.
+	FormatSigned = "s1"
+	// FormatEncrypted is the data encrypted with AES-GCM, base64url encoded.
+	FormatEncrypted = "e1"
+
+	formatSeparator = "."
+
+	// EncryptionKeySize is the required size of the keys that encrypt the sessions.
+	EncryptionKeySize = 32
+)
+
+var (
+	DecryptionFailedError = errors.New("session decryption failed")
+	UnknownFormatError    = errors.New("unknown session format")
+	InvalidKeyError       = errors.New("the session key must be 32 bytes long")
+)
+
+var encryptionKeyLabel = []byte("ab session encryption")
+
+// Format returns the format of an encoded session.
+func Format(encoded string) string {
+	format, _ := splitFormat(encoded)
+	return format
+}
+
+func splitFormat(encoded string) (string, string) {
+	if i := strings.Index(encoded, formatSeparator); i >= 0 {
+		return encoded[:i], encoded[i+1:]
+	}
+
+	return FormatLegacy, encoded
+}
+
+// EncodeSignedSession encodes and signs a session in the FormatSigned format.
+//
+// The data is readable by the client, but it cannot be modified without the key.
+func EncodeSignedSession(s Session, key SecretKey) string {
+	data := marshal(s)
+	if len(data) <= 1 {
+		return ""
+	}
+
+	signed := append(key.Sign(data[1:]), data...)
+
+	return FormatSigned + formatSeparator + base64.RawURLEncoding.EncodeToString(signed)
+}
+
+func decodeSigned(encoded string, key SecretKey) (Session, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return readStringPairs(b, key)
+}
+
+// EncodeEncryptedSession encrypts a session in the FormatEncrypted format.
+//
+// The encryption key is derived from the key, so the same key can sign and encrypt the sessions.
+func EncodeEncryptedSession(s Session, key SecretKey) (string, error) {
+	data := marshal(s)
+	if len(data) <= 1 {
+		return "", nil
+	}
+
+	aead, err := key.cipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, data[1:], []byte(FormatEncrypted))
+
+	return FormatEncrypted + formatSeparator + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func decodeEncrypted(encoded string, key SecretKey) (Session, error) {
+	if key == nil {
+		return nil, DecryptionFailedError
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := key.cipher()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < aead.NonceSize() {
+		return nil, MalformedSessionDataError
+	}
+
+	data, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(FormatEncrypted))
+	if err != nil {
+		return nil, DecryptionFailedError
+	}
+
+	return piecesToSession(splitPieces(data))
+}
+
+// cipher creates an AEAD cipher with a key that is derived from the secret key.
+func (s SecretKey) cipher() (cipher.AEAD, error) {
+	if len(s) != EncryptionKeySize {
+		return nil, InvalidKeyError
+	}
+
+	mac := hmac.New(sha256.New, s)
+	mac.Write(encryptionKeyLabel)
+
+	return util.CreateCipher(mac.Sum(nil))
+}
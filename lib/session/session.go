@@ -25,8 +25,10 @@ import (
 )
 
 const (
-	sessionIdKey = "_ID"
-	hashLen      = 32
+	// IdKey is the session key of the session ID.
+	IdKey = "_ID"
+
+	hashLen = 32
 )
 
 var (
@@ -39,16 +41,51 @@ type Session map[string]string
 
 // Id returns the session ID. If there isn't one, it generates it.
 func (s Session) Id() string {
-	if id, ok := s[sessionIdKey]; ok {
+	if id, ok := s[IdKey]; ok {
 		return id
 	}
 
 	buf := make([]byte, 32)
 	rand.Read(buf)
 
-	s[sessionIdKey] = hex.EncodeToString(buf)
+	s[IdKey] = hex.EncodeToString(buf)
+
+	return s[IdKey]
+}
+
+// HasId tells if the session has an ID, without generating one.
+func (s Session) HasId() bool {
+	_, ok := s[IdKey]
+	return ok
+}
+
+// IsEmpty tells if the session has no data besides its ID.
+func (s Session) IsEmpty() bool {
+	for k := range s {
+		if k != IdKey {
+			return false
+		}
+	}
 
-	return s[sessionIdKey]
+	return true
+}
+
+// Copy returns a shallow copy of the session.
+func (s Session) Copy() Session {
+	c := make(Session, len(s))
+	for k, v := range s {
+		c[k] = v
+	}
+
+	return c
+}
+
+// Regenerate replaces the session ID, keeping the rest of the session data.
+//
+// The ID must be regenerated when the privileges of the session change (e.g. on login) to prevent session fixation.
+func (s Session) Regenerate() string {
+	delete(s, IdKey)
+	return s.Id()
 }
 
 func (s Session) Reset() {
@@ -57,7 +94,57 @@ func (s Session) Reset() {
 	}
 }
 
+// EncodeSession encodes and signs a session in the legacy, hex encoded format.
+//
+// Use EncodeSignedSession or EncodeEncryptedSession for new cookies.
 func EncodeSession(s Session, key SecretKey) string {
+	data := marshal(s)
+
+	encoded := ""
+
+	if len(data) > 1 {
+		signature := key.Sign(data[1:])
+		encoded = hex.EncodeToString(signature) + hex.EncodeToString(data)
+	}
+
+	return encoded
+}
+
+// DecodeSession decodes a session in any format, and verifies it with the key.
+//
+// A nil key skips the verification of the signed formats. Encrypted sessions cannot be decoded without a key.
+func DecodeSession(encoded string, key SecretKey) (Session, error) {
+	var sess Session
+	var err error
+
+	switch format, payload := splitFormat(encoded); format {
+	case FormatLegacy:
+		sess, err = decodeLegacy(payload, key)
+	case FormatSigned:
+		sess, err = decodeSigned(payload, key)
+	case FormatEncrypted:
+		sess, err = decodeEncrypted(payload, key)
+	default:
+		err = UnknownFormatError
+	}
+
+	if err != nil {
+		return make(Session), err
+	}
+
+	return sess, nil
+}
+
+func decodeLegacy(encoded string, key SecretKey) (Session, error) {
+	b, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return readStringPairs(b, key)
+}
+
+func marshal(s Session) []byte {
 	buf := bytes.NewBuffer(nil)
 	for k, v := range s {
 		if strings.Contains(k, "\x00") {
@@ -73,30 +160,33 @@ func EncodeSession(s Session, key SecretKey) string {
 		buf.WriteString(v)
 	}
 
-	data := buf.Bytes()
-
-	encoded := ""
-
-	if len(data) > 1 {
-		signature := key.Sign(data[1:])
-		encoded = hex.EncodeToString(signature) + hex.EncodeToString(data)
-	}
-
-	return encoded
+	return buf.Bytes()
 }
 
-func DecodeSession(encoded string, key SecretKey) (Session, error) {
-	b, err := hex.DecodeString(encoded)
-	if err != nil {
-		return make(Session), err
+// DecodeSessionWithKeys decodes a session, trying the keys in order. It returns the index of the key that verified the
+// session.
+//
+// This makes key rotation possible: the sessions are signed with the first key, and the old keys keep the existing
+// sessions valid until they are signed again. Without keys, the signature is not verified, and the index is -1.
+func DecodeSessionWithKeys(encoded string, keys []SecretKey) (Session, int, error) {
+	if len(keys) == 0 {
+		sess, err := DecodeSession(encoded, nil)
+		return sess, -1, err
 	}
 
-	sess, err := readStringPairs(b, key)
-	if err != nil {
-		return make(Session), err
+	for i, key := range keys {
+		sess, err := DecodeSession(encoded, key)
+		switch err {
+		case nil:
+			return sess, i, nil
+		case SignatureVerificationFailedError, DecryptionFailedError:
+			continue
+		default:
+			return sess, -1, err
+		}
 	}
 
-	return sess, nil
+	return make(Session), -1, SignatureVerificationFailedError
 }
 
 func readStringPairs(b []byte, key SecretKey) (Session, error) {
@@ -104,6 +194,11 @@ func readStringPairs(b []byte, key SecretKey) (Session, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	return piecesToSession(pieces)
+}
+
+func piecesToSession(pieces []string) (Session, error) {
 	if len(pieces)%2 == 1 {
 		return nil, MalformedSessionDataError
 	}
@@ -128,7 +223,10 @@ func readPieces(b []byte, key SecretKey) ([]string, error) {
 		return nil, SignatureVerificationFailedError
 	}
 
-	remaining := b[start:]
+	return splitPieces(b[start:]), nil
+}
+
+func splitPieces(remaining []byte) []string {
 	strs := make([]string, countStringPairs(remaining))
 	currentString := 0
 	for {
@@ -143,7 +241,7 @@ func readPieces(b []byte, key SecretKey) ([]string, error) {
 		remaining = remaining[term+1:]
 	}
 
-	return strs, nil
+	return strs
 }
 
 func countStringPairs(slice []byte) int {
@@ -192,3 +290,17 @@ func MustParse(encoded string) SecretKey {
 
 	return SecretKey(b)
 }
+
+// ParseKeys parses a list of hex encoded keys.
+func ParseKeys(encoded ...string) ([]SecretKey, error) {
+	keys := make([]SecretKey, len(encoded))
+	for i, e := range encoded {
+		b, err := hex.DecodeString(e)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = SecretKey(b)
+	}
+
+	return keys, nil
+}
@@ -15,6 +15,7 @@
 package session_test
 
 import (
+	"encoding/base64"
 	"encoding/hex"
 	"strings"
 
@@ -69,6 +70,15 @@ var _ = Describe("Session", func() {
 			Expect(id).NotTo(BeZero())
 			Expect(id).To(Equal(sess.Id()))
 		})
+
+		It("should regenerate the session id", func() {
+			sess["data"] = "value"
+			id := sess.Id()
+			newID := sess.Regenerate()
+			Expect(newID).NotTo(Equal(id))
+			Expect(sess.Id()).To(Equal(newID))
+			Expect(sess["data"]).To(Equal("value"))
+		})
 	})
 
 	Describe("A session object, containing 0 byte key or a value", func() {
@@ -131,6 +141,107 @@ var _ = Describe("Session", func() {
 		})
 	})
 
+	Describe("A key list", func() {
+		oldKey := session.SecretKey(abtest.FakeKey)
+		newKey := session.MustParse(util.RandomSecret(32))
+		sess := randomSession()
+
+		It("should verify the sessions with any key", func() {
+			decoded, index, err := session.DecodeSessionWithKeys(session.EncodeSession(sess, oldKey), []session.SecretKey{newKey, oldKey})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(1))
+			Expect(decoded).To(Equal(sess))
+
+			decoded, index, err = session.DecodeSessionWithKeys(session.EncodeSession(sess, newKey), []session.SecretKey{newKey, oldKey})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(0))
+			Expect(decoded).To(Equal(sess))
+		})
+
+		It("should fail when no key verifies the session", func() {
+			_, index, err := session.DecodeSessionWithKeys(session.EncodeSession(sess, oldKey), []session.SecretKey{newKey})
+			Expect(err).To(Equal(session.SignatureVerificationFailedError))
+			Expect(index).To(Equal(-1))
+		})
+
+		It("should parse the hex encoded keys", func() {
+			keys, err := session.ParseKeys(hex.EncodeToString(newKey), hex.EncodeToString(oldKey))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(Equal([]session.SecretKey{newKey, oldKey}))
+
+			_, err = session.ParseKeys("zz")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("The cookie formats", func() {
+		sess := randomSession()
+
+		It("should encode and decode the signed format", func() {
+			encoded := session.EncodeSignedSession(sess, key)
+			Expect(session.Format(encoded)).To(Equal(session.FormatSigned))
+			Expect(len(encoded)).To(BeNumerically("<", len(session.EncodeSession(sess, key))))
+
+			decoded, err := session.DecodeSession(encoded, key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(sess))
+
+			b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(encoded, session.FormatSigned+"."))
+			Expect(err).NotTo(HaveOccurred())
+			b[len(b)-1] ^= 1
+			_, err = session.DecodeSession(session.FormatSigned+"."+base64.RawURLEncoding.EncodeToString(b), key)
+			Expect(err).To(Equal(session.SignatureVerificationFailedError))
+		})
+
+		It("should encode and decode the encrypted format", func() {
+			encoded, err := session.EncodeEncryptedSession(sess, key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(session.Format(encoded)).To(Equal(session.FormatEncrypted))
+			for k := range sess {
+				Expect(encoded).NotTo(ContainSubstring(k))
+			}
+
+			decoded, err := session.DecodeSession(encoded, key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(sess))
+
+			_, err = session.DecodeSession(encoded, nil)
+			Expect(err).To(Equal(session.DecryptionFailedError))
+
+			_, err = session.DecodeSession(encoded, session.MustParse(util.RandomSecret(32)))
+			Expect(err).To(Equal(session.DecryptionFailedError))
+		})
+
+		It("should decode the encrypted format with an old key", func() {
+			encoded, err := session.EncodeEncryptedSession(sess, key)
+			Expect(err).NotTo(HaveOccurred())
+
+			decoded, index, err := session.DecodeSessionWithKeys(encoded, []session.SecretKey{session.MustParse(util.RandomSecret(32)), key})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(1))
+			Expect(decoded).To(Equal(sess))
+		})
+
+		It("should detect the legacy format", func() {
+			Expect(session.Format(session.EncodeSession(sess, key))).To(Equal(session.FormatLegacy))
+		})
+
+		It("should encode empty sessions as empty strings", func() {
+			Expect(session.EncodeSignedSession(session.Session{}, key)).To(BeEmpty())
+			Expect(session.EncodeEncryptedSession(session.Session{}, key)).To(BeEmpty())
+		})
+
+		It("should reject the unknown formats", func() {
+			_, err := session.DecodeSession("x9.abcd", key)
+			Expect(err).To(Equal(session.UnknownFormatError))
+		})
+
+		It("should reject invalid encryption keys", func() {
+			_, err := session.EncodeEncryptedSession(sess, session.SecretKey("short"))
+			Expect(err).To(Equal(session.InvalidKeyError))
+		})
+	})
+
 	Describe("A SecretKey", func() {
 		k := session.SecretKey([]byte{})
 		It("must be 32 bytes long", func() {
@@ -0,0 +1,167 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package totp implements the time-based one-time passwords of RFC 6238.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SecretSize is the size of the generated secrets in bytes.
+	SecretSize = 20
+
+	DefaultPeriod = 30 * time.Second
+	DefaultDigits = 6
+	DefaultSkew   = 1
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Options are the parameters of the code generation.
+//
+// The zero values are replaced with the defaults, which are supported by every authenticator app.
+type Options struct {
+	// Period is the validity of a code.
+	Period time.Duration
+	// Digits is the length of a code.
+	Digits int
+	// Skew is the number of periods before and after the current one that are accepted to tolerate clock drift.
+	Skew uint
+}
+
+func (o Options) period() time.Duration {
+	if o.Period <= 0 {
+		return DefaultPeriod
+	}
+
+	return o.Period
+}
+
+func (o Options) digits() int {
+	if o.Digits <= 0 {
+		return DefaultDigits
+	}
+
+	return o.Digits
+}
+
+// GenerateSecret generates a random secret.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// EncodeSecret encodes a secret in the unpadded base32 format of the authenticator apps.
+func EncodeSecret(secret []byte) string {
+	return secretEncoding.EncodeToString(secret)
+}
+
+// DecodeSecret decodes a secret that is encoded with EncodeSecret. Spaces and lowercase letters are accepted.
+func DecodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	return secretEncoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// Counter returns the time step of t.
+func Counter(t time.Time, opts Options) int64 {
+	return t.Unix() / int64(opts.period()/time.Second)
+}
+
+// Code generates the code of a time step (RFC 4226).
+func Code(secret []byte, counter int64, opts Options) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := int64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+
+	digits := opts.digits()
+	mod := int64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	code := strconv.FormatInt(value%mod, 10)
+	for len(code) < digits {
+		code = "0" + code
+	}
+
+	return code
+}
+
+// Validate checks a code at time t, tolerating Options.Skew periods of clock drift.
+//
+// On success, the time step of the matching code is returned. Store it and reject the codes of the same or earlier
+// time steps, otherwise an observed code can be replayed while it is valid.
+func Validate(secret []byte, code string, t time.Time, opts Options) (int64, bool) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != opts.digits() {
+		return 0, false
+	}
+
+	current := Counter(t, opts)
+	skew := int64(opts.Skew)
+	for counter := current - skew; counter <= current+skew; counter++ {
+		if subtle.ConstantTimeCompare([]byte(Code(secret, counter, opts)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+// URI returns the otpauth:// URI of a secret. Authenticator apps can import this URI, usually from a QR code.
+func URI(secret []byte, issuer, account string, opts Options) string {
+	label := account
+	if issuer != "" {
+		label = issuer + ":" + account
+	}
+
+	query := url.Values{}
+	query.Set("secret", EncodeSecret(secret))
+	if issuer != "" {
+		query.Set("issuer", issuer)
+	}
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(opts.digits()))
+	query.Set("period", strconv.Itoa(int(opts.period()/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + label,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package totp_test
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/totp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// The SHA-1 test vectors of RFC 6238.
+var rfcSecret = []byte("12345678901234567890")
+var rfcOptions = totp.Options{Digits: 8}
+
+var _ = Describe("TOTP", func() {
+	DescribeTable("the RFC 6238 test vectors",
+		func(unix int64, code string) {
+			t := time.Unix(unix, 0)
+			Expect(totp.Code(rfcSecret, totp.Counter(t, rfcOptions), rfcOptions)).To(Equal(code))
+
+			counter, ok := totp.Validate(rfcSecret, code, t, rfcOptions)
+			Expect(ok).To(BeTrue())
+			Expect(counter).To(Equal(totp.Counter(t, rfcOptions)))
+		},
+		Entry("59", int64(59), "94287082"),
+		Entry("1111111109", int64(1111111109), "07081804"),
+		Entry("1111111111", int64(1111111111), "14050471"),
+		Entry("1234567890", int64(1234567890), "89005924"),
+		Entry("2000000000", int64(2000000000), "69279037"),
+		Entry("20000000000", int64(20000000000), "65353130"),
+	)
+
+	It("should tolerate the configured skew", func() {
+		secret, err := totp.GenerateSecret()
+		Expect(err).NotTo(HaveOccurred())
+		now := time.Now()
+		previous := totp.Code(secret, totp.Counter(now, totp.Options{})-1, totp.Options{})
+
+		_, ok := totp.Validate(secret, previous, now, totp.Options{})
+		Expect(ok).To(BeFalse())
+
+		counter, ok := totp.Validate(secret, previous, now, totp.Options{Skew: 1})
+		Expect(ok).To(BeTrue())
+		Expect(counter).To(Equal(totp.Counter(now, totp.Options{}) - 1))
+
+		_, ok = totp.Validate(secret, "12345", now, totp.Options{Skew: 1})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should encode the secrets", func() {
+		secret, err := totp.GenerateSecret()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret).To(HaveLen(totp.SecretSize))
+
+		encoded := totp.EncodeSecret(secret)
+		Expect(encoded).NotTo(ContainSubstring("="))
+
+		decoded, err := totp.DecodeSecret(encoded)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded).To(Equal(secret))
+	})
+
+	It("should generate an otpauth URI", func() {
+		uri := totp.URI(rfcSecret, "Example", "user@example.com", totp.Options{})
+		u, err := url.Parse(uri)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(u.Scheme).To(Equal("otpauth"))
+		Expect(u.Host).To(Equal("totp"))
+		Expect(u.Path).To(Equal("/Example:user@example.com"))
+		Expect(u.Query().Get("secret")).To(Equal("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"))
+		Expect(u.Query().Get("issuer")).To(Equal("Example"))
+		Expect(u.Query().Get("digits")).To(Equal("6"))
+		Expect(u.Query().Get("period")).To(Equal("30"))
+	})
+})
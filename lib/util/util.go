@@ -162,8 +162,14 @@ func Encrypt(aeadCipher cipher.AEAD, msg []byte) []byte {
 	return buf.Bytes()
 }
 
+// ErrMessageTooShort is returned when the encrypted message is shorter than the nonce.
+var ErrMessageTooShort = fmt.Errorf("message too short")
+
 func Decrypt(aeadCipher cipher.AEAD, msg []byte) ([]byte, error) {
 	noncelen := aeadCipher.NonceSize()
+	if len(msg) < noncelen {
+		return nil, ErrMessageTooShort
+	}
 	nonce := msg[:noncelen]
 	encrypted := msg[noncelen:]
 
@@ -32,6 +32,7 @@ const (
 	CategoryConfigNotFound     = "config not found"
 	configKey                  = "abconfig"
 	configWritableKey          = "abwritableconfig"
+	configNamespaceKey         = "abconfignamespace"
 )
 
 type NamespaceNegotiator interface {
@@ -42,10 +43,24 @@ func GetConfig(r *http.Request) config.Config {
 	return r.Context().Value(configKey).(config.Config)
 }
 
+// MaybeGetConfig returns the config of the request, or nil if the request did not go through the ConfigMiddleware.
+func MaybeGetConfig(r *http.Request) config.Config {
+	c, _ := r.Context().Value(configKey).(config.Config)
+	return c
+}
+
 func GetWritableConfig(r *http.Request) config.WritableConfig {
 	return r.Context().Value(configWritableKey).(config.WritableConfig)
 }
 
+// GetNamespace returns the config namespace of the request.
+//
+// An empty string is returned if the config middleware has not processed the request.
+func GetNamespace(r *http.Request) string {
+	namespace, _ := r.Context().Value(configNamespaceKey).(string)
+	return namespace
+}
+
 var _ middleware.Middleware = &ConfigMiddleware{}
 
 type ConfigMiddleware struct {
@@ -77,6 +92,7 @@ func (c *ConfigMiddleware) Wrap(next http.Handler) http.Handler {
 		}
 		r = util.SetContext(r, configWritableKey, c.configStore.GetWritable(namespace))
 		r = util.SetContext(r, configKey, cfg)
+		r = util.SetContext(r, configNamespaceKey, namespace)
 
 		next.ServeHTTP(w, r)
 	})
@@ -189,14 +205,27 @@ func (m *middlewareWrapper) Wrap(next http.Handler) http.Handler {
 		}
 
 		if mwi != nil {
-			mw := mwi.(middleware.Middleware)
-			mw.Wrap(next).ServeHTTP(w, r)
+			toMiddleware(mwi).Wrap(next).ServeHTTP(w, r)
 		} else {
 			next.ServeHTTP(w, r)
 		}
 	})
 }
 
+// toMiddleware converts a config value into a middleware.
+//
+// The config store returns values, so a middleware with pointer receivers has to be copied to an addressable value.
+func toMiddleware(v interface{}) middleware.Middleware {
+	if mw, ok := v.(middleware.Middleware); ok {
+		return mw
+	}
+
+	ptr := reflect.New(reflect.TypeOf(v))
+	ptr.Elem().Set(reflect.ValueOf(v))
+
+	return ptr.Interface().(middleware.Middleware)
+}
+
 func WrapMiddleware(key string, t reflect.Type) middleware.Middleware {
 	return &middlewareWrapper{key: key, t: t}
 }
@@ -50,6 +50,7 @@ var _ = Describe("Config middleware", func() {
 		stack.Push(configmw.NewConfigMiddleware(conf, negotiator))
 
 		abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {
+			Expect(configmw.GetNamespace(r)).To(Equal("test"))
 			cfg := configmw.GetConfig(r)
 			Expect(cfg).NotTo(BeNil())
 		})
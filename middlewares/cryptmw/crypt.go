@@ -16,6 +16,7 @@ package cryptmw
 
 import (
 	"crypto/cipher"
+	"encoding/base64"
 	"net/http"
 
 	"github.com/alien-bunny/ab/lib/util"
@@ -32,7 +33,7 @@ func Encrypt(r *http.Request, msg []byte) []byte {
 }
 
 func Decrypt(r *http.Request, msg []byte) []byte {
-	decrypted, err := util.Decrypt(getCipher(r), msg)
+	decrypted, _, err := getMiddleware(r).decrypt(msg)
 	if err != nil {
 		logmw.Warn(r, "crypt middleware", logmw.CategoryInputError).Log("error", err)
 		return []byte{}
@@ -46,7 +47,7 @@ func EncryptString(r *http.Request, msg string) string {
 }
 
 func DecryptString(r *http.Request, msg string) string {
-	decrypted, err := util.DecryptString(getCipher(r), msg)
+	decrypted, _, err := decryptString(r, msg)
 	if err != nil {
 		logmw.Warn(r, "crypt middleware", logmw.CategoryInputError).Log("error", err)
 		return ""
@@ -55,12 +56,67 @@ func DecryptString(r *http.Request, msg string) string {
 	return decrypted
 }
 
+// ReencryptString encrypts a string again with the current key if it was encrypted with an old key.
+//
+// The returned bool reports if the string has changed and needs to be saved. Invalid strings are returned unchanged.
+func ReencryptString(r *http.Request, msg string) (string, bool) {
+	decrypted, keyIndex, err := decryptString(r, msg)
+	if err != nil {
+		logmw.Warn(r, "crypt middleware", logmw.CategoryInputError).Log("error", err)
+		return msg, false
+	}
+	if keyIndex <= 0 {
+		return msg, false
+	}
+
+	return EncryptString(r, decrypted), true
+}
+
+func decryptString(r *http.Request, msg string) (string, int, error) {
+	if msg == "" {
+		return "", 0, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(msg)
+	if err != nil {
+		return "", -1, err
+	}
+
+	decrypted, keyIndex, err := getMiddleware(r).decrypt(decoded)
+	if err != nil {
+		return "", -1, err
+	}
+
+	return string(decrypted), keyIndex, nil
+}
+
+func getMiddleware(r *http.Request) *CryptMiddleware {
+	return r.Context().Value(cryptKey).(*CryptMiddleware)
+}
+
 func getCipher(r *http.Request) cipher.AEAD {
-	return r.Context().Value(cryptKey).(*CryptMiddleware).aeadCipher
+	return getMiddleware(r).ciphers[0]
 }
 
+// CryptMiddleware provides AEAD encryption for the handlers.
+//
+// The messages are encrypted with the first key, and every key can decrypt them. This makes key rotation possible:
+// put the new key in front of the old ones, and use ReencryptString() to migrate the stored values.
 type CryptMiddleware struct {
-	aeadCipher cipher.AEAD
+	ciphers []cipher.AEAD
+}
+
+// decrypt decrypts a message with the first key that can authenticate it. It returns the index of the key.
+func (mw *CryptMiddleware) decrypt(msg []byte) ([]byte, int, error) {
+	var err error
+	for i, c := range mw.ciphers {
+		var decrypted []byte
+		if decrypted, err = util.Decrypt(c, msg); err == nil {
+			return decrypted, i, nil
+		}
+	}
+
+	return nil, -1, err
 }
 
 func (mw *CryptMiddleware) Wrap(next http.Handler) http.Handler {
@@ -76,12 +132,15 @@ func (mw *CryptMiddleware) Dependencies() []string {
 	}
 }
 
-func NewCryptMiddleware(key []byte) (*CryptMiddleware, error) {
-	var err error
+// NewCryptMiddleware creates a CryptMiddleware. The key encrypts the messages; the old keys can only decrypt them.
+func NewCryptMiddleware(key []byte, oldKeys ...[]byte) (*CryptMiddleware, error) {
 	mw := &CryptMiddleware{}
-	mw.aeadCipher, err = util.CreateCipher(key)
-	if err != nil {
-		return nil, err
+	for _, k := range append([][]byte{key}, oldKeys...) {
+		c, err := util.CreateCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		mw.ciphers = append(mw.ciphers, c)
 	}
 
 	return mw, nil
@@ -72,4 +72,45 @@ var _ = Describe("Crypt middleware", func() {
 			Expect(decrypted).To(Equal(msg))
 		})
 	})
+	It("should decrypt with the old keys", func() {
+		oldKey := make([]byte, 32)
+		rand.Read(oldKey)
+		oldmw, err := cryptmw.NewCryptMiddleware(oldKey)
+		Expect(err).NotTo(HaveOccurred())
+		rotatedmw, err := cryptmw.NewCryptMiddleware(buf, oldKey)
+		Expect(err).NotTo(HaveOccurred())
+
+		oldStack := middleware.NewStack(nil)
+		oldStack.Push(logmw.New(logger))
+		oldStack.Push(oldmw)
+
+		rotatedStack := middleware.NewStack(nil)
+		rotatedStack.Push(logmw.New(logger))
+		rotatedStack.Push(rotatedmw)
+
+		msg := util.RandomSecret(64)
+		encrypted := ""
+		abtest.TestMiddleware(oldStack, func(w http.ResponseWriter, r *http.Request) {
+			encrypted = cryptmw.EncryptString(r, msg)
+		})
+
+		reencrypted := ""
+		abtest.TestMiddleware(rotatedStack, func(w http.ResponseWriter, r *http.Request) {
+			Expect(cryptmw.DecryptString(r, encrypted)).To(Equal(msg))
+
+			var changed bool
+			reencrypted, changed = cryptmw.ReencryptString(r, encrypted)
+			Expect(changed).To(BeTrue())
+			Expect(cryptmw.DecryptString(r, reencrypted)).To(Equal(msg))
+
+			_, changed = cryptmw.ReencryptString(r, reencrypted)
+			Expect(changed).To(BeFalse())
+		})
+
+		By("checking that only the new key is used for encryption")
+		abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {
+			Expect(cryptmw.DecryptString(r, reencrypted)).To(Equal(msg))
+			Expect(cryptmw.DecryptString(r, encrypted)).To(BeEmpty())
+		})
+	})
 })
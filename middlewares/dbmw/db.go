@@ -40,17 +40,35 @@ const (
 )
 
 // GetConnection returns DB from the request context.
+//
+// The queries are bound to the request context, so they are cancelled when the request deadline is exceeded or the
+// client goes away.
+//
+// The returned value is a wrapper, not the *sql.DB or *sql.Tx in the context, so type assertions like
+// conn.(*sql.Tx) fail on it. Use db.Unwrap() to access the underlying connection:
+//
+//		if tx, ok := db.Unwrap(dbmw.GetConnection(r)).(*sql.Tx); ok {
+//			// ...
+//		}
 func GetConnection(r *http.Request) db.DB {
+	return db.WithContext(r.Context(), getRawConnection(r))
+}
+
+func getRawConnection(r *http.Request) db.DB {
 	return r.Context().Value(dbConnectionKey).(db.DB)
 }
 
 func connect(connectString string, maxIdleConnections, maxOpenConnections int, connMaxLifetime time.Duration) *sql.DB {
 	conn := db.RetryDBConn(connectString, 10)
+	setPoolLimits(conn, maxIdleConnections, maxOpenConnections, connMaxLifetime)
+
+	return conn
+}
+
+func setPoolLimits(conn *sql.DB, maxIdleConnections, maxOpenConnections int, connMaxLifetime time.Duration) {
 	conn.SetMaxIdleConns(maxIdleConnections)
 	conn.SetMaxOpenConns(maxOpenConnections)
 	conn.SetConnMaxLifetime(connMaxLifetime)
-
-	return conn
 }
 
 type DBConfig struct {
@@ -126,6 +144,26 @@ func (m *Middleware) getConnection(connStr string) *sql.DB {
 	return conn
 }
 
+// lookupConnection is like getConnection, but it returns the error of an invalid connection string instead of
+// panicking.
+func (m *Middleware) lookupConnection(connStr string) (*sql.DB, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if conn := m.connections[connStr]; conn != nil {
+		return conn, nil
+	}
+
+	conn, err := db.ConnectToDB(connStr)
+	if err != nil {
+		return nil, err
+	}
+	setPoolLimits(conn, m.MaxIdleConnections, m.MaxOpenConnections, m.ConnectionMaxLifetime)
+	m.connections[connStr] = conn
+
+	return conn, nil
+}
+
 func (m *Middleware) Close() {
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
@@ -226,11 +264,11 @@ func Begin() *TransactionMiddleware {
 
 func (t *TransactionMiddleware) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conn := GetConnection(r)
+		conn := getRawConnection(r)
 		var tx *sql.Tx
 		var err error
 		if dbconn, ok := conn.(*sql.DB); ok {
-			tx, err = dbconn.Begin()
+			tx, err = dbconn.BeginTx(r.Context(), nil)
 			if err != nil {
 				errors.Fail(http.StatusInternalServerError, err)
 			}
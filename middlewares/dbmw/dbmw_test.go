@@ -15,6 +15,7 @@
 package dbmw_test
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -125,3 +126,19 @@ func assertSearchPath(smw *abtest.SchemaMiddleware, conn db.DB) {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(path).To(Equal(smw.GetSchemaName() + ", public"))
 }
+
+var _ = Describe("DB health check", func() {
+	It("should report an invalid connection string", func() {
+		_, conf, _ := abtest.SetupConfigMiddleware()
+		mw := dbmw.NewMiddleware(nil)
+		conf.MaybeRegisterSchema(mw)
+		_, saver, _ := conf.GetWritable("test").GetWritable("database")
+		Expect(saver.Save(dbmw.DBConfig{
+			ConnectionString: "postgres://%zz",
+		})).To(Succeed())
+
+		results := dbmw.NewHealthChecker(mw, conf).HealthCheck(context.Background())
+		Expect(results).To(HaveKey("database:test"))
+		Expect(results["database:test"]).To(HaveOccurred())
+	})
+})
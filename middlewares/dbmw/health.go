@@ -0,0 +1,83 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbmw
+
+import (
+	"context"
+
+	"github.com/alien-bunny/ab/lib/config"
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/health"
+)
+
+var _ health.HealthChecker = &HealthChecker{}
+
+// HealthChecker pings the database of every loaded site.
+type HealthChecker struct {
+	m    *Middleware
+	conf *config.Store
+}
+
+// NewHealthChecker creates a HealthChecker for the connection pools of m.
+func NewHealthChecker(m *Middleware, conf *config.Store) *HealthChecker {
+	return &HealthChecker{
+		m:    m,
+		conf: conf,
+	}
+}
+
+// HealthCheck pings the database of each site. The checks are named "database:<namespace>".
+//
+// An invalid connection string fails the check of its site, instead of panicking.
+func (h *HealthChecker) HealthCheck(ctx context.Context) map[string]error {
+	h.m.mtx.Lock()
+	h.m.ensureConnectionsMap()
+	h.m.mtx.Unlock()
+
+	results := make(map[string]error)
+
+	for _, namespace := range h.conf.Namespaces() {
+		c := h.conf.Get(namespace)
+		if c == nil {
+			continue
+		}
+
+		name := "database:" + namespace
+		confInterface, err := c.Get("database")
+		if err != nil {
+			results[name] = err
+			continue
+		}
+		if confInterface == nil {
+			continue
+		}
+
+		conf := confInterface.(DBConfig)
+		if conf.ConnectionString == "" {
+			results[name] = errors.New("empty connection string")
+			continue
+		}
+
+		conn, err := h.m.lookupConnection(conf.ConnectionString)
+		if err != nil {
+			results[name] = err
+			continue
+		}
+
+		results[name] = conn.PingContext(ctx)
+	}
+
+	return results
+}
@@ -162,6 +162,7 @@ type ErrorPageData struct {
 	Message         string
 	Logs            string
 	RequestID       string
+	Nonce           string
 }
 
 func NewErrorPageData(code int, r *http.Request) ErrorPageData {
@@ -171,6 +172,7 @@ func NewErrorPageData(code int, r *http.Request) ErrorPageData {
 		Code:            code,
 		Message:         "",
 		RequestID:       requestmw.GetRequestID(r),
+		Nonce:           render.GetNonce(r),
 	}
 }
 
@@ -181,7 +183,7 @@ var ErrorPage = template.Must(template.New("ErrorPage").Parse(`<!DOCTYPE HTML>
 	<meta http-equiv="X-UA-Compatible" content="IE=edge,chrome=1" />
 	<meta charset="utf8" />
 	<title>Error</title>
-	<style type="text/css">
+	<style type="text/css"{{if .Nonce}} nonce="{{.Nonce}}"{{end}}>
 		body {
 			background-color: #{{.BackgroundColor}};
 			color: #{{.ForegroundColor}};
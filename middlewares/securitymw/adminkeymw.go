@@ -15,27 +15,222 @@
 package securitymw
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/util"
 	"github.com/alien-bunny/ab/middlewares/errormw"
+	"github.com/alien-bunny/ab/middlewares/logmw"
 )
 
-type AdminKeyMiddleware string
+const (
+	MiddlewareDependencyAdminKey = "*securitymw.AdminKeyMiddleware"
+	CategoryAudit                = "audit"
 
-func (key AdminKeyMiddleware) Wrap(next http.Handler) http.Handler {
+	// AdminKeyScheme is the authentication scheme of the admin keys: "Authorization: AdminKey name:secret".
+	AdminKeyScheme = "AdminKey"
+
+	adminKeyComponent = "admin key middleware"
+	adminKeyKey       = "abadminkey"
+)
+
+// Admin key scopes.
+const (
+	AdminScopeInstall     = "install"
+	AdminScopeMaintenance = "maintenance"
+	AdminScopeCacheClear  = "cache-clear"
+	AdminScopeConfigRead  = "config-read"
+	AdminScopeConfigWrite = "config-write"
+)
+
+// AdminScopes contains all admin key scopes.
+var AdminScopes = []string{
+	AdminScopeInstall,
+	AdminScopeMaintenance,
+	AdminScopeCacheClear,
+	AdminScopeConfigRead,
+	AdminScopeConfigWrite,
+}
+
+var (
+	ErrAdminKeyMissing = errors.NewError("admin key missing", "Authentication required.", nil)
+	ErrAdminKeyInvalid = errors.NewError("invalid admin key", "Authentication required.", nil)
+	ErrAdminKeyExpired = errors.NewError("admin key expired", "Authentication required.", nil)
+	ErrAdminKeyScope   = errors.NewError("admin key scope missing", "Forbidden.", nil)
+)
+
+// AdminKey is a named credential for the admin endpoints.
+//
+// Only the hash of the secret is stored; see HashAdminKey(). The secrets are random values (e.g. generated with the
+// generate-secret command), so a fast hash is sufficient.
+type AdminKey struct {
+	Name string
+	// Hash is the hex encoded SHA-256 hash of the secret.
+	Hash string
+	// Scopes are the admin actions that the key can perform. See AdminScopes.
+	Scopes []string
+	// Expires is the expiry of the key. The zero value means that the key does not expire.
+	Expires time.Time
+}
+
+// HashAdminKey hashes the secret of an admin key.
+func HashAdminKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// HasScope checks if the key has a scope.
+func (k AdminKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired checks if the key is expired at the given time.
+func (k AdminKey) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && now.After(k.Expires)
+}
+
+// AdminKeyring is a set of admin keys.
+type AdminKeyring struct {
+	keys map[string]AdminKey
+}
+
+// NewAdminKeyring creates an AdminKeyring.
+func NewAdminKeyring(keys ...AdminKey) *AdminKeyring {
+	k := &AdminKeyring{
+		keys: make(map[string]AdminKey),
+	}
+
+	for _, key := range keys {
+		k.keys[key.Name] = key
+	}
+
+	return k
+}
+
+// Empty checks if the keyring has no keys.
+func (k *AdminKeyring) Empty() bool {
+	return len(k.keys) == 0
+}
+
+// Authenticate returns the key if the secret matches.
+//
+// The secret is compared in constant time. Expired keys are rejected with ErrAdminKeyExpired.
+func (k *AdminKeyring) Authenticate(name, secret string) (AdminKey, error) {
+	key, found := k.keys[name]
+
+	expected, err := hex.DecodeString(key.Hash)
+	if err != nil || len(expected) != sha256.Size {
+		expected = make([]byte, sha256.Size)
+		found = false
+	}
+
+	actual := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(expected, actual[:]) != 1 || !found {
+		return AdminKey{}, ErrAdminKeyInvalid
+	}
+
+	if key.Expired(time.Now()) {
+		return AdminKey{}, ErrAdminKeyExpired
+	}
+
+	return key, nil
+}
+
+// ParseAdminKeyHeader parses the "AdminKey name:secret" value of the Authorization header.
+func ParseAdminKeyHeader(header string) (name, secret string, ok bool) {
+	prefix := AdminKeyScheme + " "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(header[len(prefix):]), ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// AdminKeyHeader creates the value of the Authorization header for an admin key.
+func AdminKeyHeader(name, secret string) string {
+	return AdminKeyScheme + " " + name + ":" + secret
+}
+
+// AdminKeyMiddleware protects an admin endpoint with the admin keys.
+//
+// The key is sent in the Authorization header:
+//
+//		Authorization: AdminKey name:secret
+//
+// The key must have the scope of the middleware. Every accepted and rejected attempt is logged with the audit
+// category.
+type AdminKeyMiddleware struct {
+	keyring *AdminKeyring
+	scope   string
+}
+
+// NewAdminKeyMiddleware creates an AdminKeyMiddleware that requires the given scope.
+func NewAdminKeyMiddleware(keyring *AdminKeyring, scope string) *AdminKeyMiddleware {
+	return &AdminKeyMiddleware{
+		keyring: keyring,
+		scope:   scope,
+	}
+}
+
+func (m *AdminKeyMiddleware) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		urlkey := r.URL.Query().Get("key")
-		if urlkey != string(key) {
-			errors.Fail(http.StatusForbidden, errors.New("invalid key"))
+		name, secret, ok := ParseAdminKeyHeader(r.Header.Get("Authorization"))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", AdminKeyScheme)
+			errors.Fail(http.StatusUnauthorized, ErrAdminKeyMissing)
+		}
+
+		key, err := m.keyring.Authenticate(name, secret)
+		if err != nil {
+			logmw.Warn(r, adminKeyComponent, CategoryAudit).Log(
+				"key", name,
+				"scope", m.scope,
+				"error", err,
+			)
+			w.Header().Set("WWW-Authenticate", AdminKeyScheme)
+			errors.Fail(http.StatusUnauthorized, err)
+		}
+
+		if !key.HasScope(m.scope) {
+			logmw.Warn(r, adminKeyComponent, CategoryAudit).Log(
+				"key", name,
+				"scope", m.scope,
+				"error", ErrAdminKeyScope,
+			)
+			errors.Fail(http.StatusForbidden, ErrAdminKeyScope)
 		}
 
+		r = util.SetContext(r, adminKeyKey, key.Name)
+
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (key AdminKeyMiddleware) Dependencies() []string {
+func (m *AdminKeyMiddleware) Dependencies() []string {
 	return []string{
 		errormw.MiddlewareDependencyError,
+		logmw.MiddlewareDependencyLog,
 	}
 }
+
+// GetAdminKeyName returns the name of the admin key that authenticated the request.
+func GetAdminKeyName(r *http.Request) string {
+	name, _ := r.Context().Value(adminKeyKey).(string)
+	return name
+}
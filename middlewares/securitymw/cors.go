@@ -0,0 +1,230 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package securitymw
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alien-bunny/ab/lib/middleware"
+)
+
+const (
+	MiddlewareDependencyCORS = "*securitymw.CORSMiddleware"
+	csrfHeader               = "X-CSRF-Token"
+)
+
+var (
+	// DefaultCORSMethods are the allowed methods when CORSMiddleware.AllowedMethods is empty.
+	DefaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	// DefaultCORSHeaders are the allowed request headers when CORSMiddleware.AllowedHeaders is empty.
+	DefaultCORSHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"}
+)
+
+var _ middleware.Middleware = &CORSMiddleware{}
+
+// CORSMiddleware adds Cross-Origin Resource Sharing headers to the responses.
+//
+// The middleware can be configured per site with configmw.WrapMiddleware under the "cors" key:
+//
+//		{
+//			"cors": {
+//				"AllowedOrigins": ["https://app.example.com", "https://*.example.com"],
+//				"AllowCredentials": true,
+//				"MaxAge": 600
+//			}
+//		}
+//
+// Preflight requests are answered by the middleware, so it must be in the server-wide middleware stack, which runs
+// before the router.
+//
+// The X-CSRF-Token header is always allowed, because CSRFMiddleware still requires a valid token on the
+// cross-origin POST, PUT, DELETE and PATCH requests.
+type CORSMiddleware struct {
+	// AllowedOrigins is the list of the allowed origins. An origin can contain one * wildcard, e.g.
+	// "https://*.example.com". A single "*" allows every origin, but never with credentials: only the origins that
+	// match another pattern get the Access-Control-Allow-Credentials header.
+	AllowedOrigins []string
+	// AllowedMethods is the list of the allowed methods. Defaults to DefaultCORSMethods.
+	AllowedMethods []string
+	// AllowedHeaders is the list of the allowed request headers. Defaults to DefaultCORSHeaders.
+	AllowedHeaders []string
+	// ExposedHeaders is the list of the response headers that the client can read.
+	ExposedHeaders []string
+	// AllowCredentials allows sending cookies with the cross-origin requests.
+	AllowCredentials bool
+	// MaxAge is the number of seconds while the result of the preflight request can be cached.
+	MaxAge int
+
+	middleware.NoDependencies
+}
+
+func (c *CORSMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(c.AllowedOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !c.originAllowed(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if preflight {
+			c.handlePreflight(w, r, origin)
+			return
+		}
+
+		c.setOrigin(w, origin)
+		if len(c.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *CORSMiddleware) handlePreflight(w http.ResponseWriter, r *http.Request, origin string) {
+	method := r.Header.Get("Access-Control-Request-Method")
+	methods := c.methods()
+	if !containsFold(methods, method) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	headers := c.headers()
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !containsFold(headers, header) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	c.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CORSMiddleware) setOrigin(w http.ResponseWriter, origin string) {
+	// Echoing back any origin with credentials would allow every site to make authenticated requests, so the
+	// origins that are only allowed by "*" get the "*" value, which cannot be used with credentials.
+	if containsFold(c.AllowedOrigins, "*") && (!c.AllowCredentials || !c.originListed(origin)) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *CORSMiddleware) originAllowed(origin string) bool {
+	for _, pattern := range c.AllowedOrigins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// originListed tells if the origin matches a pattern other than "*".
+func (c *CORSMiddleware) originListed(origin string) bool {
+	for _, pattern := range c.AllowedOrigins {
+		if pattern != "*" && matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *CORSMiddleware) methods() []string {
+	if len(c.AllowedMethods) > 0 {
+		return c.AllowedMethods
+	}
+
+	return DefaultCORSMethods
+}
+
+func (c *CORSMiddleware) headers() []string {
+	headers := DefaultCORSHeaders
+	if len(c.AllowedHeaders) > 0 {
+		headers = c.AllowedHeaders
+	}
+
+	if containsFold(headers, csrfHeader) {
+		return headers
+	}
+
+	return append(append([]string{}, headers...), csrfHeader)
+}
+
+// matchOrigin matches an origin against a pattern with at most one * wildcard.
+//
+// The wildcard does not match the "/" character, so it cannot span over the scheme.
+func matchOrigin(pattern, origin string) bool {
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+
+	if pattern == "*" {
+		return true
+	}
+
+	i := strings.Index(pattern, "*")
+	if i == -1 {
+		return pattern == origin
+	}
+
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	if len(origin) < len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	return !strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:")
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+
+	return false
+}
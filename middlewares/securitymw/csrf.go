@@ -18,6 +18,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"net/http"
+	"strings"
 
 	"github.com/alien-bunny/ab/lib/errors"
 	"github.com/alien-bunny/ab/lib/middleware"
@@ -30,23 +31,67 @@ const (
 	MiddlewareDependencyCSRFGet = "*securitymw.CSRFGetMiddleware"
 	csrfComponent               = "csrf middleware"
 	csrfGetComponent            = "csrf get middleware"
+	bearerPrefix                = "Bearer "
 )
 
 var _ middleware.Middleware = &CSRFMiddleware{}
 var _ middleware.Middleware = &CSRFGetMiddleware{}
 
+// BearerAuthenticator validates the token of an "Authorization: Bearer" request.
+//
+// It returns the request with the identity of the token, or fails the request if the token is invalid. It returns nil
+// if it does not handle bearer tokens, and then the request is checked like any other request.
+type BearerAuthenticator func(w http.ResponseWriter, r *http.Request, token string) *http.Request
+
 // CSRFMiddleware enforces the correct X-CSRF-Token header on all POST, PUT, DELETE, PATCH requests.
 //
 // To obtain a token, use CSRFTokenHandler on a path.
-type CSRFMiddleware struct{}
+//
+// Requests with an "Authorization: Bearer" header are not checked if the token is accepted by the BearerAuthenticator,
+// see SetBearerAuthenticator(). Browsers never send that header on their own, so these requests do not carry ambient
+// credentials. The authenticator must ignore the session of such requests. Without an authenticator, the bearer
+// requests are checked like any other request.
+type CSRFMiddleware struct {
+	exempt              map[string]bool
+	bearerAuthenticator BearerAuthenticator
+}
 
 func NewCSRFMiddleware() *CSRFMiddleware {
-	return &CSRFMiddleware{}
+	return &CSRFMiddleware{
+		exempt: make(map[string]bool),
+	}
+}
+
+// Exempt disables the token check on the given paths.
+//
+// This is meant for endpoints that receive requests from the browser itself, e.g. the CSP violation reports.
+// Exempt paths must be added before the server starts.
+func (c *CSRFMiddleware) Exempt(paths ...string) *CSRFMiddleware {
+	for _, path := range paths {
+		c.exempt[path] = true
+	}
+
+	return c
+}
+
+// SetBearerAuthenticator sets the function that validates the bearer tokens.
+//
+// It must be set before the server starts.
+func (c *CSRFMiddleware) SetBearerAuthenticator(a BearerAuthenticator) *CSRFMiddleware {
+	c.bearerAuthenticator = a
+	return c
 }
 
 func (c *CSRFMiddleware) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" || r.Method == "PUT" || r.Method == "DELETE" || r.Method == "PATCH" {
+		if (r.Method == "POST" || r.Method == "PUT" || r.Method == "DELETE" || r.Method == "PATCH") && !c.exempt[r.URL.Path] {
+			if bearer := GetBearerToken(r); bearer != "" && c.bearerAuthenticator != nil {
+				if authenticated := c.bearerAuthenticator(w, r, bearer); authenticated != nil {
+					next.ServeHTTP(w, authenticated)
+					return
+				}
+			}
+
 			s := sessionmw.GetSession(r)
 			token := s["_csrf"]
 
@@ -69,6 +114,16 @@ func (c *CSRFMiddleware) Dependencies() []string {
 	return []string{logmw.MiddlewareDependencyLog, sessionmw.MiddlewareDependencySession}
 }
 
+// GetBearerToken returns the token from the "Authorization: Bearer" header of the request.
+func GetBearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(header[len(bearerPrefix):])
+}
+
 // CSRFGetMiddleware checks the CSRF token in the urlParam URL parameter.
 //
 // This is useful if you want CSRF protection in a GET request. For example, this middleware is used on the auth service's login/logout endpoints.
@@ -0,0 +1,193 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package securitymw
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/render"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+)
+
+const (
+	MiddlewareDependencySecurityHeaders = "*securitymw.SecurityHeadersMiddleware"
+	CategoryCSPViolation                = "csp violation"
+	cspReportComponent                  = "csp report"
+
+	// NoncePlaceholder is replaced with the nonce source of the request in the Content-Security-Policy.
+	NoncePlaceholder = "{nonce}"
+
+	// DisabledHeader disables a header of the SecurityHeadersMiddleware.
+	DisabledHeader = "-"
+
+	// MaxCSPReportSize is the maximum size of a CSP violation report.
+	MaxCSPReportSize = 64 << 10
+)
+
+// Default values of the SecurityHeadersMiddleware.
+const (
+	DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' " + NoncePlaceholder + "; style-src 'self' " + NoncePlaceholder + "; object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+	DefaultFrameOptions          = "DENY"
+	DefaultContentTypeOptions    = "nosniff"
+	DefaultReferrerPolicy        = "strict-origin-when-cross-origin"
+	DefaultPermissionsPolicy     = "camera=(), microphone=(), geolocation=()"
+)
+
+var _ middleware.Middleware = &SecurityHeadersMiddleware{}
+
+// SecurityHeadersMiddleware adds Content-Security-Policy, X-Frame-Options, X-Content-Type-Options, Referrer-Policy
+// and Permissions-Policy headers to the responses.
+//
+// The empty fields use the default values; DisabledHeader ("-") removes the header. The middleware can be enabled per
+// site with configmw.WrapMiddleware under the "securityheaders" key:
+//
+//		{
+//			"securityheaders": {
+//				"ContentSecurityPolicy": "default-src 'self'; script-src 'self' {nonce} https://cdn.example.com",
+//				"ReportURI": "/csp-report",
+//				"FrameOptions": "SAMEORIGIN"
+//			}
+//		}
+//
+// A route can override the policies of the site by adding its own instance.
+//
+// Every request gets a random nonce, which replaces the {nonce} placeholder in the policy. The nonce is available with
+// render.GetNonce(), and the template data of render.Renderer.HTML() can receive it by embedding render.CSPNonce.
+type SecurityHeadersMiddleware struct {
+	// ContentSecurityPolicy is the policy. Defaults to DefaultContentSecurityPolicy.
+	ContentSecurityPolicy string
+	// ReportOnly sends the policy in the Content-Security-Policy-Report-Only header.
+	ReportOnly bool
+	// ReportURI is the endpoint of the violation reports. See CSPReportHandler. It is not added if the policy already
+	// has a report-uri directive.
+	ReportURI string
+	// FrameOptions is the value of the X-Frame-Options header. Defaults to DefaultFrameOptions.
+	FrameOptions string
+	// ContentTypeOptions is the value of the X-Content-Type-Options header. Defaults to DefaultContentTypeOptions.
+	ContentTypeOptions string
+	// ReferrerPolicy is the value of the Referrer-Policy header. Defaults to DefaultReferrerPolicy.
+	ReferrerPolicy string
+	// PermissionsPolicy is the value of the Permissions-Policy header. Defaults to DefaultPermissionsPolicy.
+	PermissionsPolicy string
+
+	middleware.NoDependencies
+}
+
+func (m *SecurityHeadersMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := render.GetNonce(r)
+		if nonce == "" {
+			nonce = generateNonce()
+			r = render.WithNonce(r, nonce)
+		}
+
+		h := w.Header()
+
+		cspHeader, otherCSPHeader := "Content-Security-Policy", "Content-Security-Policy-Report-Only"
+		if m.ReportOnly {
+			cspHeader, otherCSPHeader = otherCSPHeader, cspHeader
+		}
+		h.Del(otherCSPHeader)
+		setSecurityHeader(h, cspHeader, m.policy(nonce))
+
+		setSecurityHeader(h, "X-Frame-Options", withDefault(m.FrameOptions, DefaultFrameOptions))
+		setSecurityHeader(h, "X-Content-Type-Options", withDefault(m.ContentTypeOptions, DefaultContentTypeOptions))
+		setSecurityHeader(h, "Referrer-Policy", withDefault(m.ReferrerPolicy, DefaultReferrerPolicy))
+		setSecurityHeader(h, "Permissions-Policy", withDefault(m.PermissionsPolicy, DefaultPermissionsPolicy))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *SecurityHeadersMiddleware) policy(nonce string) string {
+	policy := withDefault(m.ContentSecurityPolicy, DefaultContentSecurityPolicy)
+	if policy == DisabledHeader {
+		return policy
+	}
+
+	policy = strings.Replace(policy, NoncePlaceholder, "'nonce-"+nonce+"'", -1)
+	if m.ReportURI != "" && !hasDirective(policy, "report-uri") {
+		policy += "; report-uri " + m.ReportURI
+	}
+
+	return policy
+}
+
+// hasDirective tells if a Content-Security-Policy contains a directive.
+func hasDirective(policy, directive string) bool {
+	for _, d := range strings.Split(policy, ";") {
+		if name := strings.Fields(d); len(name) > 0 && strings.EqualFold(name[0], directive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setSecurityHeader(h http.Header, name, value string) {
+	if value == DisabledHeader {
+		h.Del(name)
+	} else {
+		h.Set(name, value)
+	}
+}
+
+func withDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+
+	return value
+}
+
+func generateNonce() string {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// CSPReportHandler logs the Content-Security-Policy violation reports.
+//
+// Both the report-uri (application/csp-report) and the Reporting API (application/reports+json) formats are accepted.
+// Browsers do not send CSRF tokens with the reports, so the path of this handler must be exempted with
+// CSRFMiddleware.Exempt().
+func CSPReportHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxCSPReportSize))
+	if err != nil {
+		errors.Fail(http.StatusBadRequest, err)
+	}
+
+	if !json.Valid(body) {
+		errors.Fail(http.StatusBadRequest, errors.New("invalid csp report"))
+	}
+
+	logmw.Warn(r, cspReportComponent, CategoryCSPViolation).Log(
+		"report", string(body),
+		"useragent", r.UserAgent(),
+	)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,357 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package securitymw
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/middlewares/configmw"
+	"github.com/alien-bunny/ab/middlewares/errormw"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+	"github.com/alien-bunny/ab/middlewares/sessionmw"
+)
+
+const (
+	MiddlewareDependencyRateLimit      = "*securitymw.RateLimitMiddleware"
+	MiddlewareDependencyRateLimitStore = "*securitymw.RateLimitStoreMiddleware"
+	rateLimitStoreKey                  = "abratelimitstore"
+	rateLimitComponent                 = "rate limit middleware"
+)
+
+// ErrRateLimited is the error of the 429 response.
+var ErrRateLimited = errors.NewError("rate limit exceeded", "Too many requests. Please try again later.", nil)
+
+// RateLimit is the configuration of a token bucket.
+type RateLimit struct {
+	// Requests is the number of tokens added to the bucket in every Period.
+	Requests int
+	// Period is the length of the refill window.
+	Period time.Duration
+	// Burst is the capacity of the bucket. Defaults to Requests.
+	Burst int
+}
+
+func (l RateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+
+	return float64(l.Requests)
+}
+
+// rate returns the number of tokens added per second.
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// RateLimitResult is the state of a bucket after taking a token.
+type RateLimitResult struct {
+	// Allowed tells if a token was available.
+	Allowed bool
+	// Remaining is the number of the remaining tokens.
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next token is available. It is 0 if the request is allowed.
+	RetryAfter time.Duration
+}
+
+func newRateLimitResult(limit RateLimit, tokens float64, allowed bool) RateLimitResult {
+	rate := limit.rate()
+	res := RateLimitResult{
+		Allowed:   allowed,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     time.Duration((limit.capacity() - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+
+	return res
+}
+
+// RateLimitStore stores the token buckets.
+type RateLimitStore interface {
+	// Take takes a token from the bucket identified by key.
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+var _ RateLimitStore = &MemoryRateLimitStore{}
+
+// MemoryRateLimitStore stores the token buckets in memory.
+//
+// This store is only suitable for a single server. Use PostgresRateLimitStore for clusters.
+type MemoryRateLimitStore struct {
+	mtx     sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens   float64
+	updated  time.Time
+	capacity float64
+	rate     float64
+}
+
+// NewMemoryRateLimitStore creates a MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take takes a token from a bucket.
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := s.now()
+
+	s.takes++
+	if s.takes%1024 == 0 {
+		s.gc(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens:  limit.capacity(),
+			updated: now,
+		}
+		s.buckets[key] = b
+	}
+
+	b.capacity = limit.capacity()
+	b.rate = limit.rate()
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	return newRateLimitResult(limit, b.tokens, allowed), nil
+}
+
+// gc removes the buckets that are full again.
+func (s *MemoryRateLimitStore) gc(now time.Time) {
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.rate >= b.capacity {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+var _ middleware.Middleware = &RateLimitStoreMiddleware{}
+
+// RateLimitStoreMiddleware puts a RateLimitStore into the request context for the RateLimitMiddleware instances.
+type RateLimitStoreMiddleware struct {
+	store RateLimitStore
+
+	middleware.NoDependencies
+}
+
+// NewRateLimitStoreMiddleware creates a RateLimitStoreMiddleware.
+func NewRateLimitStoreMiddleware(store RateLimitStore) *RateLimitStoreMiddleware {
+	return &RateLimitStoreMiddleware{
+		store: store,
+	}
+}
+
+func (m *RateLimitStoreMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = util.SetContext(r, rateLimitStoreKey, m.store)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetRateLimitStore returns the RateLimitStore from the request context.
+func GetRateLimitStore(r *http.Request) RateLimitStore {
+	return r.Context().Value(rateLimitStoreKey).(RateLimitStore)
+}
+
+// RateLimitKeyFunc returns the identifier of the client. An empty string means that the request is not limited.
+type RateLimitKeyFunc func(r *http.Request, trustForwardedFor bool) string
+
+var rateLimitKeyFuncs = map[string]RateLimitKeyFunc{
+	"ip":      RateLimitKeyIP,
+	"session": RateLimitKeySession,
+}
+
+// RegisterRateLimitKey registers a key function that can be referenced in RateLimitMiddleware.Key.
+func RegisterRateLimitKey(name string, f RateLimitKeyFunc) {
+	rateLimitKeyFuncs[name] = f
+}
+
+// RateLimitKeyIP identifies the clients by their IP address.
+//
+// If trustForwardedFor is set, the last address of the X-Forwarded-For header is used, which is the address that the
+// reverse proxy has seen. Only enable it behind a reverse proxy that sets this header.
+func RateLimitKeyIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addresses := strings.Split(forwarded, ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// RateLimitKeySession identifies the clients by their session ID.
+//
+// The session middleware must run before the RateLimitMiddleware. Since clients without a session cookie get a new
+// session for every request, this key should be combined with an IP based limit.
+func RateLimitKeySession(r *http.Request, trustForwardedFor bool) string {
+	return "session:" + sessionmw.GetSession(r).Id()
+}
+
+var _ middleware.Middleware = &RateLimitMiddleware{}
+
+// RateLimitMiddleware limits the number of requests of the clients with a token bucket.
+//
+// The middleware can be configured per site with configmw.WrapMiddleware under the "ratelimit" key:
+//
+//		{
+//			"ratelimit": {
+//				"Requests": 60,
+//				"Period": "1m",
+//				"Burst": 10,
+//				"Key": "ip"
+//			}
+//		}
+//
+// It can be added to individual routes as well, to set stricter limits for sensitive endpoints.
+// The buckets are stored in the RateLimitStore of the request; see RateLimitStoreMiddleware.
+//
+// The state of the bucket is reported with the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// Rejected requests fail with 429 and a Retry-After header.
+type RateLimitMiddleware struct {
+	// Requests is the number of allowed requests in Period.
+	Requests int
+	// Period is the length of the refill window, e.g. "1m". Defaults to a minute.
+	Period string
+	// Burst is the number of requests that can be made at once. Defaults to Requests.
+	Burst int
+	// Key selects the key function: "ip" (default), "session" or a name registered with RegisterRateLimitKey().
+	Key string
+	// Scope separates the buckets of the different limits. Defaults to "default".
+	Scope string
+	// TrustForwardedFor enables using the X-Forwarded-For header to identify the clients.
+	TrustForwardedFor bool
+}
+
+func (m *RateLimitMiddleware) limit() (RateLimit, error) {
+	period := time.Minute
+	if m.Period != "" {
+		var err error
+		if period, err = time.ParseDuration(m.Period); err != nil {
+			return RateLimit{}, err
+		}
+	}
+	if period <= 0 {
+		return RateLimit{}, errors.New("invalid rate limit period")
+	}
+
+	return RateLimit{
+		Requests: m.Requests,
+		Period:   period,
+		Burst:    m.Burst,
+	}, nil
+}
+
+func (m *RateLimitMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.Requests <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit, err := m.limit()
+		if err != nil {
+			errors.Fail(http.StatusInternalServerError, err)
+		}
+
+		keyName := m.Key
+		if keyName == "" {
+			keyName = "ip"
+		}
+		keyFunc, ok := rateLimitKeyFuncs[keyName]
+		if !ok {
+			errors.Fail(http.StatusInternalServerError, errors.New("rate limit key function not found: "+keyName))
+		}
+
+		clientKey := keyFunc(r, m.TrustForwardedFor)
+		if clientKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scope := m.Scope
+		if scope == "" {
+			scope = "default"
+		}
+
+		key := configmw.GetNamespace(r) + ":" + scope + ":" + clientKey
+
+		res, err := GetRateLimitStore(r).Take(key, limit)
+		if err != nil {
+			logmw.Error(r, rateLimitComponent, nil).Log("error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(int(limit.capacity())))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			logmw.Debug(r, rateLimitComponent, nil).Log("key", key)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			errors.Fail(http.StatusTooManyRequests, ErrRateLimited)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *RateLimitMiddleware) Dependencies() []string {
+	return []string{
+		MiddlewareDependencyRateLimitStore,
+		errormw.MiddlewareDependencyError,
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package securitymw
+
+import (
+	"time"
+
+	"github.com/alien-bunny/ab/lib/db"
+)
+
+var _ RateLimitStore = &PostgresRateLimitStore{}
+
+// PostgresRateLimitStore stores the token buckets in a PostgreSQL table, so the limits are shared between the servers
+// of a cluster.
+//
+// The bucket is refilled and taken from in a single statement, so concurrent requests are counted correctly.
+type PostgresRateLimitStore struct {
+	conn  db.DB
+	table string
+}
+
+// NewPostgresRateLimitStore creates a PostgresRateLimitStore. The table is created with CreateTable().
+func NewPostgresRateLimitStore(conn db.DB) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{
+		conn:  conn,
+		table: "ratelimit",
+	}
+}
+
+// CreateTable creates the table of the buckets if it does not exist.
+func (s *PostgresRateLimitStore) CreateTable() error {
+	_, err := s.conn.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + s.table + `(
+			key text NOT NULL PRIMARY KEY,
+			tokens double precision NOT NULL,
+			allowed boolean NOT NULL,
+			updated timestamp with time zone NOT NULL
+		)
+	`)
+
+	return err
+}
+
+// Take takes a token from a bucket.
+func (s *PostgresRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	var tokens float64
+	var allowed bool
+
+	// $2 is the capacity, $3 is the refill rate per second.
+	refilled := `LEAST($2::double precision, ` + s.table + `.tokens + EXTRACT(EPOCH FROM now() - ` + s.table + `.updated)::double precision * $3::double precision)`
+	err := s.conn.QueryRow(`
+		INSERT INTO `+s.table+`(key, tokens, allowed, updated) VALUES ($1, $2::double precision - 1, $2::double precision >= 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = CASE WHEN `+refilled+` >= 1 THEN `+refilled+` - 1 ELSE `+refilled+` END,
+			allowed = `+refilled+` >= 1,
+			updated = now()
+		RETURNING tokens, allowed
+	`, key, limit.capacity(), limit.rate()).Scan(&tokens, &allowed)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	return newRateLimitResult(limit, tokens, allowed), nil
+}
+
+// DeleteIdle removes the buckets that have not been used for the given duration.
+//
+// It should be called periodically, e.g. on the maintenance event. The duration must be longer than the time that is
+// needed to refill the largest bucket.
+func (s *PostgresRateLimitStore) DeleteIdle(idle time.Duration) error {
+	_, err := s.conn.Exec(`DELETE FROM `+s.table+` WHERE updated < $1`, time.Now().Add(-idle))
+
+	return err
+}
@@ -22,13 +22,17 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"time"
 
 	"golang.org/x/text/language"
 
 	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/errors"
 	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/render"
 	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/middlewares/configmw"
 	"github.com/alien-bunny/ab/middlewares/errormw"
 	"github.com/alien-bunny/ab/middlewares/logmw"
 	"github.com/alien-bunny/ab/middlewares/securitymw"
@@ -77,6 +81,50 @@ var _ = Describe("CSRF Middlewares", func() {
 		Expect(body).NotTo(ContainSubstring(msg))
 	})
 
+	It("should only skip the check of the requests with an authenticated bearer token", func() {
+		bearerStack := func(authenticator securitymw.BearerAuthenticator) *middleware.Stack {
+			s := middleware.NewStack(nil)
+			s.Push(cmw)
+			s.Push(logmw.New(logger))
+			s.Push(smw)
+			s.Push(translationmw.New(logger, []language.Tag{language.English}))
+			s.Push(errormw.New(true))
+			s.Push(securitymw.NewCSRFMiddleware().SetBearerAuthenticator(authenticator))
+
+			return s
+		}
+		request := func(s *middleware.Stack, token string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r, reqerr := abtest.NewRequest("POST", "/", nil)
+			Expect(reqerr).NotTo(HaveOccurred())
+			r.Header.Set("Authorization", "bearer "+token)
+			s.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(securitymw.GetBearerToken(r)).To(Equal(token))
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r)
+
+			return w
+		}
+
+		By("checking the bearer requests without an authenticator")
+		Expect(request(stack, "abc123").Code).To(Equal(http.StatusForbidden))
+		Expect(request(bearerStack(func(w http.ResponseWriter, r *http.Request, token string) *http.Request {
+			return nil
+		}), "abc123").Code).To(Equal(http.StatusForbidden))
+
+		By("authenticating the bearer token")
+		authenticated := bearerStack(func(w http.ResponseWriter, r *http.Request, token string) *http.Request {
+			if token != "abc123" {
+				errors.Fail(http.StatusUnauthorized, errors.New("invalid token"))
+			}
+
+			return r
+		})
+		Expect(request(authenticated, "abc123").Code).To(Equal(http.StatusNoContent))
+		Expect(request(authenticated, "invalid").Code).To(Equal(http.StatusUnauthorized))
+		Expect(request(stack, "abc123").Code).To(Equal(http.StatusForbidden))
+	})
+
 	It("should generate a token", func() {
 		w := abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {
 			token := securitymw.GetCSRFToken(r)
@@ -199,3 +247,509 @@ var _ = Describe("LengthLimitMiddleware", func() {
 		})).ServeHTTP(w, r)
 	})
 })
+
+var _ = Describe("RateLimit Middleware", func() {
+	logger, conf, cmw := abtest.SetupConfigMiddleware()
+
+	newStack := func(mws ...middleware.Middleware) *middleware.Stack {
+		stack := middleware.NewStack(nil)
+		stack.Push(cmw)
+		stack.Push(logmw.New(logger))
+		stack.Push(translationmw.New(logger, []language.Tag{language.English}))
+		stack.Push(errormw.New(true))
+		stack.Push(securitymw.NewRateLimitStoreMiddleware(securitymw.NewMemoryRateLimitStore()))
+		for _, mw := range mws {
+			stack.Push(mw)
+		}
+
+		return stack
+	}
+
+	request := func(stack *middleware.Stack, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, reqerr := abtest.NewRequest("GET", "/", nil)
+		Expect(reqerr).NotTo(HaveOccurred())
+		r.RemoteAddr = ip + ":12345"
+		stack.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})).ServeHTTP(w, r)
+
+		return w
+	}
+
+	It("should reject the requests above the limit", func() {
+		stack := newStack(&securitymw.RateLimitMiddleware{
+			Requests: 2,
+			Period:   "1h",
+		})
+
+		w := request(stack, "10.0.0.1")
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(w.Header().Get("RateLimit-Limit")).To(Equal("2"))
+		Expect(w.Header().Get("RateLimit-Remaining")).To(Equal("1"))
+		Expect(w.Header().Get("RateLimit-Reset")).To(Equal("1800"))
+
+		w = request(stack, "10.0.0.1")
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(w.Header().Get("RateLimit-Remaining")).To(Equal("0"))
+
+		w = request(stack, "10.0.0.1")
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("1800"))
+
+		w = request(stack, "10.0.0.2")
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should refill the bucket", func() {
+		stack := newStack(&securitymw.RateLimitMiddleware{
+			Requests: 1,
+			Period:   "100ms",
+		})
+
+		Expect(request(stack, "10.0.0.1").Code).To(Equal(http.StatusNoContent))
+		Expect(request(stack, "10.0.0.1").Code).To(Equal(http.StatusTooManyRequests))
+		time.Sleep(150 * time.Millisecond)
+		Expect(request(stack, "10.0.0.1").Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should allow bursts", func() {
+		stack := newStack(&securitymw.RateLimitMiddleware{
+			Requests: 1,
+			Period:   "1h",
+			Burst:    3,
+		})
+
+		for i := 0; i < 3; i++ {
+			Expect(request(stack, "10.0.0.1").Code).To(Equal(http.StatusNoContent))
+		}
+		Expect(request(stack, "10.0.0.1").Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("should separate the scopes", func() {
+		stack := newStack(
+			&securitymw.RateLimitMiddleware{
+				Requests: 2,
+				Period:   "1h",
+			},
+			&securitymw.RateLimitMiddleware{
+				Requests: 1,
+				Period:   "1h",
+				Scope:    "strict",
+			},
+		)
+
+		w := request(stack, "10.0.0.1")
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(request(stack, "10.0.0.1").Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("should use the custom key functions", func() {
+		securitymw.RegisterRateLimitKey("test", func(r *http.Request, trustForwardedFor bool) string {
+			return r.Header.Get("X-Test-Client")
+		})
+		stack := newStack(&securitymw.RateLimitMiddleware{
+			Requests: 1,
+			Period:   "1h",
+			Key:      "test",
+		})
+
+		for i := 0; i < 3; i++ {
+			Expect(request(stack, "10.0.0.1").Code).To(Equal(http.StatusNoContent))
+		}
+	})
+
+	It("should be configurable per site", func() {
+		wrapper := configmw.WrapMiddleware("ratelimit", reflect.TypeOf(securitymw.RateLimitMiddleware{}))
+		conf.MaybeRegisterSchema(wrapper)
+		_, saver, err := conf.GetWritable("test").GetWritable("ratelimit")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(saver.Save(securitymw.RateLimitMiddleware{
+			Requests: 1,
+			Period:   "1h",
+		})).To(Succeed())
+
+		stack := newStack(wrapper)
+
+		Expect(request(stack, "10.0.0.1").Code).To(Equal(http.StatusNoContent))
+		Expect(request(stack, "10.0.0.1").Code).To(Equal(http.StatusTooManyRequests))
+	})
+})
+
+var _ = Describe("MemoryRateLimitStore", func() {
+	It("should report the state of the bucket", func() {
+		store := securitymw.NewMemoryRateLimitStore()
+		limit := securitymw.RateLimit{
+			Requests: 60,
+			Period:   time.Minute,
+			Burst:    2,
+		}
+
+		res, err := store.Take("key", limit)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.Allowed).To(BeTrue())
+		Expect(res.Remaining).To(Equal(1))
+		Expect(res.RetryAfter).To(BeZero())
+
+		res, _ = store.Take("key", limit)
+		Expect(res.Allowed).To(BeTrue())
+		Expect(res.Remaining).To(Equal(0))
+
+		res, _ = store.Take("key", limit)
+		Expect(res.Allowed).To(BeFalse())
+		Expect(res.RetryAfter).To(BeNumerically(">", 0))
+		Expect(res.RetryAfter).To(BeNumerically("<=", time.Second))
+	})
+})
+
+var _ = Describe("CORS Middleware", func() {
+	stack := middleware.NewStack(nil)
+	stack.Push(&securitymw.CORSMiddleware{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedHeaders:   []string{"Content-Type"},
+		ExposedHeaders:   []string{"RateLimit-Remaining"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+
+	request := func(method, origin string, headers map[string]string) (*httptest.ResponseRecorder, bool) {
+		w := httptest.NewRecorder()
+		r, reqerr := abtest.NewRequest(method, "/", nil)
+		Expect(reqerr).NotTo(HaveOccurred())
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		called := false
+		stack.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(w, r)
+
+		return w, called
+	}
+
+	It("should answer the preflight requests", func() {
+		w, called := request("OPTIONS", "https://app.example.com", map[string]string{
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "content-type, x-csrf-token",
+		})
+		Expect(called).To(BeFalse())
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+		Expect(w.Header().Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+		Expect(w.Header().Get("Access-Control-Allow-Methods")).To(ContainSubstring("POST"))
+		Expect(w.Header().Get("Access-Control-Allow-Headers")).To(Equal("Content-Type, X-CSRF-Token"))
+		Expect(w.Header().Get("Access-Control-Max-Age")).To(Equal("600"))
+		Expect(w.Header()["Vary"]).To(ContainElement("Origin"))
+	})
+
+	It("should reject the preflight requests with unknown headers", func() {
+		w, called := request("OPTIONS", "https://app.example.com", map[string]string{
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "X-Unknown",
+		})
+		Expect(called).To(BeFalse())
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	It("should reject the preflight requests from unknown origins", func() {
+		w, called := request("OPTIONS", "https://evil.example.com", map[string]string{
+			"Access-Control-Request-Method": "POST",
+		})
+		Expect(called).To(BeFalse())
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should add the headers to the allowed requests", func() {
+		w, called := request("GET", "https://api.example.org", nil)
+		Expect(called).To(BeTrue())
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://api.example.org"))
+		Expect(w.Header().Get("Access-Control-Expose-Headers")).To(Equal("RateLimit-Remaining"))
+	})
+
+	It("should not add the headers to the requests from unknown origins", func() {
+		w, called := request("GET", "https://example.org.evil.com", nil)
+		Expect(called).To(BeTrue())
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	It("should pass through the same-origin requests", func() {
+		w, called := request("OPTIONS", "", nil)
+		Expect(called).To(BeTrue())
+		Expect(w.Header().Get("Vary")).To(BeEmpty())
+	})
+
+	It("should use the wildcard without credentials", func() {
+		wildcard := middleware.NewStack(nil)
+		wildcard.Push(&securitymw.CORSMiddleware{
+			AllowedOrigins: []string{"*"},
+		})
+
+		w := httptest.NewRecorder()
+		r, reqerr := abtest.NewRequest("GET", "/", nil)
+		Expect(reqerr).NotTo(HaveOccurred())
+		r.Header.Set("Origin", "https://example.com")
+		wildcard.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		})).ServeHTTP(w, r)
+
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("*"))
+		Expect(w.Header().Get("Access-Control-Allow-Credentials")).To(BeEmpty())
+	})
+
+	It("should not allow credentials for the origins that are only allowed by the wildcard", func() {
+		wildcard := middleware.NewStack(nil)
+		wildcard.Push(&securitymw.CORSMiddleware{
+			AllowedOrigins:   []string{"https://app.example.com", "*"},
+			AllowCredentials: true,
+		})
+		handler := wildcard.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		}))
+
+		request := func(origin string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r, reqerr := abtest.NewRequest("GET", "/", nil)
+			Expect(reqerr).NotTo(HaveOccurred())
+			r.Header.Set("Origin", origin)
+			handler.ServeHTTP(w, r)
+
+			return w
+		}
+
+		w := request("https://evil.example.net")
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("*"))
+		Expect(w.Header().Get("Access-Control-Allow-Credentials")).To(BeEmpty())
+
+		w = request("https://app.example.com")
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+		Expect(w.Header().Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+	})
+})
+
+var _ = Describe("SecurityHeaders Middleware", func() {
+	logger, _, cmw := abtest.SetupConfigMiddleware()
+
+	newStack := func(mws ...middleware.Middleware) *middleware.Stack {
+		stack := middleware.NewStack(nil)
+		stack.Push(cmw)
+		stack.Push(logmw.New(logger))
+		stack.Push(translationmw.New(logger, []language.Tag{language.English}))
+		for _, mw := range mws {
+			stack.Push(mw)
+		}
+
+		return stack
+	}
+
+	It("should add the default headers with a nonce", func() {
+		var nonce string
+		w := abtest.TestMiddleware(newStack(&securitymw.SecurityHeadersMiddleware{}), func(w http.ResponseWriter, r *http.Request) {
+			nonce = render.GetNonce(r)
+		})
+
+		Expect(nonce).NotTo(BeEmpty())
+		Expect(w.Header().Get("Content-Security-Policy")).To(ContainSubstring("script-src 'self' 'nonce-" + nonce + "'"))
+		Expect(w.Header().Get("X-Frame-Options")).To(Equal(securitymw.DefaultFrameOptions))
+		Expect(w.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(w.Header().Get("Referrer-Policy")).To(Equal(securitymw.DefaultReferrerPolicy))
+		Expect(w.Header().Get("Permissions-Policy")).To(Equal(securitymw.DefaultPermissionsPolicy))
+	})
+
+	It("should generate a new nonce for every request", func() {
+		stack := newStack(&securitymw.SecurityHeadersMiddleware{})
+		first := abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {})
+		second := abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {})
+
+		Expect(first.Header().Get("Content-Security-Policy")).NotTo(Equal(second.Header().Get("Content-Security-Policy")))
+	})
+
+	It("should let a route override the policies", func() {
+		var nonces []string
+		nonceCollector := middleware.Func(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nonces = append(nonces, render.GetNonce(r))
+				next.ServeHTTP(w, r)
+			})
+		})
+		stack := newStack(
+			&securitymw.SecurityHeadersMiddleware{ReportURI: "/csp-report"},
+			nonceCollector,
+			&securitymw.SecurityHeadersMiddleware{
+				ContentSecurityPolicy: "script-src " + securitymw.NoncePlaceholder,
+				ReportOnly:            true,
+				FrameOptions:          securitymw.DisabledHeader,
+			},
+		)
+
+		w := abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {
+			nonces = append(nonces, render.GetNonce(r))
+		})
+
+		Expect(nonces).To(HaveLen(2))
+		Expect(nonces[1]).To(Equal(nonces[0]))
+		Expect(w.Header().Get("Content-Security-Policy")).To(BeEmpty())
+		Expect(w.Header().Get("Content-Security-Policy-Report-Only")).To(Equal("script-src 'nonce-" + nonces[0] + "'"))
+		Expect(w.Header().Get("X-Frame-Options")).To(BeEmpty())
+	})
+
+	It("should not add a second report-uri", func() {
+		stack := newStack(&securitymw.SecurityHeadersMiddleware{
+			ContentSecurityPolicy: "default-src 'self'; report-uri /custom-report",
+			ReportURI:             "/csp-report",
+		})
+		w := abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {})
+
+		Expect(w.Header().Get("Content-Security-Policy")).To(Equal("default-src 'self'; report-uri /custom-report"))
+
+		stack = newStack(&securitymw.SecurityHeadersMiddleware{
+			ContentSecurityPolicy: "default-src 'self'",
+			ReportURI:             "/csp-report",
+		})
+		w = abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {})
+
+		Expect(w.Header().Get("Content-Security-Policy")).To(Equal("default-src 'self'; report-uri /csp-report"))
+	})
+
+	It("should add the nonce to the error page", func() {
+		var nonce string
+		stack := newStack(&securitymw.SecurityHeadersMiddleware{}, errormw.New(false))
+		w := abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {
+			nonce = render.GetNonce(r)
+			errors.Fail(http.StatusNotFound, errors.New("not found"))
+		})
+
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+		Expect(w.Body.String()).To(ContainSubstring(`<style type="text/css" nonce="` + nonce + `">`))
+	})
+
+	It("should accept the violation reports", func() {
+		stack := newStack(errormw.New(false))
+		report := `{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src"}}`
+
+		w := httptest.NewRecorder()
+		r, reqerr := abtest.NewRequest("POST", "/csp-report", bytes.NewBufferString(report))
+		Expect(reqerr).NotTo(HaveOccurred())
+		r.Header.Set("Content-Type", "application/csp-report")
+		stack.Wrap(http.HandlerFunc(securitymw.CSPReportHandler)).ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+
+		w = httptest.NewRecorder()
+		r, reqerr = abtest.NewRequest("POST", "/csp-report", bytes.NewBufferString("{"))
+		Expect(reqerr).NotTo(HaveOccurred())
+		stack.Wrap(http.HandlerFunc(securitymw.CSPReportHandler)).ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+})
+
+var _ = Describe("AdminKey Middleware", func() {
+	logger, _, cmw := abtest.SetupConfigMiddleware()
+
+	keyring := securitymw.NewAdminKeyring(
+		securitymw.AdminKey{
+			Name:   "deploy",
+			Hash:   securitymw.HashAdminKey("deploysecret"),
+			Scopes: []string{securitymw.AdminScopeInstall, securitymw.AdminScopeMaintenance},
+		},
+		securitymw.AdminKey{
+			Name:    "old",
+			Hash:    securitymw.HashAdminKey("oldsecret"),
+			Scopes:  securitymw.AdminScopes,
+			Expires: time.Now().Add(-time.Hour),
+		},
+	)
+
+	stack := middleware.NewStack(nil)
+	stack.Push(cmw)
+	stack.Push(logmw.New(logger))
+	stack.Push(translationmw.New(logger, []language.Tag{language.English}))
+	stack.Push(errormw.New(false))
+	stack.Push(securitymw.NewAdminKeyMiddleware(keyring, securitymw.AdminScopeInstall))
+
+	request := func(authorization string) (*httptest.ResponseRecorder, string) {
+		w := httptest.NewRecorder()
+		r, reqerr := abtest.NewRequest("GET", "/install", nil)
+		Expect(reqerr).NotTo(HaveOccurred())
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		keyName := ""
+		stack.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keyName = securitymw.GetAdminKeyName(r)
+			w.WriteHeader(http.StatusNoContent)
+		})).ServeHTTP(w, r)
+
+		return w, keyName
+	}
+
+	It("should accept a valid key with the scope", func() {
+		w, keyName := request(securitymw.AdminKeyHeader("deploy", "deploysecret"))
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(keyName).To(Equal("deploy"))
+	})
+
+	It("should reject the missing keys", func() {
+		w, _ := request("")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(w.Header().Get("WWW-Authenticate")).To(Equal(securitymw.AdminKeyScheme))
+	})
+
+	It("should reject an invalid secret", func() {
+		w, _ := request(securitymw.AdminKeyHeader("deploy", "wrong"))
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should reject an unknown key", func() {
+		w, _ := request(securitymw.AdminKeyHeader("unknown", "deploysecret"))
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should reject an expired key", func() {
+		w, _ := request(securitymw.AdminKeyHeader("old", "oldsecret"))
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should reject a key without the scope", func() {
+		scoped := middleware.NewStack(nil)
+		scoped.Push(cmw)
+		scoped.Push(logmw.New(logger))
+		scoped.Push(translationmw.New(logger, []language.Tag{language.English}))
+		scoped.Push(errormw.New(false))
+		scoped.Push(securitymw.NewAdminKeyMiddleware(keyring, securitymw.AdminScopeCacheClear))
+
+		w := httptest.NewRecorder()
+		r, reqerr := abtest.NewRequest("GET", "/cache-clear", nil)
+		Expect(reqerr).NotTo(HaveOccurred())
+		r.Header.Set("Authorization", securitymw.AdminKeyHeader("deploy", "deploysecret"))
+		scoped.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		})).ServeHTTP(w, r)
+
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should ignore the query parameter", func() {
+		w := httptest.NewRecorder()
+		r, reqerr := abtest.NewRequest("GET", "/install?key=deploysecret", nil)
+		Expect(reqerr).NotTo(HaveOccurred())
+		stack.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		})).ServeHTTP(w, r)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	DescribeTable("the header parsing",
+		func(header, name, secret string, ok bool) {
+			n, s, o := securitymw.ParseAdminKeyHeader(header)
+			Expect(o).To(Equal(ok))
+			Expect(n).To(Equal(name))
+			Expect(s).To(Equal(secret))
+		},
+		Entry("valid", "AdminKey a:b", "a", "b", true),
+		Entry("case insensitive scheme", "adminkey a:b:c", "a", "b:c", true),
+		Entry("wrong scheme", "Bearer a:b", "", "", false),
+		Entry("missing secret", "AdminKey a", "", "", false),
+		Entry("empty", "", "", "", false),
+	)
+})
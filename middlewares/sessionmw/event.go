@@ -0,0 +1,78 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessionmw
+
+import (
+	"net/http"
+
+	"github.com/alien-bunny/ab/lib/event"
+	"github.com/alien-bunny/ab/lib/session"
+)
+
+const (
+	// EventSessionExpired is dispatched when an expired session is replaced with an empty one.
+	EventSessionExpired = "session-expired"
+
+	// The reasons of the session expiry.
+	ExpiredReasonIdle              = "idle"
+	ExpiredReasonAbsolute          = "absolute"
+	ExpiredReasonMissingTimestamps = "missing timestamps"
+)
+
+var _ event.Event = &SessionExpiredEvent{}
+
+// SessionExpiredEvent is dispatched when a session expires.
+//
+// The subscribers can check the expired session (e.g. the logged in user), and the request. The handlers can use
+// Expired() to react to the expiry, e.g. redirect to the login page.
+type SessionExpiredEvent struct {
+	r       *http.Request
+	session session.Session
+	reason  string
+}
+
+// NewSessionExpiredEvent creates a SessionExpiredEvent.
+func NewSessionExpiredEvent(r *http.Request, sess session.Session, reason string) *SessionExpiredEvent {
+	return &SessionExpiredEvent{
+		r:       r,
+		session: sess,
+		reason:  reason,
+	}
+}
+
+// Name of the event. Always returns EventSessionExpired.
+func (e *SessionExpiredEvent) Name() string {
+	return EventSessionExpired
+}
+
+// ErrorStrategy of the event. Always returns event.ErrorStrategyAggregate.
+func (e *SessionExpiredEvent) ErrorStrategy() event.ErrorStrategy {
+	return event.ErrorStrategyAggregate
+}
+
+// Request returns the current request.
+func (e *SessionExpiredEvent) Request() *http.Request {
+	return e.r
+}
+
+// Session returns the expired session.
+func (e *SessionExpiredEvent) Session() session.Session {
+	return e.session
+}
+
+// Reason returns the reason of the expiry: ExpiredReasonIdle, ExpiredReasonAbsolute or ExpiredReasonMissingTimestamps.
+func (e *SessionExpiredEvent) Reason() string {
+	return e.reason
+}
@@ -15,11 +15,16 @@
 package sessionmw
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/alien-bunny/ab/lib/event"
 	"github.com/alien-bunny/ab/lib/middleware"
 	"github.com/alien-bunny/ab/lib/session"
 	"github.com/alien-bunny/ab/lib/util"
@@ -31,6 +36,27 @@ const (
 	MiddlewareDependencySession = "*sessionmw.SessionMiddleware"
 	sessionComponent            = "session middleware"
 	sessionContextKey           = "SESSION"
+	sessionExpiredContextKey    = "SESSIONEXPIRED"
+
+	// SessionKeyIssuedAt is the session key of the time when the session was issued, in unix seconds.
+	SessionKeyIssuedAt = "_iat"
+	// SessionKeyLastSeen is the session key of the time of the last request of the session, in unix seconds.
+	SessionKeyLastSeen = "_seen"
+
+	// storeTouchInterval is the minimum time between two saves of an unchanged session in the Store.
+	storeTouchInterval = time.Minute
+	// lastSeenInterval is the maximum time between two updates of SessionKeyLastSeen. Since an update re-issues the
+	// cookie, the updates are throttled.
+	lastSeenInterval = time.Minute
+
+	// cookieChunkSize is the maximum length of the value of a session cookie. The browsers limit the size of a cookie
+	// to about 4096 bytes, so larger sessions are split into numbered cookies: _SESSION, _SESSION_1, _SESSION_2...
+	cookieChunkSize = 3800
+	// maxCookieChunks is the maximum number of the session cookies. Larger sessions are not saved, and the session
+	// cookies are deleted, because the proxies and the servers limit the size of the request headers.
+	maxCookieChunks = 8
+	// sessionSizeWarning is the length of the encoded session that triggers a warning.
+	sessionSizeWarning = 3000
 )
 
 // GetSession returns the session from the http request context.
@@ -38,9 +64,82 @@ func GetSession(r *http.Request) session.Session {
 	return r.Context().Value(sessionContextKey).(session.Session)
 }
 
+// Expired tells if the session of the request has expired, and it has been replaced with an empty session.
+func Expired(r *http.Request) bool {
+	expired, _ := r.Context().Value(sessionExpiredContextKey).(bool)
+	return expired
+}
+
+// Config is the per-site configuration of the SessionMiddleware.
 type Config struct {
-	Key       string
+	// Key is the hex encoded secret key that signs the sessions. It is appended to Keys.
+	Key string
+	// Keys is a list of hex encoded secret keys. The first key signs the sessions, and every key verifies them.
+	//
+	// To rotate the key, put the new key in the beginning of the list. The sessions that are signed with an old key are
+	// signed again with the new key on their next response, so the old key can be removed after the sessions expire.
+	Keys      []string
 	CookieURL string
+	// SameSite sets the SameSite attribute of the session cookies: "Lax", "Strict" or "None". The attribute is
+	// omitted if it is empty. "None" requires a https:// CookieURL, because the browsers reject insecure
+	// SameSite=None cookies.
+	SameSite string
+	// Encrypt encrypts the session cookies, so the client cannot read the session data. Both signed and encrypted
+	// cookies are accepted regardless of this setting, so it can be changed without logging out the users.
+	// The first key must be session.EncryptionKeySize bytes long.
+	Encrypt bool
+	// IdleTimeout expires the sessions that have not been used for the given duration, e.g. "30m".
+	IdleTimeout string
+	// AbsoluteTimeout expires the sessions after the given duration since they were issued, e.g. "24h".
+	//
+	// When either timeout is enabled, the sessions without timestamps (issued before the timeouts were supported)
+	// are expired as well.
+	AbsoluteTimeout string
+}
+
+// Timeouts returns the parsed IdleTimeout and AbsoluteTimeout. A zero duration means that the timeout is disabled.
+func (c Config) Timeouts() (idle, absolute time.Duration, err error) {
+	if c.IdleTimeout != "" {
+		if idle, err = time.ParseDuration(c.IdleTimeout); err != nil {
+			return 0, 0, err
+		}
+	}
+	if c.AbsoluteTimeout != "" {
+		if absolute, err = time.ParseDuration(c.AbsoluteTimeout); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return idle, absolute, nil
+}
+
+// SameSiteMode returns the normalized SameSite attribute.
+func (c Config) SameSiteMode() (string, error) {
+	switch strings.ToLower(c.SameSite) {
+	case "":
+		return "", nil
+	case "lax":
+		return "Lax", nil
+	case "strict":
+		return "Strict", nil
+	case "none":
+		return "None", nil
+	}
+
+	return "", fmt.Errorf("invalid SameSite value: %q", c.SameSite)
+}
+
+// SecretKeys returns the parsed keys. The first key signs the sessions.
+func (c Config) SecretKeys() ([]session.SecretKey, error) {
+	encoded := c.Keys
+	if c.Key != "" {
+		encoded = append(encoded[:len(encoded):len(encoded)], c.Key)
+	}
+	if len(encoded) == 0 {
+		return nil, errors.New("no session key")
+	}
+
+	return session.ParseKeys(encoded...)
 }
 
 var _ middleware.Middleware = &SessionMiddleware{}
@@ -48,6 +147,9 @@ var _ middleware.Middleware = &SessionMiddleware{}
 type SessionMiddleware struct {
 	prefix       string
 	expiresAfter time.Duration
+	store        Store
+	idStore      SessionIDStore
+	dispatcher   *event.Dispatcher
 }
 
 // New creates a session middleware.
@@ -64,6 +166,26 @@ func New(prefix string, expiresAfter time.Duration) *SessionMiddleware {
 	}
 }
 
+// SetStore sets a server-side session store. Without a store, the session data is stored in the cookie.
+//
+// With a store, the cookie only carries the signed session ID, and empty sessions are not saved.
+func (s *SessionMiddleware) SetStore(store Store) *SessionMiddleware {
+	s.store = store
+	return s
+}
+
+// SetIDStore sets a SessionIDStore to keep track of the owned sessions. See SetOwner().
+func (s *SessionMiddleware) SetIDStore(idStore SessionIDStore) *SessionMiddleware {
+	s.idStore = idStore
+	return s
+}
+
+// SetDispatcher sets the dispatcher of the SessionExpiredEvent.
+func (s *SessionMiddleware) SetDispatcher(dispatcher *event.Dispatcher) *SessionMiddleware {
+	s.dispatcher = dispatcher
+	return s
+}
+
 func (s *SessionMiddleware) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ci, err := configmw.GetConfig(r).Get("session")
@@ -73,7 +195,19 @@ func (s *SessionMiddleware) Wrap(next http.Handler) http.Handler {
 			return
 		}
 		c := ci.(Config)
-		key := session.MustParse(c.Key)
+		keys, err := c.SecretKeys()
+		if err != nil {
+			logmw.Error(r, sessionComponent, configmw.CategoryConfigNotFound).Log("error", err)
+			http.Error(w, "session not configured", http.StatusInternalServerError)
+			return
+		}
+
+		idleTimeout, absoluteTimeout, err := c.Timeouts()
+		if err != nil {
+			logmw.Error(r, sessionComponent, configmw.CategoryConfigNotFound).Log("error", err)
+			http.Error(w, "session not configured", http.StatusInternalServerError)
+			return
+		}
 
 		cookieURL, err := url.Parse(c.CookieURL)
 		if err != nil {
@@ -82,22 +216,92 @@ func (s *SessionMiddleware) Wrap(next http.Handler) http.Handler {
 			return
 		}
 
-		sess, err := readCookieFromRequest(r, s.prefix, key)
+		sameSite, err := c.SameSiteMode()
+		if err == nil && sameSite == "None" && cookieURL.Scheme != "https" {
+			err = errors.New("SameSite=None requires a https cookie url")
+		}
+		if err != nil {
+			logmw.Error(r, sessionComponent, configmw.CategoryConfigNotFound).Log("error", err)
+			http.Error(w, "session not configured", http.StatusInternalServerError)
+			return
+		}
+
+		format := session.FormatSigned
+		if c.Encrypt {
+			if len(keys[0]) != session.EncryptionKeySize {
+				logmw.Error(r, sessionComponent, configmw.CategoryConfigNotFound).Log("error", session.InvalidKeyError)
+				http.Error(w, "session not configured", http.StatusInternalServerError)
+				return
+			}
+			format = session.FormatEncrypted
+		}
+
+		sess, keyIndex, err := readCookieFromRequest(r, s.prefix, keys)
+		// The cookie is re-issued if it is invalid, signed with an old key or it is in a different format.
+		reissue := err != nil || keyIndex > 0 || (keyIndex == 0 && cookieFormat(r, s.prefix) != format)
 		if err != nil {
 			logmw.Warn(r, sessionComponent, logmw.CategoryFormatError).Log("sessioncookieread", err)
 		}
+		if keyIndex > 0 {
+			logmw.Debug(r, sessionComponent, logmw.CategoryTracing).Log("sessionkey", keyIndex)
+		}
+
+		var expires time.Time
+		if s.store != nil {
+			sess, expires, err = s.load(sess)
+			if err != nil {
+				logmw.Error(r, sessionComponent, "session store").Log("error", err)
+				http.Error(w, "session store error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		loaded := sess.Copy()
+
+		if s.idStore != nil && sess[SessionKeyOwner] != "" {
+			exists, err := s.idStore.Exists(sess.Id())
+			if err != nil {
+				logmw.Error(r, sessionComponent, "session store").Log("error", err)
+				http.Error(w, "session store error", http.StatusInternalServerError)
+				return
+			}
+			if !exists {
+				logmw.Debug(r, sessionComponent, logmw.CategoryTracing).Log("sessioninvalidated", sess.Id())
+				sess = make(session.Session)
+			}
+		}
+
+		expired := false
+		if reason := expiredReason(sess, idleTimeout, absoluteTimeout); reason != "" {
+			logmw.Debug(r, sessionComponent, logmw.CategoryTracing).Log("sessionexpired", reason)
+			expired = true
+			sess = make(session.Session)
+			s.dispatchExpired(r, loaded, reason)
+		}
 
 		logmw.Debug(r, sessionComponent, logmw.CategoryTracing).Log("session", sess)
 
 		r = util.SetContext(r, sessionContextKey, sess)
+		r = util.SetContext(r, sessionExpiredContextKey, expired)
+		if s.idStore != nil {
+			r = util.SetContext(r, sessionIDStoreContextKey, s.idStore)
+		}
 
 		srw := &sessionResponseWriter{
 			ResponseWriterWrapper: util.ResponseWriterWrapper{ResponseWriter: w},
-			key:          key,
+			key:          keys[0],
+			encrypt:      c.Encrypt,
+			sameSite:     sameSite,
 			prefix:       s.prefix,
 			r:            r,
 			expiresAfter: s.expiresAfter,
 			cookieURL:    cookieURL,
+			store:        s.store,
+			idStore:      s.idStore,
+			original:     loaded,
+			expires:      expires,
+			reissue:      reissue,
+			seenInterval: lastSeenUpdateInterval(idleTimeout),
 		}
 
 		next.ServeHTTP(srw, r)
@@ -105,6 +309,64 @@ func (s *SessionMiddleware) Wrap(next http.Handler) http.Handler {
 	})
 }
 
+func (s *SessionMiddleware) dispatchExpired(r *http.Request, sess session.Session, reason string) {
+	if s.dispatcher == nil {
+		return
+	}
+
+	if errs := s.dispatcher.Dispatch(NewSessionExpiredEvent(r, sess, reason)); len(errs) > 0 {
+		logmw.Error(r, sessionComponent, nil).Log("sessionexpiredevent", errs)
+	}
+}
+
+// expiredReason checks the timestamps of a session. It returns an empty string if the session is valid.
+func expiredReason(sess session.Session, idleTimeout, absoluteTimeout time.Duration) string {
+	if sess.IsEmpty() || (idleTimeout <= 0 && absoluteTimeout <= 0) {
+		return ""
+	}
+
+	issuedAt, iatErr := strconv.ParseInt(sess[SessionKeyIssuedAt], 10, 64)
+	lastSeen, seenErr := strconv.ParseInt(sess[SessionKeyLastSeen], 10, 64)
+	if iatErr != nil || seenErr != nil {
+		return ExpiredReasonMissingTimestamps
+	}
+
+	now := time.Now()
+	if absoluteTimeout > 0 && now.Sub(time.Unix(issuedAt, 0)) > absoluteTimeout {
+		return ExpiredReasonAbsolute
+	}
+	if idleTimeout > 0 && now.Sub(time.Unix(lastSeen, 0)) > idleTimeout {
+		return ExpiredReasonIdle
+	}
+
+	return ""
+}
+
+// lastSeenUpdateInterval throttles the updates of the last seen timestamp, so short idle timeouts still work.
+func lastSeenUpdateInterval(idleTimeout time.Duration) time.Duration {
+	if idleTimeout > 0 && idleTimeout/4 < lastSeenInterval {
+		return idleTimeout / 4
+	}
+
+	return lastSeenInterval
+}
+
+// load replaces the session that is read from the cookie with the session from the store.
+func (s *SessionMiddleware) load(cookieSession session.Session) (session.Session, time.Time, error) {
+	if !cookieSession.HasId() {
+		return make(session.Session), time.Time{}, nil
+	}
+
+	id := cookieSession.Id()
+	sess, expires, err := s.store.Load(id)
+	if err != nil || sess == nil {
+		return make(session.Session), time.Time{}, err
+	}
+	sess[session.IdKey] = id
+
+	return sess, expires, nil
+}
+
 func (s *SessionMiddleware) ConfigSchema() map[string]reflect.Type {
 	return map[string]reflect.Type{
 		"session": reflect.TypeOf(Config{}),
@@ -118,8 +380,16 @@ func (s *SessionMiddleware) Dependencies() []string {
 	}
 }
 
-func sessionToCookie(s session.Session, key session.SecretKey, prefix string, cookieURL *url.URL, expiresAfter time.Duration) *http.Cookie {
-	cookieValue := session.EncodeSession(s, key)
+func sessionToCookie(s session.Session, key session.SecretKey, encrypt bool, prefix string, cookieURL *url.URL, expiresAfter time.Duration) (*http.Cookie, error) {
+	var cookieValue string
+	if encrypt {
+		var err error
+		if cookieValue, err = session.EncodeEncryptedSession(s, key); err != nil {
+			return nil, err
+		}
+	} else {
+		cookieValue = session.EncodeSignedSession(s, key)
+	}
 
 	c := &http.Cookie{
 		Name:     prefix + "_SESSION",
@@ -135,19 +405,104 @@ func sessionToCookie(s session.Session, key session.SecretKey, prefix string, co
 		c.Secure = cookieURL.Scheme == "https"
 	}
 
-	return c
+	return c, nil
+}
+
+// cookieName returns the name of the nth chunk of the session cookie.
+func cookieName(prefix string, n int) string {
+	if n == 0 {
+		return prefix + "_SESSION"
+	}
+
+	return prefix + "_SESSION_" + strconv.Itoa(n)
+}
+
+// splitCookie splits the session cookie into chunks of cookieChunkSize.
+func splitCookie(c *http.Cookie, prefix string) []*http.Cookie {
+	var chunks []*http.Cookie
+	value := c.Value
+	for n := 0; n == 0 || len(value) > 0; n++ {
+		size := len(value)
+		if size > cookieChunkSize {
+			size = cookieChunkSize
+		}
+
+		chunk := *c
+		chunk.Name = cookieName(prefix, n)
+		chunk.Value = value[:size]
+		value = value[size:]
+
+		chunks = append(chunks, &chunk)
+	}
+
+	return chunks
+}
+
+// chunkIndexes returns the indexes of the numbered session cookie chunks in the request.
+func chunkIndexes(r *http.Request, prefix string) []int {
+	var indexes []int
+	for _, c := range r.Cookies() {
+		if !strings.HasPrefix(c.Name, prefix+"_SESSION_") {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(c.Name, prefix+"_SESSION_")); err == nil && n > 0 {
+			indexes = append(indexes, n)
+		}
+	}
+
+	return indexes
 }
 
-func readCookieFromRequest(r *http.Request, prefix string, key session.SecretKey) (session.Session, error) {
-	sesscookie, err := r.Cookie(prefix + "_SESSION")
-	if err != nil || len(sesscookie.Value) == 0 {
-		if err == http.ErrNoCookie {
-			err = nil
+// readCookieValue reads the session cookie, and joins its chunks.
+func readCookieValue(r *http.Request, prefix string) string {
+	sesscookie, err := r.Cookie(cookieName(prefix, 0))
+	if err != nil {
+		return ""
+	}
+
+	value := sesscookie.Value
+	for n := 1; n < maxCookieChunks; n++ {
+		chunk, err := r.Cookie(cookieName(prefix, n))
+		if err != nil {
+			break
 		}
-		return make(session.Session), err
+		value += chunk.Value
 	}
 
-	return session.DecodeSession(sesscookie.Value, key)
+	return value
+}
+
+// setCookie sets a cookie with the SameSite attribute, which is not supported by http.Cookie.
+func setCookie(w http.ResponseWriter, c *http.Cookie, sameSite string) {
+	v := c.String()
+	if v == "" {
+		return
+	}
+	if sameSite != "" {
+		v += "; SameSite=" + sameSite
+	}
+
+	w.Header().Add("Set-Cookie", v)
+}
+
+// readCookieFromRequest reads the session cookie. It returns the index of the key that verified the session, or -1
+// if there is no valid session cookie.
+func readCookieFromRequest(r *http.Request, prefix string, keys []session.SecretKey) (session.Session, int, error) {
+	value := readCookieValue(r, prefix)
+	if value == "" {
+		return make(session.Session), -1, nil
+	}
+
+	return session.DecodeSessionWithKeys(value, keys)
+}
+
+func cookieFormat(r *http.Request, prefix string) string {
+	sesscookie, err := r.Cookie(cookieName(prefix, 0))
+	if err != nil {
+		return ""
+	}
+
+	return session.Format(sesscookie.Value)
 }
 
 var _ http.Hijacker = &sessionResponseWriter{}
@@ -159,11 +514,19 @@ var _ http.ResponseWriter = &sessionResponseWriter{}
 type sessionResponseWriter struct {
 	util.ResponseWriterWrapper
 	key          session.SecretKey
+	encrypt      bool
+	sameSite     string
 	prefix       string
 	r            *http.Request
 	expiresAfter time.Duration
 	written      bool
 	cookieURL    *url.URL
+	store        Store
+	idStore      SessionIDStore
+	original     session.Session
+	expires      time.Time
+	reissue      bool
+	seenInterval time.Duration
 }
 
 func (srw *sessionResponseWriter) Write(b []byte) (int, error) {
@@ -180,12 +543,165 @@ func (srw *sessionResponseWriter) WriteHeader(code int) {
 	}
 
 	sess := GetSession(srw.r)
+	srw.touch(sess)
 	logmw.Debug(srw.r, sessionComponent, logmw.CategoryTracing).Log("sessionend", sess)
-	cookie := sessionToCookie(sess, srw.key, srw.prefix, srw.cookieURL, srw.expiresAfter)
-	logmw.Debug(srw.r, sessionComponent, logmw.CategoryTracing).Log("sessioncookie", cookie)
-	http.SetCookie(srw.ResponseWriterWrapper.ResponseWriter, cookie)
+	if err := srw.save(sess); err != nil {
+		logmw.Error(srw.r, sessionComponent, "session store").Log("error", err)
+	}
+
+	cookieSession := sess
+	if srw.store != nil {
+		cookieSession = make(session.Session)
+		if !sess.IsEmpty() {
+			cookieSession[session.IdKey] = sess.Id()
+		}
+	}
+
+	if srw.reissue || !equalSessions(sess, srw.original) {
+		cookie, err := sessionToCookie(cookieSession, srw.key, srw.encrypt, srw.prefix, srw.cookieURL, srw.expiresAfter)
+		if err != nil {
+			logmw.Error(srw.r, sessionComponent, "session encoding").Log("error", err)
+		} else {
+			srw.setCookies(cookie)
+		}
+	}
 
 	srw.ResponseWriterWrapper.WriteHeader(code)
 
 	srw.written = true
 }
+
+// setCookies sets the session cookie. Large sessions are split into chunks, and the stale chunks are deleted.
+//
+// A session that does not fit into maxCookieChunks is not saved, and all session cookies are deleted, so the client
+// does not keep an outdated session (e.g. one that was logged out in this request).
+func (srw *sessionResponseWriter) setCookies(cookie *http.Cookie) {
+	size := len(cookie.Value)
+	if size > sessionSizeWarning {
+		logmw.Warn(srw.r, sessionComponent, "session size").Log("sessionsize", size)
+	}
+
+	w := srw.ResponseWriterWrapper.ResponseWriter
+	chunks := splitCookie(cookie, srw.prefix)
+	if len(chunks) > maxCookieChunks {
+		logmw.Error(srw.r, sessionComponent, "session size").Log("sessionsize", size, "error", "the session is too large")
+		chunks = nil
+		srw.expireCookie(w, cookie, 0)
+	}
+
+	for _, chunk := range chunks {
+		logmw.Debug(srw.r, sessionComponent, logmw.CategoryTracing).Log("sessioncookie", chunk)
+		setCookie(w, chunk, srw.sameSite)
+	}
+
+	for _, n := range chunkIndexes(srw.r, srw.prefix) {
+		if n < len(chunks) {
+			continue
+		}
+
+		srw.expireCookie(w, cookie, n)
+	}
+}
+
+// expireCookie deletes the nth chunk of the session cookie.
+func (srw *sessionResponseWriter) expireCookie(w http.ResponseWriter, cookie *http.Cookie, n int) {
+	stale := *cookie
+	stale.Name = cookieName(srw.prefix, n)
+	stale.Value = ""
+	stale.Expires = time.Unix(0, 0)
+	stale.MaxAge = -1
+	setCookie(w, &stale, srw.sameSite)
+}
+
+// touch updates the timestamps of a non-empty session.
+//
+// The issued at timestamp is reset when the session ID changes (e.g. on login). The last seen timestamp is updated
+// at most once in every seenInterval, because every update re-issues the cookie.
+func (srw *sessionResponseWriter) touch(sess session.Session) {
+	if sess.IsEmpty() {
+		return
+	}
+
+	now := time.Now()
+	nowString := strconv.FormatInt(now.Unix(), 10)
+	originalID := srw.original[session.IdKey]
+
+	if sess[SessionKeyIssuedAt] == "" || (originalID != "" && sess.HasId() && sess.Id() != originalID) {
+		sess[SessionKeyIssuedAt] = nowString
+		sess[SessionKeyLastSeen] = nowString
+		return
+	}
+
+	lastSeen, err := strconv.ParseInt(sess[SessionKeyLastSeen], 10, 64)
+	if err != nil || now.Sub(time.Unix(lastSeen, 0)) >= srw.seenInterval {
+		sess[SessionKeyLastSeen] = nowString
+	}
+}
+
+// save updates the Store and the SessionIDStore at the end of the request.
+func (srw *sessionResponseWriter) save(sess session.Session) error {
+	originalID := srw.original[session.IdKey]
+	originalOwner := srw.original[SessionKeyOwner]
+
+	owner := sess[SessionKeyOwner]
+
+	expires := time.Now().Add(srw.expiresAfter)
+	// The session is extended when the cookie is re-issued or the stored session is saved.
+	extended := srw.reissue || !equalSessions(sess, srw.original)
+
+	// Empty sessions are not saved in the store, so they don't have an ID.
+	id := ""
+	if srw.store != nil {
+		if !sess.IsEmpty() {
+			id = sess.Id()
+		}
+	} else if sess.HasId() || owner != "" {
+		id = sess.Id()
+	}
+
+	if srw.store != nil {
+		if originalID != "" && originalID != id {
+			if err := srw.store.Delete(originalID); err != nil {
+				return err
+			}
+		}
+
+		if id != "" && (id != originalID || !equalSessions(sess, srw.original) || time.Until(srw.expires) < srw.expiresAfter-storeTouchInterval) {
+			if err := srw.store.Save(id, sess, expires); err != nil {
+				return err
+			}
+			extended = true
+		}
+	}
+
+	if srw.idStore != nil {
+		changed := id != originalID || owner != originalOwner
+		if changed && originalOwner != "" {
+			if err := srw.idStore.InvalidateSessionID(originalID); err != nil {
+				return err
+			}
+		}
+
+		if owner != "" && id != "" && (changed || extended) {
+			if err := srw.idStore.SaveSessionID(id, owner, expires); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func equalSessions(a, b session.Session) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+
+	return true
+}
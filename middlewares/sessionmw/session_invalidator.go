@@ -14,10 +14,52 @@
 
 package sessionmw
 
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// SessionKeyOwner is the session key of the owner of the session. See SetOwner().
+	SessionKeyOwner = "_owner"
+
+	sessionIDStoreContextKey = "SESSIONIDSTORE"
+)
+
+// SessionIDStore keeps track of the IDs of the sessions that belong to an owner (e.g. a user), so they can be
+// invalidated individually or all at once.
+//
+// The key is the identifier of the owner.
 type SessionIDStore interface {
-	SaveSessionID(sid, key string) error
+	// SaveSessionID binds a session to an owner until the session expires. It is called again with a new expiration
+	// time when the session is extended.
+	SaveSessionID(sid, key string, expires time.Time) error
+	// InvalidateSessionID invalidates a session.
 	InvalidateSessionID(sid string) error
+	// InvalidateAll invalidates all sessions of an owner.
 	InvalidateAll(key string) error
+	// GetIDs returns the IDs of the valid sessions of an owner.
 	GetIDs(key string) ([]string, error)
+	// Exists tells if a session is valid.
 	Exists(sid string) (bool, error)
 }
+
+// SetOwner sets the owner of the session of the request. An empty owner removes the owner.
+//
+// If the SessionMiddleware has a SessionIDStore, the session is saved into it at the end of the request, and an owned
+// session is only accepted while the SessionIDStore knows about it.
+func SetOwner(r *http.Request, owner string) {
+	sess := GetSession(r)
+	if owner == "" {
+		delete(sess, SessionKeyOwner)
+	} else {
+		sess[SessionKeyOwner] = owner
+	}
+}
+
+// GetSessionIDStore returns the SessionIDStore of the SessionMiddleware. It returns nil if the sessions are not
+// tracked.
+func GetSessionIDStore(r *http.Request) SessionIDStore {
+	store, _ := r.Context().Value(sessionIDStoreContextKey).(SessionIDStore)
+	return store
+}
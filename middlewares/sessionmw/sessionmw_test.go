@@ -20,10 +20,13 @@ import (
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/event"
 	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/session"
 	"github.com/alien-bunny/ab/lib/util"
 	"github.com/alien-bunny/ab/middlewares/logmw"
 	"github.com/alien-bunny/ab/middlewares/sessionmw"
@@ -73,6 +76,461 @@ var _ = Describe("Session Middleware", func() {
 		body := string(w.Body.Bytes())
 		Expect(body).To(Equal(data))
 	})
+
+	It("should accept the sessions that are signed with an old key", func() {
+		newKey := util.RandomSecret(32)
+		defer saver.Save(sessionmw.Config{
+			Key: hex.EncodeToString(abtest.FakeKey),
+		})
+
+		data := util.RandomString(16)
+		request(stack, jar, func(w http.ResponseWriter, r *http.Request) {
+			sessionmw.GetSession(r)["data"] = data
+		})
+
+		By("rotating the key")
+		saver.Save(sessionmw.Config{
+			Keys: []string{newKey, hex.EncodeToString(abtest.FakeKey)},
+		})
+
+		w := request(stack, jar, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(sessionmw.GetSession(r)["data"]))
+		})
+		Expect(w.Body.String()).To(Equal(data))
+
+		By("checking that the session is signed with the new key")
+		cookies := (&http.Response{Header: w.Header()}).Cookies()
+		Expect(cookies).To(HaveLen(1))
+		_, index, err := session.DecodeSessionWithKeys(cookies[0].Value, []session.SecretKey{session.MustParse(newKey)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index).To(Equal(0))
+
+		By("removing the old key")
+		saver.Save(sessionmw.Config{
+			Keys: []string{newKey},
+		})
+		w = request(stack, jar, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(sessionmw.GetSession(r)["data"]))
+		})
+		Expect(w.Body.String()).To(Equal(data))
+	})
+
+	It("should encrypt the session cookies", func() {
+		defer saver.Save(sessionmw.Config{
+			Key: hex.EncodeToString(abtest.FakeKey),
+		})
+
+		data := util.RandomString(16)
+		w := request(stack, jar, func(w http.ResponseWriter, r *http.Request) {
+			sessionmw.GetSession(r)["data"] = data
+		})
+		cookies := (&http.Response{Header: w.Header()}).Cookies()
+		Expect(cookies).To(HaveLen(1))
+		Expect(session.Format(cookies[0].Value)).To(Equal(session.FormatSigned))
+
+		By("switching to encrypted cookies")
+		saver.Save(sessionmw.Config{
+			Key:     hex.EncodeToString(abtest.FakeKey),
+			Encrypt: true,
+		})
+
+		w = request(stack, jar, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(sessionmw.GetSession(r)["data"]))
+		})
+		Expect(w.Body.String()).To(Equal(data))
+
+		cookies = (&http.Response{Header: w.Header()}).Cookies()
+		Expect(cookies).To(HaveLen(1))
+		Expect(session.Format(cookies[0].Value)).To(Equal(session.FormatEncrypted))
+		Expect(cookies[0].Value).NotTo(ContainSubstring(data))
+
+		By("rejecting a short encryption key")
+		saver.Save(sessionmw.Config{
+			Key:     util.RandomSecret(16),
+			Encrypt: true,
+		})
+		w = request(stack, jar, func(w http.ResponseWriter, r *http.Request) {
+			sessionmw.GetSession(r)["data"] = data
+		})
+		Expect(w.Code).To(Equal(http.StatusInternalServerError))
+
+		By("switching back to signed cookies")
+		saver.Save(sessionmw.Config{
+			Key: hex.EncodeToString(abtest.FakeKey),
+		})
+
+		w = request(stack, jar, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(sessionmw.GetSession(r)["data"]))
+		})
+		Expect(w.Body.String()).To(Equal(data))
+	})
+
+	It("should split large sessions into multiple cookies", func() {
+		chunkJar, _ := cookiejar.New(&cookiejar.Options{
+			PublicSuffixList: publicsuffix.List,
+		})
+		readData := func() string {
+			w := request(stack, chunkJar, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(sessionmw.GetSession(r)["data"]))
+			})
+			return w.Body.String()
+		}
+
+		data := util.RandomString(10000)
+		w := request(stack, chunkJar, func(w http.ResponseWriter, r *http.Request) {
+			sessionmw.GetSession(r)["data"] = data
+		})
+		cookies := (&http.Response{Header: w.Header()}).Cookies()
+		Expect(len(cookies)).To(BeNumerically(">", 2))
+		Expect(cookies[0].Name).To(Equal("_SESSION"))
+		Expect(cookies[1].Name).To(Equal("_SESSION_1"))
+		for _, c := range cookies {
+			Expect(len(c.Value)).To(BeNumerically("<", 4000))
+		}
+		Expect(readData()).To(Equal(data))
+
+		By("shrinking the session")
+		w = request(stack, chunkJar, func(w http.ResponseWriter, r *http.Request) {
+			sessionmw.GetSession(r)["data"] = "small"
+		})
+		cookies = (&http.Response{Header: w.Header()}).Cookies()
+		Expect(len(cookies)).To(BeNumerically(">", 2))
+		Expect(cookies[0].Name).To(Equal("_SESSION"))
+		for _, c := range cookies[1:] {
+			Expect(c.MaxAge).To(Equal(-1))
+		}
+		Expect(readData()).To(Equal("small"))
+
+		u, _ := url.Parse("http://test")
+		Expect(chunkJar.Cookies(u)).To(HaveLen(1))
+
+		By("growing the session over the limit")
+		request(stack, chunkJar, func(w http.ResponseWriter, r *http.Request) {
+			sessionmw.GetSession(r)["data"] = data
+		})
+		Expect(len(chunkJar.Cookies(u))).To(BeNumerically(">", 2))
+		w = request(stack, chunkJar, func(w http.ResponseWriter, r *http.Request) {
+			sessionmw.GetSession(r)["data"] = util.RandomString(40000)
+		})
+		cookies = (&http.Response{Header: w.Header()}).Cookies()
+		Expect(len(cookies)).To(BeNumerically(">", 2))
+		for _, c := range cookies {
+			Expect(c.MaxAge).To(Equal(-1))
+		}
+		Expect(chunkJar.Cookies(u)).To(BeEmpty())
+		Expect(readData()).To(BeEmpty())
+	})
+
+	It("should set the SameSite attribute", func() {
+		defer saver.Save(sessionmw.Config{
+			Key: hex.EncodeToString(abtest.FakeKey),
+		})
+
+		sameSiteJar, _ := cookiejar.New(&cookiejar.Options{
+			PublicSuffixList: publicsuffix.List,
+		})
+		setData := func() *httptest.ResponseRecorder {
+			return request(stack, sameSiteJar, func(w http.ResponseWriter, r *http.Request) {
+				sessionmw.GetSession(r)["data"] = util.RandomString(8)
+			})
+		}
+
+		Expect(setData().Header().Get("Set-Cookie")).NotTo(ContainSubstring("SameSite"))
+
+		saver.Save(sessionmw.Config{
+			Key:      hex.EncodeToString(abtest.FakeKey),
+			SameSite: "strict",
+		})
+		Expect(setData().Header().Get("Set-Cookie")).To(HaveSuffix("; SameSite=Strict"))
+
+		By("rejecting invalid values")
+		saver.Save(sessionmw.Config{
+			Key:      hex.EncodeToString(abtest.FakeKey),
+			SameSite: "invalid",
+		})
+		Expect(setData().Code).To(Equal(http.StatusInternalServerError))
+
+		By("requiring https for SameSite=None")
+		saver.Save(sessionmw.Config{
+			Key:      hex.EncodeToString(abtest.FakeKey),
+			SameSite: "None",
+		})
+		Expect(setData().Code).To(Equal(http.StatusInternalServerError))
+
+		saver.Save(sessionmw.Config{
+			Key:       hex.EncodeToString(abtest.FakeKey),
+			SameSite:  "None",
+			CookieURL: "https://test/",
+		})
+		w := setData()
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Set-Cookie")).To(HaveSuffix("; Secure; SameSite=None"))
+	})
+
+	Describe("with timeouts", func() {
+		dispatcher := event.NewDispatcher()
+		var expiredEvents []*sessionmw.SessionExpiredEvent
+		dispatcher.Subscribe(sessionmw.EventSessionExpired, event.SubscriberFunc(func(e event.Event) error {
+			expiredEvents = append(expiredEvents, e.(*sessionmw.SessionExpiredEvent))
+			return nil
+		}))
+
+		timeoutStack := middleware.NewStack(nil)
+		timeoutStack.Push(cmw)
+		timeoutStack.Push(logmw.New(logger))
+		timeoutStack.Push(sessionmw.New("", time.Hour).SetDispatcher(dispatcher))
+
+		var timeoutJar *cookiejar.Jar
+
+		BeforeEach(func() {
+			expiredEvents = nil
+			timeoutJar, _ = cookiejar.New(&cookiejar.Options{
+				PublicSuffixList: publicsuffix.List,
+			})
+			saver.Save(sessionmw.Config{
+				Key:             hex.EncodeToString(abtest.FakeKey),
+				IdleTimeout:     "1h",
+				AbsoluteTimeout: "24h",
+			})
+		})
+
+		AfterEach(func() {
+			saver.Save(sessionmw.Config{
+				Key: hex.EncodeToString(abtest.FakeKey),
+			})
+		})
+
+		setCookie := func(sess session.Session) {
+			u, _ := url.Parse("http://test")
+			timeoutJar.SetCookies(u, []*http.Cookie{{
+				Name:  "_SESSION",
+				Value: session.EncodeSignedSession(sess, session.SecretKey(abtest.FakeKey)),
+			}})
+		}
+
+		timestamps := func(issuedAt, lastSeen time.Time) session.Session {
+			return session.Session{
+				"data":                       "value",
+				sessionmw.SessionKeyIssuedAt: strconv.FormatInt(issuedAt.Unix(), 10),
+				sessionmw.SessionKeyLastSeen: strconv.FormatInt(lastSeen.Unix(), 10),
+			}
+		}
+
+		checkExpired := func(reason string) {
+			w := request(timeoutStack, timeoutJar, func(w http.ResponseWriter, r *http.Request) {
+				Expect(sessionmw.Expired(r)).To(BeTrue())
+				Expect(sessionmw.GetSession(r)).To(BeEmpty())
+			})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(expiredEvents).To(HaveLen(1))
+			Expect(expiredEvents[0].Reason()).To(Equal(reason))
+			Expect(expiredEvents[0].Session()).To(HaveKeyWithValue("data", "value"))
+		}
+
+		It("should embed the timestamps and throttle the cookie updates", func() {
+			w := request(timeoutStack, timeoutJar, func(w http.ResponseWriter, r *http.Request) {
+				sessionmw.GetSession(r)["data"] = "value"
+			})
+			Expect((&http.Response{Header: w.Header()}).Cookies()).To(HaveLen(1))
+
+			w = request(timeoutStack, timeoutJar, func(w http.ResponseWriter, r *http.Request) {
+				Expect(sessionmw.Expired(r)).To(BeFalse())
+				sess := sessionmw.GetSession(r)
+				Expect(sess).To(HaveKey(sessionmw.SessionKeyIssuedAt))
+				Expect(sess).To(HaveKey(sessionmw.SessionKeyLastSeen))
+				Expect(sess).To(HaveKeyWithValue("data", "value"))
+			})
+			Expect((&http.Response{Header: w.Header()}).Cookies()).To(BeEmpty())
+		})
+
+		It("should slide the idle window", func() {
+			setCookie(timestamps(time.Now().Add(-time.Hour), time.Now().Add(-30*time.Minute)))
+
+			var lastSeen string
+			request(timeoutStack, timeoutJar, func(w http.ResponseWriter, r *http.Request) {
+				Expect(sessionmw.Expired(r)).To(BeFalse())
+			})
+			request(timeoutStack, timeoutJar, func(w http.ResponseWriter, r *http.Request) {
+				lastSeen = sessionmw.GetSession(r)[sessionmw.SessionKeyLastSeen]
+			})
+
+			seen, err := strconv.ParseInt(lastSeen, 10, 64)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Unix(seen, 0)).To(BeTemporally("~", time.Now(), 5*time.Second))
+		})
+
+		It("should expire idle sessions", func() {
+			setCookie(timestamps(time.Now().Add(-3*time.Hour), time.Now().Add(-2*time.Hour)))
+			checkExpired(sessionmw.ExpiredReasonIdle)
+		})
+
+		It("should expire old sessions", func() {
+			setCookie(timestamps(time.Now().Add(-25*time.Hour), time.Now()))
+			checkExpired(sessionmw.ExpiredReasonAbsolute)
+		})
+
+		It("should expire sessions without timestamps", func() {
+			setCookie(session.Session{"data": "value"})
+			checkExpired(sessionmw.ExpiredReasonMissingTimestamps)
+		})
+	})
+
+	Describe("with a server-side store", func() {
+		store := sessionmw.NewMemoryStore()
+		storeStack := middleware.NewStack(nil)
+		storeStack.Push(cmw)
+		storeStack.Push(logmw.New(logger))
+		storeStack.Push(sessionmw.New("", time.Hour).SetStore(store).SetIDStore(store))
+
+		newJar := func() *cookiejar.Jar {
+			jar, _ := cookiejar.New(&cookiejar.Options{
+				PublicSuffixList: publicsuffix.List,
+			})
+			return jar
+		}
+
+		readData := func(jar *cookiejar.Jar) string {
+			return request(storeStack, jar, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(sessionmw.GetSession(r)["data"]))
+			}).Body.String()
+		}
+
+		login := func(jar *cookiejar.Jar, owner string) string {
+			var sid string
+			request(storeStack, jar, func(w http.ResponseWriter, r *http.Request) {
+				sess := sessionmw.GetSession(r)
+				sess.Regenerate()
+				sess["data"] = owner
+				sessionmw.SetOwner(r, owner)
+				sid = sess.Id()
+			})
+			return sid
+		}
+
+		It("should only store the session ID in the cookie", func() {
+			jar := newJar()
+			data := util.RandomString(16)
+
+			w := request(storeStack, jar, func(w http.ResponseWriter, r *http.Request) {
+				sessionmw.GetSession(r)["data"] = data
+			})
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			cookies := (&http.Response{Header: w.Header()}).Cookies()
+			Expect(cookies).To(HaveLen(1))
+			cookieSession, err := session.DecodeSession(cookies[0].Value, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cookieSession).To(HaveLen(1))
+			Expect(cookieSession).To(HaveKey(session.IdKey))
+
+			Expect(readData(jar)).To(Equal(data))
+		})
+
+		It("should not store empty sessions", func() {
+			var sid string
+			request(storeStack, newJar(), func(w http.ResponseWriter, r *http.Request) {
+				sid = sessionmw.GetSession(r).Id()
+			})
+
+			sess, _, err := store.Load(sid)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sess).To(BeNil())
+		})
+
+		It("should remove the old session when the ID is regenerated", func() {
+			jar := newJar()
+			var oldID string
+			request(storeStack, jar, func(w http.ResponseWriter, r *http.Request) {
+				sess := sessionmw.GetSession(r)
+				sess["data"] = "value"
+				oldID = sess.Id()
+			})
+
+			newID := login(jar, util.RandomString(8))
+			Expect(newID).NotTo(Equal(oldID))
+
+			exists, err := store.Exists(oldID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+		})
+
+		It("should invalidate the sessions individually and per owner", func() {
+			owner := util.RandomString(8)
+			jar0, jar1, jar2 := newJar(), newJar(), newJar()
+			sid0 := login(jar0, owner)
+			login(jar1, owner)
+			login(jar2, owner)
+
+			ids, err := store.GetIDs(owner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids).To(HaveLen(3))
+
+			By("invalidating one session")
+			Expect(store.InvalidateSessionID(sid0)).To(Succeed())
+			Expect(readData(jar0)).To(BeEmpty())
+			Expect(readData(jar1)).To(Equal(owner))
+
+			By("invalidating every session of the owner")
+			request(storeStack, jar1, func(w http.ResponseWriter, r *http.Request) {
+				Expect(sessionmw.GetSessionIDStore(r).InvalidateAll(owner)).To(Succeed())
+			})
+			Expect(readData(jar1)).To(BeEmpty())
+			Expect(readData(jar2)).To(BeEmpty())
+
+			ids, err = store.GetIDs(owner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids).To(BeEmpty())
+		})
+
+		It("should delete the expired sessions", func() {
+			expiring := sessionmw.NewMemoryStore()
+			Expect(expiring.Save("expired", session.Session{"data": "value"}, time.Now().Add(-time.Second))).To(Succeed())
+			Expect(expiring.Save("valid", session.Session{"data": "value"}, time.Now().Add(time.Hour))).To(Succeed())
+			Expect(expiring.SaveSessionID("expiredid", "owner", time.Now().Add(-time.Second))).To(Succeed())
+
+			sess, _, err := expiring.Load("expired")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sess).To(BeNil())
+
+			Expect(expiring.DeleteExpired()).To(Succeed())
+			Expect(expiring.Exists("expired")).To(BeFalse())
+			Expect(expiring.Exists("valid")).To(BeTrue())
+			Expect(expiring.Exists("expiredid")).To(BeFalse())
+			Expect(expiring.GetIDs("owner")).To(BeEmpty())
+		})
+	})
+
+	It("should invalidate owned cookie sessions with a SessionIDStore", func() {
+		idStore := sessionmw.NewMemoryStore()
+		idStack := middleware.NewStack(nil)
+		idStack.Push(cmw)
+		idStack.Push(logmw.New(logger))
+		idStack.Push(sessionmw.New("", time.Hour).SetIDStore(idStore))
+
+		jar, _ := cookiejar.New(&cookiejar.Options{
+			PublicSuffixList: publicsuffix.List,
+		})
+		owner := util.RandomString(8)
+
+		request(idStack, jar, func(w http.ResponseWriter, r *http.Request) {
+			sessionmw.GetSession(r)["data"] = "value"
+			sessionmw.SetOwner(r, owner)
+		})
+		Expect(idStore.GetIDs(owner)).To(HaveLen(1))
+
+		Expect(idStore.InvalidateAll(owner)).To(Succeed())
+		w := request(idStack, jar, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(sessionmw.GetSession(r)["data"]))
+		})
+		Expect(w.Body.String()).To(BeEmpty())
+	})
 })
 
 func request(stack *middleware.Stack, jar *cookiejar.Jar, handler http.HandlerFunc) *httptest.ResponseRecorder {
@@ -0,0 +1,210 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessionmw
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/session"
+)
+
+// Store stores the session data on the server side. The session cookie only carries the signed session ID.
+type Store interface {
+	// Load loads a session and its expiration time. It returns a nil session if the session does not exist or it is
+	// expired.
+	Load(sid string) (session.Session, time.Time, error)
+	// Save creates or overwrites a session.
+	Save(sid string, sess session.Session, expires time.Time) error
+	// Delete removes a session.
+	Delete(sid string) error
+	// DeleteExpired removes the expired sessions.
+	DeleteExpired() error
+}
+
+var _ Store = &MemoryStore{}
+var _ SessionIDStore = &MemoryStore{}
+
+// MemoryStore stores the sessions in memory. It implements both Store and SessionIDStore.
+//
+// The sessions are lost when the server restarts, and they are not shared between the servers of a cluster. Use
+// PostgresStore for clusters.
+type MemoryStore struct {
+	mtx      sync.RWMutex
+	sessions map[string]*memorySession
+	owners   map[string]map[string]struct{}
+	now      func() time.Time
+}
+
+type memorySession struct {
+	data    session.Session
+	owner   string
+	expires time.Time
+}
+
+func (s *memorySession) expired(now time.Time) bool {
+	return !s.expires.IsZero() && !now.Before(s.expires)
+}
+
+// NewMemoryStore creates a MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]*memorySession),
+		owners:   make(map[string]map[string]struct{}),
+		now:      time.Now,
+	}
+}
+
+// Load loads a session.
+func (s *MemoryStore) Load(sid string) (session.Session, time.Time, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	ms, ok := s.sessions[sid]
+	if !ok || ms.data == nil || ms.expired(s.now()) {
+		return nil, time.Time{}, nil
+	}
+
+	return ms.data.Copy(), ms.expires, nil
+}
+
+// Save saves a session.
+func (s *MemoryStore) Save(sid string, sess session.Session, expires time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ms, ok := s.sessions[sid]
+	if !ok {
+		ms = &memorySession{}
+		s.sessions[sid] = ms
+	}
+
+	ms.data = sess.Copy()
+	ms.expires = expires
+
+	return nil
+}
+
+// Delete deletes a session.
+func (s *MemoryStore) Delete(sid string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.delete(sid)
+
+	return nil
+}
+
+// DeleteExpired deletes the expired sessions.
+func (s *MemoryStore) DeleteExpired() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := s.now()
+	for sid, ms := range s.sessions {
+		if ms.expired(now) {
+			s.delete(sid)
+		}
+	}
+
+	return nil
+}
+
+// SaveSessionID binds a session to an owner.
+func (s *MemoryStore) SaveSessionID(sid, key string, expires time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ms, ok := s.sessions[sid]
+	if !ok {
+		ms = &memorySession{}
+		s.sessions[sid] = ms
+	}
+
+	s.unbind(sid, ms.owner)
+	ms.owner = key
+	ms.expires = expires
+	if key == "" {
+		return nil
+	}
+
+	if s.owners[key] == nil {
+		s.owners[key] = make(map[string]struct{})
+	}
+	s.owners[key][sid] = struct{}{}
+
+	return nil
+}
+
+// InvalidateSessionID deletes a session.
+func (s *MemoryStore) InvalidateSessionID(sid string) error {
+	return s.Delete(sid)
+}
+
+// InvalidateAll deletes all sessions of an owner.
+func (s *MemoryStore) InvalidateAll(key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for sid := range s.owners[key] {
+		s.delete(sid)
+	}
+
+	return nil
+}
+
+// GetIDs returns the IDs of the active sessions of an owner.
+func (s *MemoryStore) GetIDs(key string) ([]string, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	now := s.now()
+	ids := []string{}
+	for sid := range s.owners[key] {
+		if !s.sessions[sid].expired(now) {
+			ids = append(ids, sid)
+		}
+	}
+
+	return ids, nil
+}
+
+// Exists tells if a session is active.
+func (s *MemoryStore) Exists(sid string) (bool, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	ms, ok := s.sessions[sid]
+
+	return ok && !ms.expired(s.now()), nil
+}
+
+func (s *MemoryStore) delete(sid string) {
+	if ms, ok := s.sessions[sid]; ok {
+		s.unbind(sid, ms.owner)
+		delete(s.sessions, sid)
+	}
+}
+
+func (s *MemoryStore) unbind(sid, owner string) {
+	if owner == "" {
+		return
+	}
+
+	delete(s.owners[owner], sid)
+	if len(s.owners[owner]) == 0 {
+		delete(s.owners, owner)
+	}
+}
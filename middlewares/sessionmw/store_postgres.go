@@ -0,0 +1,168 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessionmw
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/db"
+	"github.com/alien-bunny/ab/lib/session"
+	"github.com/lib/pq"
+)
+
+var _ Store = &PostgresStore{}
+var _ SessionIDStore = &PostgresStore{}
+
+// PostgresStore stores the sessions in a PostgreSQL table, so they are shared between the servers of a cluster. It
+// implements both Store and SessionIDStore.
+type PostgresStore struct {
+	conn  db.DB
+	table string
+}
+
+// NewPostgresStore creates a PostgresStore. The table is created with CreateTable().
+func NewPostgresStore(conn db.DB) *PostgresStore {
+	return &PostgresStore{
+		conn:  conn,
+		table: "sessions",
+	}
+}
+
+// CreateTable creates the table of the sessions if it does not exist.
+func (s *PostgresStore) CreateTable() error {
+	_, err := s.conn.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + s.table + `(
+			id text NOT NULL PRIMARY KEY,
+			data jsonb,
+			owner text,
+			expires timestamp with time zone
+		);
+		CREATE INDEX IF NOT EXISTS ` + s.table + `_owner_idx ON ` + s.table + `(owner);
+	`)
+
+	return err
+}
+
+// Load loads a session.
+func (s *PostgresStore) Load(sid string) (session.Session, time.Time, error) {
+	var data []byte
+	var expires pq.NullTime
+	err := s.conn.QueryRow(`
+		SELECT data, expires FROM `+s.table+`
+		WHERE id = $1 AND data IS NOT NULL AND (expires IS NULL OR expires > now())
+	`, sid).Scan(&data, &expires)
+	if err == sql.ErrNoRows {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	sess := make(session.Session)
+	if err = json.Unmarshal(data, &sess); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return sess, expires.Time, nil
+}
+
+// Save saves a session.
+func (s *PostgresStore) Save(sid string, sess session.Session, expires time.Time) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.Exec(`
+		INSERT INTO `+s.table+`(id, data, expires) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, expires = EXCLUDED.expires
+	`, sid, data, expires)
+
+	return err
+}
+
+// Delete deletes a session.
+func (s *PostgresStore) Delete(sid string) error {
+	_, err := s.conn.Exec(`DELETE FROM `+s.table+` WHERE id = $1`, sid)
+
+	return err
+}
+
+// DeleteExpired deletes the expired sessions.
+//
+// It should be called periodically, e.g. on the maintenance event.
+func (s *PostgresStore) DeleteExpired() error {
+	_, err := s.conn.Exec(`DELETE FROM ` + s.table + ` WHERE expires <= now()`)
+
+	return err
+}
+
+// SaveSessionID binds a session to an owner.
+func (s *PostgresStore) SaveSessionID(sid, key string, expires time.Time) error {
+	_, err := s.conn.Exec(`
+		INSERT INTO `+s.table+`(id, owner, expires) VALUES ($1, NULLIF($2, ''), $3)
+		ON CONFLICT (id) DO UPDATE SET owner = EXCLUDED.owner, expires = EXCLUDED.expires
+	`, sid, key, expires)
+
+	return err
+}
+
+// InvalidateSessionID deletes a session.
+func (s *PostgresStore) InvalidateSessionID(sid string) error {
+	return s.Delete(sid)
+}
+
+// InvalidateAll deletes all sessions of an owner.
+func (s *PostgresStore) InvalidateAll(key string) error {
+	_, err := s.conn.Exec(`DELETE FROM `+s.table+` WHERE owner = $1`, key)
+
+	return err
+}
+
+// GetIDs returns the IDs of the active sessions of an owner.
+func (s *PostgresStore) GetIDs(key string) ([]string, error) {
+	rows, err := s.conn.Query(`
+		SELECT id FROM `+s.table+`
+		WHERE owner = $1 AND (expires IS NULL OR expires > now())
+		ORDER BY id
+	`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Exists tells if a session is active.
+func (s *PostgresStore) Exists(sid string) (bool, error) {
+	var exists bool
+	err := s.conn.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM `+s.table+` WHERE id = $1 AND (expires IS NULL OR expires > now()))
+	`, sid).Scan(&exists)
+
+	return exists, err
+}
@@ -0,0 +1,222 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timeoutmw
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/middlewares/errormw"
+)
+
+const (
+	MiddlewareDependencyTimeout = "*timeoutmw.TimeoutMiddleware"
+	deadlineKey                 = "abdeadline"
+)
+
+// ErrTimeout is the error of the 503 response when the deadline of a request is exceeded.
+var ErrTimeout = errors.NewError("request timeout", "The server could not process the request in time.", nil)
+
+var _ middleware.Middleware = &TimeoutMiddleware{}
+
+// TimeoutMiddleware puts a deadline on the request context.
+//
+// The handlers are expected to respect the context; the database connections from dbmw do this automatically.
+// If the deadline is exceeded and nothing has been written to the response, the request fails with 503.
+//
+// A server-wide instance sets the default deadline. A route can override it with its own instance, e.g. a long-running
+// export endpoint can opt into a longer deadline:
+//
+//		s.GetF("/api/export", exportHandler, timeoutmw.New(10*time.Minute))
+//
+// The deadline is measured from the time when the request has reached the first TimeoutMiddleware.
+type TimeoutMiddleware struct {
+	timeout time.Duration
+}
+
+// New creates a TimeoutMiddleware. A timeout of 0 disables the deadline.
+func New(timeout time.Duration) *TimeoutMiddleware {
+	return &TimeoutMiddleware{
+		timeout: timeout,
+	}
+}
+
+func (t *TimeoutMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d := getDeadline(r); d != nil {
+			d.reset(t.timeout)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, d := newDeadlineContext(r.Context(), t.timeout)
+		r = util.SetContext(r.WithContext(ctx), deadlineKey, d)
+
+		tw := &timeoutResponseWriter{ResponseWriterWrapper: util.ResponseWriterWrapper{ResponseWriter: w}}
+
+		defer func() {
+			if rec := recover(); rec != nil {
+				if d.exceeded() {
+					errors.Fail(http.StatusServiceUnavailable, ErrTimeout)
+				}
+				panic(rec)
+			}
+
+			if d.exceeded() && !tw.written {
+				errors.Fail(http.StatusServiceUnavailable, ErrTimeout)
+			}
+		}()
+
+		next.ServeHTTP(tw, r)
+	})
+}
+
+func (t *TimeoutMiddleware) Dependencies() []string {
+	return []string{errormw.MiddlewareDependencyError}
+}
+
+// Deadline returns the deadline of the request, if there is one.
+func Deadline(r *http.Request) (time.Time, bool) {
+	return r.Context().Deadline()
+}
+
+func getDeadline(r *http.Request) *deadline {
+	d, _ := r.Context().Value(deadlineKey).(*deadline)
+	return d
+}
+
+// deadline is a resettable deadline.
+//
+// Context deadlines can only be shortened by deriving a new context, so a route could not opt into a longer deadline
+// than the server-wide one. This implementation cancels the context with a timer that can be reset.
+type deadline struct {
+	mtx      sync.Mutex
+	start    time.Time
+	deadline time.Time
+	timer    *time.Timer
+	expired  bool
+	cancel   context.CancelFunc
+}
+
+func (d *deadline) reset(timeout time.Duration) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.expired {
+		return
+	}
+
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.deadline = time.Time{}
+
+	if timeout > 0 {
+		d.deadline = d.start.Add(timeout)
+		d.timer = time.AfterFunc(time.Until(d.deadline), d.expire)
+	}
+}
+
+func (d *deadline) expire() {
+	d.mtx.Lock()
+	d.expired = true
+	d.mtx.Unlock()
+
+	d.cancel()
+}
+
+func (d *deadline) exceeded() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.expired
+}
+
+func (d *deadline) stop() {
+	d.mtx.Lock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.mtx.Unlock()
+}
+
+type deadlineContext struct {
+	context.Context
+	d *deadline
+}
+
+func newDeadlineContext(parent context.Context, timeout time.Duration) (*deadlineContext, *deadline) {
+	ctx, cancel := context.WithCancel(parent)
+	d := &deadline{
+		start:  time.Now(),
+		cancel: cancel,
+	}
+	d.reset(timeout)
+
+	// The deadline is still in effect after the handler returns, because the response might be rendered later
+	// (see rendermw). The timer is stopped when the request is finished, and the server cancels its context.
+	go func() {
+		<-ctx.Done()
+		d.stop()
+	}()
+
+	return &deadlineContext{
+		Context: ctx,
+		d:       d,
+	}, d
+}
+
+func (c *deadlineContext) Deadline() (time.Time, bool) {
+	c.d.mtx.Lock()
+	deadline := c.d.deadline
+	c.d.mtx.Unlock()
+
+	if parentDeadline, ok := c.Context.Deadline(); ok && (deadline.IsZero() || parentDeadline.Before(deadline)) {
+		return parentDeadline, true
+	}
+
+	return deadline, !deadline.IsZero()
+}
+
+func (c *deadlineContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && c.d.exceeded() {
+		return context.DeadlineExceeded
+	}
+
+	return err
+}
+
+type timeoutResponseWriter struct {
+	util.ResponseWriterWrapper
+	written bool
+}
+
+func (w *timeoutResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutResponseWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timeoutmw_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/middlewares/errormw"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+	"github.com/alien-bunny/ab/middlewares/requestmw"
+	"github.com/alien-bunny/ab/middlewares/timeoutmw"
+	"github.com/alien-bunny/ab/middlewares/translationmw"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/text/language"
+)
+
+var _ = Describe("Timeout middleware", func() {
+	logger, _, cmw := abtest.SetupConfigMiddleware()
+
+	newStack := func(timeout time.Duration) *middleware.Stack {
+		stack := middleware.NewStack(nil)
+		stack.Push(requestmw.NewRequestIDMiddleware())
+		stack.Push(logmw.New(logger))
+		stack.Push(cmw)
+		stack.Push(translationmw.New(logger, []language.Tag{language.English}))
+		stack.Push(errormw.New(true))
+		stack.Push(timeoutmw.New(timeout))
+
+		return stack
+	}
+
+	It("should let the requests through that finish in time", func() {
+		w := abtest.TestMiddleware(newStack(time.Second), func(w http.ResponseWriter, r *http.Request) {
+			deadline, ok := timeoutmw.Deadline(r)
+			Expect(ok).To(BeTrue())
+			Expect(deadline).To(BeTemporally("~", time.Now().Add(time.Second), 100*time.Millisecond))
+			w.Write([]byte("ok"))
+		})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("ok"))
+	})
+
+	It("should reply with 503 when the deadline is exceeded", func() {
+		w := abtest.TestMiddleware(newStack(20*time.Millisecond), func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			Expect(r.Context().Err()).To(Equal(context.DeadlineExceeded))
+		})
+
+		Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should convert the errors after the deadline to 503", func() {
+		w := abtest.TestMiddleware(newStack(20*time.Millisecond), func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			errors.Fail(http.StatusInternalServerError, r.Context().Err())
+		})
+
+		Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should let a route extend the deadline", func() {
+		stack := newStack(20 * time.Millisecond)
+		stack.Push(timeoutmw.New(time.Second))
+
+		w := abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(50 * time.Millisecond):
+			}
+			Expect(r.Context().Err()).NotTo(HaveOccurred())
+			w.Write([]byte("ok"))
+		})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should let a route disable the deadline", func() {
+		stack := newStack(20 * time.Millisecond)
+		stack.Push(timeoutmw.New(0))
+
+		w := abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {
+			_, ok := timeoutmw.Deadline(r)
+			Expect(ok).To(BeFalse())
+			time.Sleep(50 * time.Millisecond)
+			Expect(r.Context().Err()).NotTo(HaveOccurred())
+		})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+})
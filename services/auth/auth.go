@@ -0,0 +1,394 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alien-bunny/ab"
+	"github.com/alien-bunny/ab/lib/db"
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/event"
+	"github.com/alien-bunny/ab/lib/hash"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/middlewares/configmw"
+	"github.com/alien-bunny/ab/middlewares/dbmw"
+	"github.com/alien-bunny/ab/middlewares/errormw"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+	"github.com/alien-bunny/ab/middlewares/securitymw"
+	"github.com/alien-bunny/ab/middlewares/sessionmw"
+)
+
+const (
+	MiddlewareDependencyAuthenticated = "*auth.AuthenticatedMiddleware"
+
+	// SessionKeyUserID is the session key of the ID of the logged in user.
+	SessionKeyUserID = "uid"
+
+	// Route names.
+	RouteRegister  = "auth.register"
+	RouteLogin     = "auth.login"
+	RouteLogout    = "auth.logout"
+	RouteLogoutAll = "auth.logout.all"
+	RouteUser      = "auth.user"
+
+	// LoginIPRateLimit is the number of the allowed login requests per minute from an IP address.
+	LoginIPRateLimit = 60
+
+	authComponent  = "auth"
+	userContextKey = "abauthuser"
+)
+
+// LoginRateLimit limits the login attempts of an email address, regardless of the IP address of the client.
+var LoginRateLimit = securitymw.RateLimit{
+	Requests: 10,
+	Period:   15 * time.Minute,
+}
+
+var (
+	ErrInvalidCredentials = errors.NewError("invalid credentials", "Invalid email or password.", nil)
+	ErrUnauthenticated    = errors.NewError("unauthenticated", "Authentication required.", nil)
+	ErrSessionsNotTracked = errors.NewError("sessions are not tracked", "Logging out from every device is not supported.", nil)
+
+	emailConstraintConverter = db.ConstraintErrorConverter(map[string]string{
+		"users_email_key": "This email address is already registered.",
+	})
+)
+
+var _ server.Service = &Service{}
+var _ db.DBSchemaProvider = &Service{}
+
+// Service provides user accounts with email and password authentication.
+//
+// The service registers the following endpoints under the prefix ("/api/auth" by default):
+//
+//		POST /register: creates an account from Credentials
+//		POST /login: logs the user in with Credentials. Responds with 202 and a TwoFactorChallenge if the user has
+//		             two-factor authentication enabled.
+//		POST /login/totp: completes the login with a TwoFactorCode
+//		POST /logout: logs the user out
+//		POST /logout/all: logs the user out from every session. Requires a session store, see sessionmw.SessionIDStore.
+//		GET /user: returns the logged in user
+//		GET /permissions: lists the permissions
+//		GET /users/:id/roles, PUT /users/:id/roles: returns or sets the roles of a user
+//		GET /tokens: lists the API tokens of the logged in user
+//		POST /tokens: creates an API token from a TokenRequest
+//		DELETE /tokens/:id: revokes an API token
+//		POST /totp/enroll: starts the TOTP enrollment, returns a TwoFactorEnrollment
+//		POST /totp/confirm: enables TOTP with the first TwoFactorCode, returns the RecoveryCodes
+//		POST /totp/verify: verifies the second factor for the StepUpMiddleware
+//		DELETE /totp: disables TOTP
+//		POST /totp/recovery-codes: regenerates the RecoveryCodes
+//
+// The logged in user is stored in the session. Use GetUser() to get the current user and Authenticated() to protect
+// endpoints. Machine clients can authenticate with API tokens instead, if the application enables them with
+// UseTokens().
+type Service struct {
+	dispatcher *event.Dispatcher
+	prefix     string
+
+	// DisableRegistration removes the register endpoint, e.g. when the accounts are created by an administrator.
+	DisableRegistration bool
+}
+
+// NewService creates an authentication service.
+func NewService(dispatcher *event.Dispatcher) *Service {
+	return &Service{
+		dispatcher: dispatcher,
+		prefix:     "/api/auth",
+	}
+}
+
+// SetPrefix sets the path prefix of the endpoints.
+func (s *Service) SetPrefix(prefix string) *Service {
+	s.prefix = prefix
+	return s
+}
+
+func (s *Service) Name() string {
+	return "auth"
+}
+
+func (s *Service) DBSchema() db.SchemaGenerations {
+	return usersSchema()
+}
+
+// ConfigSchema registers the RolesConfig and the TwoFactorConfig.
+func (s *Service) ConfigSchema() map[string]reflect.Type {
+	return map[string]reflect.Type{
+		rolesConfigKey:     reflect.TypeOf(RolesConfig{}),
+		twoFactorConfigKey: reflect.TypeOf(TwoFactorConfig{}),
+	}
+}
+
+func (s *Service) Register(srv *server.Server) error {
+	g := srv.Group(s.prefix)
+
+	if !s.DisableRegistration {
+		g.Post("/register", ab.WrapHandlerFunc(s.registerHandler), dbmw.Begin()).SetName(RouteRegister)
+	}
+	g.Post("/login", ab.WrapHandlerFunc(s.loginHandler), &securitymw.RateLimitMiddleware{
+		Requests: LoginIPRateLimit,
+		Period:   "1m",
+		Scope:    "login",
+	}).SetName(RouteLogin)
+	g.Post("/logout", ab.WrapHandlerFunc(s.logoutHandler)).SetName(RouteLogout)
+	g.Post("/logout/all", ab.WrapHandlerFunc(s.logoutAllHandler), Authenticated()).SetName(RouteLogoutAll)
+	g.Get("/user", ab.WrapHandlerFunc(s.userHandler), Authenticated()).SetName(RouteUser)
+	s.registerRoleEndpoints(g)
+	s.registerTokenEndpoints(g)
+	s.registerTwoFactorEndpoints(g)
+
+	return nil
+}
+
+func (s *Service) registerHandler(w http.ResponseWriter, r *http.Request) {
+	c := &Credentials{}
+	ab.MustDecode(r, c)
+	ab.MaybeFail(http.StatusBadRequest, c.Validate())
+
+	pw, err := hash.DefaultHashPassword(c.Password)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+
+	u := &User{
+		Email:    c.Email,
+		Password: pw,
+	}
+
+	err = InsertUser(ab.GetDB(r), u)
+	ab.MaybeFail(http.StatusConflict, db.ConvertDBError(err, emailConstraintConverter))
+
+	errs := s.dispatcher.Dispatch(NewUserEvent(EventRegister, r, u))
+	ab.MaybeFail(http.StatusInternalServerError, errors.NewMultiError(errs))
+
+	ab.Render(r).SetCode(http.StatusCreated).JSON(u)
+}
+
+func (s *Service) loginHandler(w http.ResponseWriter, r *http.Request) {
+	c := &Credentials{}
+	ab.MustDecode(r, c)
+
+	limitAttempts(w, r, "login", strings.ToLower(strings.TrimSpace(c.Email)), LoginRateLimit)
+
+	u, err := Authenticate(ab.GetDB(r), c.Email, c.Password)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if u == nil {
+		logmw.Info(r, authComponent, logmw.CategoryValidationFailure).Log("login", "failed", "email", c.Email)
+		ab.Fail(http.StatusUnauthorized, ErrInvalidCredentials)
+	}
+
+	errs := s.dispatcher.Dispatch(NewUserEvent(EventBeforeLogin, r, u))
+	ab.MaybeFail(http.StatusForbidden, errors.NewMultiError(errs))
+
+	if StartTwoFactorLogin(r, u) {
+		ab.Render(r).SetCode(http.StatusAccepted).JSON(TwoFactorChallenge{TwoFactor: true})
+		return
+	}
+
+	s.completeLogin(r, u)
+
+	ab.Render(r).JSON(u)
+}
+
+// limitAttempts fails with 429 if the attempts identified by key have used up the limit.
+//
+// Unlike securitymw.RateLimitMiddleware, the key can come from the request body, e.g. an email address.
+func limitAttempts(w http.ResponseWriter, r *http.Request, scope, key string, limit securitymw.RateLimit) {
+	res, err := securitymw.GetRateLimitStore(r).Take(configmw.GetNamespace(r)+":"+scope+":"+key, limit)
+	if err != nil {
+		logmw.Error(r, authComponent, nil).Log("error", err)
+		return
+	}
+
+	if !res.Allowed {
+		logmw.Info(r, authComponent, securitymw.CategoryAudit).Log("rate limited", scope, "key", key)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+		ab.Fail(http.StatusTooManyRequests, securitymw.ErrRateLimited)
+	}
+}
+
+func (s *Service) completeLogin(r *http.Request, u *User) {
+	Login(r, u)
+
+	errs := s.dispatcher.Dispatch(NewUserEvent(EventLogin, r, u))
+	ab.MaybeFail(http.StatusInternalServerError, errors.NewMultiError(errs))
+}
+
+func (s *Service) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	u := GetUser(r)
+	Logout(r)
+
+	if u != nil {
+		errs := s.dispatcher.Dispatch(NewUserEvent(EventLogout, r, u))
+		ab.MaybeFail(http.StatusInternalServerError, errors.NewMultiError(errs))
+	}
+}
+
+func (s *Service) logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	store := sessionmw.GetSessionIDStore(r)
+	if store == nil {
+		ab.Fail(http.StatusNotImplemented, ErrSessionsNotTracked)
+	}
+
+	ab.MaybeFail(http.StatusInternalServerError, store.InvalidateAll(GetUser(r).ID.String()))
+
+	s.logoutHandler(w, r)
+}
+
+func (s *Service) userHandler(w http.ResponseWriter, r *http.Request) {
+	ab.Render(r).JSON(GetUser(r))
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// Authenticate checks an email address and a password. It returns nil if the credentials are invalid.
+//
+// The password is hashed even if the user does not exist, so the response time does not reveal the registered
+// email addresses. If the stored hash uses an outdated algorithm or outdated parameters, it is transparently
+// replaced with a hash made by the default algorithm. A failed rehash does not fail the authentication.
+func Authenticate(conn db.DB, email, password string) (*User, error) {
+	u, err := LoadUserByEmail(conn, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if u == nil {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = hash.DefaultHashPassword(util.RandomString(16))
+		})
+		hash.VerifyPassword(password, dummyHash)
+		return nil, nil
+	}
+
+	ok, err := hash.VerifyPassword(password, u.Password)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	if hash.NeedsRehash(u.Password) {
+		if h, err := hash.DefaultHashPassword(password); err == nil {
+			if err = UpdatePassword(conn, u.ID, h); err == nil {
+				u.Password = h
+			}
+		}
+	}
+
+	return u, nil
+}
+
+// Login binds a user to the session of the request.
+//
+// The session ID is regenerated to prevent session fixation. The user becomes the owner of the session, so it can be
+// invalidated with the other sessions of the user. The second factor state of the previous user is removed.
+func Login(r *http.Request, u *User) {
+	sess := sessionmw.GetSession(r)
+	delete(sess, SessionKeyTwoFactorVerified)
+	clearTwoFactorLogin(r)
+	sess.Regenerate()
+	sess[SessionKeyUserID] = u.ID.String()
+	sessionmw.SetOwner(r, u.ID.String())
+}
+
+// Logout removes the user from the session of the request.
+func Logout(r *http.Request) {
+	sess := sessionmw.GetSession(r)
+	delete(sess, SessionKeyUserID)
+	delete(sess, SessionKeyTwoFactorVerified)
+	sessionmw.SetOwner(r, "")
+	sess.Regenerate()
+}
+
+// GetUserID returns the ID of the logged in user. It is empty for anonymous users.
+//
+// The ID comes from the API token for token authenticated requests, and from the session otherwise.
+func GetUserID(r *http.Request) string {
+	if t := GetToken(r); t != nil {
+		return t.UserID.String()
+	}
+
+	if securitymw.GetBearerToken(r) != "" {
+		return ""
+	}
+
+	return sessionmw.GetSession(r)[SessionKeyUserID]
+}
+
+// GetUser returns the logged in user, or nil for anonymous users.
+//
+// The user is loaded from the database, unless the AuthenticatedMiddleware has already loaded it.
+func GetUser(r *http.Request) *User {
+	if u, ok := r.Context().Value(userContextKey).(*User); ok {
+		return u
+	}
+
+	id := GetUserID(r)
+	if id == "" {
+		return nil
+	}
+
+	u, err := LoadUser(ab.GetDB(r), id)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if u == nil {
+		logmw.Warn(r, authComponent, nil).Log("user not found", id)
+		Logout(r)
+		return nil
+	}
+
+	u.Sanitize()
+
+	return u
+}
+
+var _ middleware.Middleware = &AuthenticatedMiddleware{}
+
+// AuthenticatedMiddleware rejects the requests of anonymous users with 401.
+type AuthenticatedMiddleware struct{}
+
+// Authenticated creates an AuthenticatedMiddleware.
+func Authenticated() *AuthenticatedMiddleware {
+	return &AuthenticatedMiddleware{}
+}
+
+func (m *AuthenticatedMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := GetUser(r)
+		if u == nil {
+			errors.Fail(http.StatusUnauthorized, ErrUnauthenticated)
+		}
+
+		r = util.SetContext(r, userContextKey, u)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *AuthenticatedMiddleware) Dependencies() []string {
+	return []string{
+		sessionmw.MiddlewareDependencySession,
+		dbmw.MiddlewareDependencyDB,
+		errormw.MiddlewareDependencyError,
+	}
+}
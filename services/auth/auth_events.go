@@ -0,0 +1,67 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"net/http"
+
+	"github.com/alien-bunny/ab/lib/event"
+)
+
+const (
+	EventRegister = "auth-register"
+	// EventBeforeLogin is dispatched after the credentials are verified. A subscriber can stop the login with an error.
+	EventBeforeLogin = "auth-before-login"
+	EventLogin       = "auth-login"
+	EventLogout      = "auth-logout"
+)
+
+var _ event.Event = &UserEvent{}
+
+// UserEvent is dispatched when the authentication state of a user changes.
+type UserEvent struct {
+	name string
+	r    *http.Request
+	user *User
+}
+
+// NewUserEvent creates a UserEvent.
+func NewUserEvent(name string, r *http.Request, user *User) *UserEvent {
+	return &UserEvent{
+		name: name,
+		r:    r,
+		user: user,
+	}
+}
+
+func (e *UserEvent) Name() string {
+	return e.name
+}
+
+func (e *UserEvent) ErrorStrategy() event.ErrorStrategy {
+	if e.name == EventBeforeLogin {
+		return event.ErrorStrategyStop
+	}
+
+	return event.ErrorStrategyAggregate
+}
+
+func (e *UserEvent) Request() *http.Request {
+	return e.r
+}
+
+func (e *UserEvent) User() *User {
+	return e.user
+}
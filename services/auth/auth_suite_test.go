@@ -0,0 +1,76 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alien-bunny/ab"
+	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/config"
+	"github.com/alien-bunny/ab/lib/event"
+	"github.com/alien-bunny/ab/lib/hash"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/services/auth"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAuth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Auth Suite")
+}
+
+var _, clientFactory = abtest.HopMock(func(conf *config.Store, s *server.Server, dispatcher *event.Dispatcher, base, schema string) (abtest.DataMockerFunc, error) {
+	s.RegisterService(auth.NewService(dispatcher))
+	auth.UseTokens(s)
+
+	_, saver, err := conf.GetWritable(strings.TrimPrefix(base, "http://")).GetWritable("roles")
+	if err != nil {
+		return nil, err
+	}
+	if err = saver.Save(auth.RolesConfig{
+		Permissions: map[string][]string{
+			"editor": {"view articles", "edit articles"},
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	s.PostF("/api/test/administrator", func(w http.ResponseWriter, r *http.Request) {
+		u := auth.GetUser(r)
+		ab.MaybeFail(http.StatusInternalServerError, auth.SetRoles(ab.GetDB(r), u.ID, []string{auth.RoleAdministrator}))
+	}, auth.Authenticated())
+
+	s.GetF("/api/test/stepup", func(w http.ResponseWriter, r *http.Request) {
+	}, auth.StepUp(time.Minute))
+
+	s.PostF("/api/test/legacyhash", func(w http.ResponseWriter, r *http.Request) {
+		c := &auth.Credentials{}
+		ab.MustDecode(r, c)
+		h, err := hash.HashPassword(c.Password, 16, 16384, 8, 1, 32)
+		ab.MaybeFail(http.StatusInternalServerError, err)
+		ab.MaybeFail(http.StatusInternalServerError, auth.UpdatePassword(ab.GetDB(r), auth.GetUser(r).ID, h))
+	}, auth.Authenticated())
+
+	s.GetF("/api/test/needsrehash", func(w http.ResponseWriter, r *http.Request) {
+		ab.Render(r).JSON(hash.NeedsRehash(auth.GetUser(r).Password))
+	}, auth.Authenticated())
+
+	return nil, nil
+})
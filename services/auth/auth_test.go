@@ -0,0 +1,135 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth_test
+
+import (
+	"net/http"
+
+	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/services/auth"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+)
+
+var _ = Describe("Auth", func() {
+	It("should register, log in and log out a user", func() {
+		client := clientFactory()
+		creds := &auth.Credentials{
+			Email:    util.RandomString(8) + "@example.com",
+			Password: util.RandomString(16),
+		}
+
+		By("checking the anonymous user")
+		client.Request("GET", "/api/auth/user", nil, nil, nil, http.StatusUnauthorized)
+
+		By("registering")
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(creds), nil, func(resp *http.Response) {
+			u := &auth.User{}
+			client.AssertJSON(resp, u, PointTo(MatchFields(IgnoreExtras, Fields{
+				"ID":       Not(BeZero()),
+				"Email":    Equal(creds.Email),
+				"Password": BeEmpty(),
+			})))
+		}, http.StatusCreated)
+
+		By("registering the same email again")
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(creds), nil, nil, http.StatusConflict)
+
+		By("logging in with a wrong password")
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(&auth.Credentials{
+			Email:    creds.Email,
+			Password: "wrong password",
+		}), nil, nil, http.StatusUnauthorized)
+
+		By("logging in")
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, func(resp *http.Response) {
+			u := &auth.User{}
+			client.AssertJSON(resp, u, PointTo(MatchFields(IgnoreExtras, Fields{
+				"Email":    Equal(creds.Email),
+				"Password": BeEmpty(),
+			})))
+		}, http.StatusOK)
+
+		By("checking the logged in user")
+		client.Request("GET", "/api/auth/user", nil, nil, func(resp *http.Response) {
+			u := &auth.User{}
+			client.AssertJSON(resp, u, PointTo(MatchFields(IgnoreExtras, Fields{
+				"Email":    Equal(creds.Email),
+				"Password": BeEmpty(),
+			})))
+		}, http.StatusOK)
+
+		By("logging out")
+		client.Request("POST", "/api/auth/logout", nil, nil, nil, http.StatusNoContent)
+		client.Request("GET", "/api/auth/user", nil, nil, nil, http.StatusUnauthorized)
+	})
+
+	It("should rehash outdated password hashes on login", func() {
+		client := clientFactory()
+		creds := &auth.Credentials{
+			Email:    util.RandomString(8) + "@example.com",
+			Password: util.RandomString(16),
+		}
+
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(creds), nil, nil, http.StatusCreated)
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, nil, http.StatusOK)
+
+		By("downgrading the password hash")
+		client.Request("POST", "/api/test/legacyhash", client.JSONBuffer(creds), nil, nil, http.StatusNoContent)
+		client.Request("GET", "/api/test/needsrehash", nil, nil, func(resp *http.Response) {
+			var needsRehash bool
+			client.AssertJSON(resp, &needsRehash, PointTo(BeTrue()))
+		}, http.StatusOK)
+
+		By("logging in again")
+		client.Request("POST", "/api/auth/logout", nil, nil, nil, http.StatusNoContent)
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, nil, http.StatusOK)
+		client.Request("GET", "/api/test/needsrehash", nil, nil, func(resp *http.Response) {
+			var needsRehash bool
+			client.AssertJSON(resp, &needsRehash, PointTo(BeFalse()))
+		}, http.StatusOK)
+	})
+
+	It("should limit the login attempts of an email address", func() {
+		client := clientFactory()
+		creds := &auth.Credentials{
+			Email:    util.RandomString(8) + "@example.com",
+			Password: util.RandomString(16),
+		}
+
+		for i := 0; i < auth.LoginRateLimit.Requests; i++ {
+			client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, nil, http.StatusUnauthorized)
+		}
+
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, func(resp *http.Response) {
+			Expect(resp.Header.Get("Retry-After")).NotTo(BeEmpty())
+		}, http.StatusTooManyRequests)
+	})
+
+	It("should reject invalid registrations", func() {
+		client := clientFactory()
+
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(&auth.Credentials{
+			Email:    "invalid",
+			Password: util.RandomString(16),
+		}), nil, nil, http.StatusBadRequest)
+
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(&auth.Credentials{
+			Email:    util.RandomString(8) + "@example.com",
+			Password: "short",
+		}), nil, nil, http.StatusBadRequest)
+	})
+})
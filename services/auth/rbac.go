@@ -0,0 +1,414 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/alien-bunny/ab"
+	"github.com/alien-bunny/ab/lib/db"
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/lib/uuid"
+	"github.com/alien-bunny/ab/middlewares/configmw"
+	"github.com/alien-bunny/ab/middlewares/dbmw"
+	"github.com/alien-bunny/ab/middlewares/errormw"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+	"github.com/alien-bunny/ab/middlewares/sessionmw"
+)
+
+const (
+	MiddlewareDependencyAuthorization = "*auth.AuthorizationMiddleware"
+
+	// RoleAnonymous is the role of the users who are not logged in.
+	RoleAnonymous = "anonymous"
+	// RoleAuthenticated is the role of every logged in user.
+	RoleAuthenticated = "authenticated"
+	// RoleAdministrator has every permission.
+	RoleAdministrator = "administrator"
+
+	// PermissionAdministerRoles allows listing the permissions and assigning roles to the users.
+	PermissionAdministerRoles = "administer roles"
+
+	// RouteName of the role endpoints.
+	RoutePermissions = "auth.permissions"
+	RouteUserRoles   = "auth.userroles"
+	RouteSetRoles    = "auth.setroles"
+
+	rolesConfigKey = "roles"
+)
+
+var ErrForbidden = errors.NewError("forbidden", "You do not have permission to perform this action.", nil)
+
+// Permission is a named privilege.
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var (
+	permissionsMtx sync.RWMutex
+	permissions    = map[string]Permission{}
+)
+
+func init() {
+	RegisterPermission(PermissionAdministerRoles, "List the permissions and assign roles to the users.")
+}
+
+// RegisterPermission makes a permission discoverable.
+//
+// Registering a permission again overwrites its description.
+func RegisterPermission(name, description string) {
+	permissionsMtx.Lock()
+	permissions[name] = Permission{
+		Name:        name,
+		Description: description,
+	}
+	permissionsMtx.Unlock()
+}
+
+// MaybeRegisterPermission registers a permission if it is not registered yet.
+func MaybeRegisterPermission(name string) {
+	permissionsMtx.Lock()
+	if _, found := permissions[name]; !found {
+		permissions[name] = Permission{Name: name}
+	}
+	permissionsMtx.Unlock()
+}
+
+// Permissions returns the registered permissions, ordered by name.
+func Permissions() []Permission {
+	permissionsMtx.RLock()
+	list := make([]Permission, 0, len(permissions))
+	for _, p := range permissions {
+		list = append(list, p)
+	}
+	permissionsMtx.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// RolesConfig is the per-site configuration of the roles under the "roles" key.
+//
+//		{
+//			"roles": {
+//				"Permissions": {
+//					"anonymous": ["view articles"],
+//					"editor": ["view articles", "edit articles"]
+//				}
+//			}
+//		}
+//
+// The administrator role has every permission.
+type RolesConfig struct {
+	// Permissions maps the roles to their permissions.
+	Permissions map[string][]string
+}
+
+// CanGrant checks if a user with the given roles can grant or revoke a role.
+//
+// Only administrators can grant the administrator role. The roles that are not in the config cannot be granted, so
+// they cannot gain permissions later. Other roles can be granted if the user has every permission of the role.
+func (c RolesConfig) CanGrant(roles []string, role string) bool {
+	if role == RoleAnonymous || role == RoleAuthenticated {
+		return true
+	}
+	if role == RoleAdministrator {
+		return hasRole(roles, RoleAdministrator)
+	}
+
+	if !c.isDefined(role) {
+		return false
+	}
+	if hasRole(roles, RoleAdministrator) {
+		return true
+	}
+
+	for _, p := range c.Permissions[role] {
+		if !c.HasPermission(roles, p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isDefined checks if a role is a built-in role or it is in the config.
+func (c RolesConfig) isDefined(role string) bool {
+	if role == RoleAnonymous || role == RoleAuthenticated || role == RoleAdministrator {
+		return true
+	}
+
+	_, found := c.Permissions[role]
+
+	return found
+}
+
+// HasPermission checks if a role has a permission.
+func (c RolesConfig) HasPermission(roles []string, permission string) bool {
+	for _, role := range roles {
+		if role == RoleAdministrator {
+			return true
+		}
+
+		for _, p := range c.Permissions[role] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getRolesConfig(r *http.Request) RolesConfig {
+	c, err := configmw.GetConfig(r).Get(rolesConfigKey)
+	if err != nil {
+		logmw.Info(r, authComponent, configmw.CategoryConfigNotFound).Log("error", err)
+	}
+
+	if rc, ok := c.(RolesConfig); ok {
+		return rc
+	}
+
+	return RolesConfig{}
+}
+
+// LoadRoles loads the roles that are assigned to a user.
+func LoadRoles(conn db.DB, userID uuid.UUID) ([]string, error) {
+	rows, err := conn.Query(`SELECT role FROM user_roles WHERE user_id = $1 ORDER BY role`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// SetRoles replaces the roles of a user.
+func SetRoles(conn db.DB, userID uuid.UUID, roles []string) error {
+	if _, err := conn.Exec(`DELETE FROM user_roles WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		if role == RoleAnonymous || role == RoleAuthenticated {
+			continue
+		}
+		if _, err := conn.Exec(`INSERT INTO user_roles(user_id, role) VALUES($1, $2) ON CONFLICT DO NOTHING`, userID, role); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetRoles returns the roles of the current user, including the anonymous or authenticated role.
+func GetRoles(r *http.Request) []string {
+	u := GetUser(r)
+	if u == nil {
+		return []string{RoleAnonymous}
+	}
+
+	roles, err := LoadRoles(ab.GetDB(r), u.ID)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+
+	return append([]string{RoleAuthenticated}, roles...)
+}
+
+// HasPermission checks if the current user has a permission.
+//
+// The permissions of the token authenticated requests are limited to the scopes of the token.
+func HasPermission(r *http.Request, permission string) bool {
+	if t := GetToken(r); t != nil && !t.HasScope(permission) {
+		return false
+	}
+
+	return getRolesConfig(r).HasPermission(GetRoles(r), permission)
+}
+
+// RequirePermission fails with 403 if the current user does not have the permission.
+func RequirePermission(r *http.Request, permission string) {
+	if !HasPermission(r, permission) {
+		logmw.Info(r, authComponent, nil).Log("permission denied", permission, "user", GetUserID(r))
+		errors.Fail(http.StatusForbidden, ErrForbidden)
+	}
+}
+
+var _ middleware.Middleware = &AuthorizationMiddleware{}
+
+// PermissionAuthorizer returns a function that returns ErrForbidden if the current user does not have the permission.
+// The permission is registered with MaybeRegisterPermission().
+//
+// It can be used as a resource.Authorizer.
+func PermissionAuthorizer(permission string) func(r *http.Request) error {
+	MaybeRegisterPermission(permission)
+
+	return func(r *http.Request) error {
+		if !HasPermission(r, permission) {
+			logmw.Info(r, authComponent, nil).Log("permission denied", permission, "user", GetUserID(r))
+			return ErrForbidden
+		}
+
+		return nil
+	}
+}
+
+// AuthorizationMiddleware rejects the requests of the users without a permission with 403.
+type AuthorizationMiddleware struct {
+	permission string
+}
+
+// Authorize creates an AuthorizationMiddleware. The permission is registered with MaybeRegisterPermission().
+func Authorize(permission string) *AuthorizationMiddleware {
+	MaybeRegisterPermission(permission)
+
+	return &AuthorizationMiddleware{
+		permission: permission,
+	}
+}
+
+func (m *AuthorizationMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RequirePermission(r, m.permission)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *AuthorizationMiddleware) Dependencies() []string {
+	return []string{
+		configmw.MiddlewareDependencyConfig,
+		sessionmw.MiddlewareDependencySession,
+		dbmw.MiddlewareDependencyDB,
+		errormw.MiddlewareDependencyError,
+	}
+}
+
+func (s *Service) registerRoleEndpoints(g *server.Group) {
+	admin := Authorize(PermissionAdministerRoles)
+
+	g.Get("/permissions", ab.WrapHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ab.Render(r).JSON(Permissions())
+	}), admin).SetName(RoutePermissions)
+
+	g.Get("/users/:id/roles", ab.WrapHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := loadUserParam(r)
+		roles, err := LoadRoles(ab.GetDB(r), id)
+		ab.MaybeFail(http.StatusInternalServerError, err)
+		ab.Render(r).JSON(roles)
+	}), admin).SetName(RouteUserRoles)
+
+	g.Put("/users/:id/roles", ab.WrapHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := loadUserParam(r)
+		roles := []string{}
+		ab.MustDecode(r, &roles)
+
+		current, err := LoadRoles(ab.GetDB(r), id)
+		ab.MaybeFail(http.StatusInternalServerError, err)
+		rc := getRolesConfig(r)
+		for _, role := range changedRoles(current, roles) {
+			// The roles that are not in the config have no permissions, so they can be revoked by anyone who
+			// administers the roles.
+			if hasRole(current, role) && !rc.isDefined(role) {
+				continue
+			}
+			if !canGrant(r, role) {
+				logmw.Info(r, authComponent, nil).Log("role change denied", role, "user", id, "by", GetUserID(r))
+				ab.Fail(http.StatusForbidden, ErrForbidden)
+			}
+		}
+
+		ab.MaybeFail(http.StatusInternalServerError, SetRoles(ab.GetDB(r), id, roles))
+		logmw.Info(r, authComponent, nil).Log("roles changed", id, "roles", roles, "by", GetUserID(r))
+		ab.Render(r).JSON(roles)
+	}), admin, dbmw.Begin()).SetName(RouteSetRoles)
+}
+
+// canGrant checks if the current user can grant or revoke a role.
+//
+// The token authenticated requests are limited to the scopes of the token, so they cannot grant the administrator role.
+func canGrant(r *http.Request, role string) bool {
+	rc := getRolesConfig(r)
+	if !rc.CanGrant(GetRoles(r), role) {
+		return false
+	}
+
+	if t := GetToken(r); t != nil {
+		if role == RoleAdministrator {
+			return false
+		}
+		for _, p := range rc.Permissions[role] {
+			if !t.HasScope(p) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// changedRoles returns the roles that are only in one of the lists.
+func changedRoles(current, roles []string) []string {
+	var changed []string
+	for _, role := range roles {
+		if !hasRole(current, role) {
+			changed = append(changed, role)
+		}
+	}
+	for _, role := range current {
+		if !hasRole(roles, role) {
+			changed = append(changed, role)
+		}
+	}
+
+	return changed
+}
+
+func loadUserParam(r *http.Request) uuid.UUID {
+	id := ab.GetParams(r).ByName("id")
+	u, err := LoadUser(ab.GetDB(r), id)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if u == nil {
+		ab.Fail(http.StatusNotFound, nil)
+	}
+
+	return u.ID
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth_test
+
+import (
+	"net/http"
+
+	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/services/auth"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+)
+
+var _ = Describe("RBAC", func() {
+	It("should check the permissions of the roles", func() {
+		c := auth.RolesConfig{
+			Permissions: map[string][]string{
+				auth.RoleAnonymous: {"view articles"},
+				"editor":           {"view articles", "edit articles"},
+			},
+		}
+
+		Expect(c.HasPermission([]string{auth.RoleAnonymous}, "view articles")).To(BeTrue())
+		Expect(c.HasPermission([]string{auth.RoleAnonymous}, "edit articles")).To(BeFalse())
+		Expect(c.HasPermission([]string{auth.RoleAuthenticated, "editor"}, "edit articles")).To(BeTrue())
+		Expect(c.HasPermission([]string{auth.RoleAdministrator}, "delete articles")).To(BeTrue())
+		Expect(c.HasPermission(nil, "view articles")).To(BeFalse())
+	})
+
+	It("should only let the users grant the roles with their own permissions", func() {
+		c := auth.RolesConfig{
+			Permissions: map[string][]string{
+				"editor":  {"view articles", "edit articles"},
+				"manager": {auth.PermissionAdministerRoles, "view articles"},
+			},
+		}
+
+		Expect(c.CanGrant([]string{"manager"}, auth.RoleAuthenticated)).To(BeTrue())
+		Expect(c.CanGrant([]string{"manager"}, "manager")).To(BeTrue())
+		Expect(c.CanGrant([]string{"manager"}, "editor")).To(BeFalse())
+		Expect(c.CanGrant([]string{"manager", "editor"}, "editor")).To(BeTrue())
+		Expect(c.CanGrant([]string{"manager", "editor"}, auth.RoleAdministrator)).To(BeFalse())
+		Expect(c.CanGrant([]string{auth.RoleAdministrator}, auth.RoleAdministrator)).To(BeTrue())
+		Expect(c.CanGrant([]string{auth.RoleAdministrator}, "editor")).To(BeTrue())
+		Expect(c.CanGrant([]string{"manager"}, "unknown")).To(BeFalse())
+		Expect(c.CanGrant([]string{auth.RoleAdministrator}, "unknown")).To(BeFalse())
+	})
+
+	It("should list the registered permissions", func() {
+		auth.RegisterPermission("rbac test permission", "A permission for testing.")
+		auth.MaybeRegisterPermission("rbac test permission")
+		auth.Authorize("rbac test middleware permission")
+		auth.PermissionAuthorizer("rbac test authorizer permission")
+
+		Expect(auth.Permissions()).To(ContainElement(auth.Permission{
+			Name:        "rbac test permission",
+			Description: "A permission for testing.",
+		}))
+		Expect(auth.Permissions()).To(ContainElement(auth.Permission{
+			Name: "rbac test middleware permission",
+		}))
+		Expect(auth.Permissions()).To(ContainElement(auth.Permission{
+			Name: "rbac test authorizer permission",
+		}))
+	})
+
+	It("should protect the role endpoints", func() {
+		client := clientFactory()
+		creds := &auth.Credentials{
+			Email:    util.RandomString(8) + "@example.com",
+			Password: util.RandomString(16),
+		}
+
+		By("checking the anonymous user")
+		client.Request("GET", "/api/auth/permissions", nil, nil, nil, http.StatusForbidden)
+
+		By("checking a user without roles")
+		u := &auth.User{}
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(creds), nil, func(resp *http.Response) {
+			client.AssertJSON(resp, u, PointTo(MatchFields(IgnoreExtras, Fields{
+				"ID": Not(BeZero()),
+			})))
+		}, http.StatusCreated)
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, nil, http.StatusOK)
+		client.Request("GET", "/api/auth/permissions", nil, nil, nil, http.StatusForbidden)
+
+		By("checking an administrator")
+		client.Request("POST", "/api/test/administrator", nil, nil, nil, http.StatusOK)
+		client.Request("GET", "/api/auth/permissions", nil, nil, func(resp *http.Response) {
+			permissions := []auth.Permission{}
+			client.AssertJSON(resp, &permissions, ContainElement(MatchFields(IgnoreExtras, Fields{
+				"Name": Equal(auth.PermissionAdministerRoles),
+			})))
+		}, http.StatusOK)
+
+		By("assigning roles")
+		rolesURL := "/api/auth/users/" + u.ID.String() + "/roles"
+		client.Request("PUT", rolesURL, client.JSONBuffer([]string{auth.RoleAdministrator, "unknown"}), nil, nil, http.StatusForbidden)
+		client.Request("PUT", rolesURL, client.JSONBuffer([]string{auth.RoleAdministrator, "editor", auth.RoleAuthenticated}), nil, nil, http.StatusOK)
+		client.Request("GET", rolesURL, nil, nil, func(resp *http.Response) {
+			roles := []string{}
+			client.AssertJSON(resp, &roles, Equal([]string{auth.RoleAdministrator, "editor"}))
+		}, http.StatusOK)
+		client.Request("GET", "/api/auth/users/"+util.RandomString(8)+"/roles", nil, nil, nil, http.StatusNotFound)
+	})
+})
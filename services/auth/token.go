@@ -0,0 +1,326 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alien-bunny/ab"
+	"github.com/alien-bunny/ab/lib/db"
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/lib/uuid"
+	"github.com/alien-bunny/ab/middlewares/dbmw"
+	"github.com/alien-bunny/ab/middlewares/errormw"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+	"github.com/alien-bunny/ab/middlewares/securitymw"
+	"github.com/lib/pq"
+)
+
+const (
+	MiddlewareDependencyToken = "*auth.TokenMiddleware"
+
+	// TokenPrefix is the prefix of the API token secrets. It makes the tokens easy to recognize, e.g. for secret scanners.
+	TokenPrefix = "abt_"
+
+	// Route names of the token endpoints.
+	RouteTokens      = "auth.tokens"
+	RouteCreateToken = "auth.token.create"
+	RouteRevokeToken = "auth.token.revoke"
+
+	tokenContextKey = "abauthtoken"
+	tokenComponent  = "auth token"
+
+	// tokenTouchInterval limits how often the last use of a token is written to the database.
+	tokenTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidToken     = errors.NewError("invalid token", "Invalid or expired API token.", nil)
+	ErrTokenName        = errors.NewError("missing token name", "The token must have a name.", nil)
+	ErrTokenExpired     = errors.NewError("token expires in the past", "The expiry of the token must be in the future.", nil)
+	ErrTokenNotAccepted = errors.NewError("token not accepted", "API tokens cannot be managed with API tokens.", nil)
+)
+
+// APIToken is a personal or service API token.
+//
+// Machine clients send the token in the "Authorization: Bearer" header instead of using a session. Only the hash of the
+// token is stored, the token itself is returned once, when it is created.
+type APIToken struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	Hash   string    `json:"-"`
+	// Scopes are the permissions that the token can exercise. A token never has more permissions than its user.
+	Scopes   []string   `json:"scopes"`
+	Expires  *time.Time `json:"expires"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used"`
+}
+
+// HasScope checks if the token is allowed to use a permission.
+func (t *APIToken) HasScope(permission string) bool {
+	for _, s := range t.Scopes {
+		if s == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired checks if the token is expired.
+func (t *APIToken) Expired() bool {
+	return t.Expires != nil && !t.Expires.After(time.Now())
+}
+
+// TokenRequest is the payload of the token creation endpoint.
+type TokenRequest struct {
+	Name    string     `json:"name"`
+	Scopes  []string   `json:"scopes"`
+	Expires *time.Time `json:"expires"`
+}
+
+func (t *TokenRequest) Validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return ErrTokenName
+	}
+
+	if t.Expires != nil && !t.Expires.After(time.Now()) {
+		return ErrTokenExpired
+	}
+
+	return nil
+}
+
+// CreatedToken is the response of the token creation endpoint.
+type CreatedToken struct {
+	*APIToken
+	Token string `json:"token"`
+}
+
+// GenerateToken generates a new API token secret.
+func GenerateToken() string {
+	return TokenPrefix + util.RandomSecret(32)
+}
+
+// HashToken hashes an API token secret.
+//
+// The secrets are long random values, so a fast hash is sufficient.
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+const tokenFields = `id, user_id, name, hash, scopes, expires, created, last_used`
+
+func scanToken(row interface {
+	Scan(dest ...interface{}) error
+}) (*APIToken, error) {
+	t := &APIToken{}
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Hash, pq.Array(&t.Scopes), &t.Expires, &t.Created, &t.LastUsed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// InsertToken saves a new token. The ID and the creation time are set on t.
+func InsertToken(conn db.DB, t *APIToken) error {
+	if t.Scopes == nil {
+		t.Scopes = []string{}
+	}
+
+	return conn.QueryRow(`INSERT INTO api_tokens(user_id, name, hash, scopes, expires) VALUES($1, $2, $3, $4, $5) RETURNING id, created`,
+		t.UserID, t.Name, t.Hash, pq.Array(t.Scopes), t.Expires,
+	).Scan(&t.ID, &t.Created)
+}
+
+// LoadTokenBySecret loads a token by its secret. Expired tokens are returned as well.
+func LoadTokenBySecret(conn db.DB, secret string) (*APIToken, error) {
+	return scanToken(conn.QueryRow(`SELECT `+tokenFields+` FROM api_tokens WHERE hash = $1`, HashToken(secret)))
+}
+
+// LoadTokens loads the tokens of a user.
+func LoadTokens(conn db.DB, userID uuid.UUID) ([]*APIToken, error) {
+	rows, err := conn.Query(`SELECT `+tokenFields+` FROM api_tokens WHERE user_id = $1 ORDER BY created`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*APIToken{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// RevokeToken deletes a token of a user. It returns false if the user does not have such a token.
+func RevokeToken(conn db.DB, userID uuid.UUID, id string) (bool, error) {
+	if uuid.FromStringOrNil(id).IsNil() {
+		return false, nil
+	}
+
+	res, err := conn.Exec(`DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+
+	return affected > 0, err
+}
+
+// touchToken records the use of a token.
+func touchToken(conn db.DB, t *APIToken) error {
+	if t.LastUsed != nil && time.Since(*t.LastUsed) < tokenTouchInterval {
+		return nil
+	}
+
+	_, err := conn.Exec(`UPDATE api_tokens SET last_used = now() WHERE id = $1`, t.ID)
+
+	return err
+}
+
+// GetToken returns the API token that authenticated the request, or nil.
+func GetToken(r *http.Request) *APIToken {
+	t, _ := r.Context().Value(tokenContextKey).(*APIToken)
+	return t
+}
+
+var _ middleware.Middleware = &TokenMiddleware{}
+
+// TokenMiddleware authenticates the requests with an "Authorization: Bearer" header.
+//
+// The session is ignored for these requests: the user comes from the token, and the permissions are limited to the
+// scopes of the token. Requests with an invalid or expired token are rejected with 401.
+//
+// The application adds this middleware to the server with UseTokens().
+type TokenMiddleware struct{}
+
+func (m *TokenMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := securitymw.GetBearerToken(r)
+		if secret == "" || GetToken(r) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, AuthenticateBearer(w, r, secret))
+	})
+}
+
+// AuthenticateBearer loads the token of the request, and fails the request if the token is invalid or expired.
+//
+// It is a securitymw.BearerAuthenticator, so the CSRF check is only skipped for the valid tokens.
+func AuthenticateBearer(w http.ResponseWriter, r *http.Request, secret string) *http.Request {
+	conn := ab.GetDB(r)
+	t, err := LoadTokenBySecret(conn, secret)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if t == nil || t.Expired() {
+		logmw.Info(r, tokenComponent, logmw.CategoryValidationFailure).Log("invalid token", t != nil)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		errors.Fail(http.StatusUnauthorized, ErrInvalidToken)
+	}
+
+	if err = touchToken(conn, t); err != nil {
+		logmw.Warn(r, tokenComponent, nil).Log("error", err)
+	}
+
+	return util.SetContext(r, tokenContextKey, t)
+}
+
+func (m *TokenMiddleware) Dependencies() []string {
+	return []string{
+		dbmw.MiddlewareDependencyDB,
+		errormw.MiddlewareDependencyError,
+	}
+}
+
+// UseTokens enables the API token authentication on a server created by ab.Pet(): it adds the TokenMiddleware, and
+// sets AuthenticateBearer as the BearerAuthenticator of the server, so the token authenticated requests skip the
+// CSRF check.
+func UseTokens(s *server.Server) {
+	s.Use(&TokenMiddleware{})
+	s.BearerAuthenticator = AuthenticateBearer
+}
+
+// sessionOnly rejects the token authenticated requests, so a leaked token cannot be used to create more tokens.
+func sessionOnly(r *http.Request) {
+	if GetToken(r) != nil {
+		errors.Fail(http.StatusForbidden, ErrTokenNotAccepted)
+	}
+}
+
+func (s *Service) registerTokenEndpoints(g *server.Group) {
+	g.Get("/tokens", ab.WrapHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionOnly(r)
+		tokens, err := LoadTokens(ab.GetDB(r), GetUser(r).ID)
+		ab.MaybeFail(http.StatusInternalServerError, err)
+		ab.Render(r).JSON(tokens)
+	}), Authenticated()).SetName(RouteTokens)
+
+	g.Post("/tokens", ab.WrapHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionOnly(r)
+		tr := &TokenRequest{}
+		ab.MustDecode(r, tr)
+		ab.MaybeFail(http.StatusBadRequest, tr.Validate())
+
+		secret := GenerateToken()
+		t := &APIToken{
+			UserID:  GetUser(r).ID,
+			Name:    tr.Name,
+			Hash:    HashToken(secret),
+			Scopes:  tr.Scopes,
+			Expires: tr.Expires,
+		}
+		ab.MaybeFail(http.StatusInternalServerError, InsertToken(ab.GetDB(r), t))
+		logmw.Info(r, tokenComponent, securitymw.CategoryAudit).Log("token created", t.ID, "user", t.UserID, "scopes", strings.Join(t.Scopes, ","))
+
+		ab.Render(r).SetCode(http.StatusCreated).JSON(CreatedToken{
+			APIToken: t,
+			Token:    secret,
+		})
+	}), Authenticated()).SetName(RouteCreateToken)
+
+	g.Delete("/tokens/:id", ab.WrapHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionOnly(r)
+		id := ab.GetParams(r).ByName("id")
+		found, err := RevokeToken(ab.GetDB(r), GetUser(r).ID, id)
+		ab.MaybeFail(http.StatusInternalServerError, err)
+		if !found {
+			ab.Fail(http.StatusNotFound, nil)
+		}
+		logmw.Info(r, tokenComponent, securitymw.CategoryAudit).Log("token revoked", id, "user", GetUserID(r))
+	}), Authenticated()).SetName(RouteRevokeToken)
+}
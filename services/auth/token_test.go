@@ -0,0 +1,142 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/services/auth"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+)
+
+func bearer(token string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.Header.Del("X-CSRF-Token")
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func createToken(client *abtest.TestClient, tr *auth.TokenRequest) *auth.CreatedToken {
+	t := &auth.CreatedToken{}
+	client.Request("POST", "/api/auth/tokens", client.JSONBuffer(tr), nil, func(resp *http.Response) {
+		client.AssertJSON(resp, t, PointTo(MatchFields(IgnoreExtras, Fields{
+			"APIToken": PointTo(MatchFields(IgnoreExtras, Fields{
+				"ID":   Not(BeZero()),
+				"Name": Equal(tr.Name),
+			})),
+			"Token": HavePrefix(auth.TokenPrefix),
+		})))
+	}, http.StatusCreated)
+
+	return t
+}
+
+var _ = Describe("API tokens", func() {
+	It("should hash the tokens", func() {
+		token := auth.GenerateToken()
+		Expect(token).To(HavePrefix(auth.TokenPrefix))
+		Expect(auth.HashToken(token)).To(HaveLen(64))
+		Expect(auth.HashToken(token)).NotTo(Equal(auth.HashToken(auth.GenerateToken())))
+	})
+
+	It("should check the scopes and the expiry", func() {
+		past := time.Now().Add(-time.Hour)
+		t := &auth.APIToken{
+			Scopes:  []string{"view articles"},
+			Expires: &past,
+		}
+		Expect(t.HasScope("view articles")).To(BeTrue())
+		Expect(t.HasScope("edit articles")).To(BeFalse())
+		Expect(t.Expired()).To(BeTrue())
+
+		t.Expires = nil
+		Expect(t.Expired()).To(BeFalse())
+	})
+
+	It("should authenticate machine clients", func() {
+		client := clientFactory()
+		creds := &auth.Credentials{
+			Email:    util.RandomString(8) + "@example.com",
+			Password: util.RandomString(16),
+		}
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(creds), nil, nil, http.StatusCreated)
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, nil, http.StatusOK)
+		client.Request("POST", "/api/test/administrator", nil, nil, nil, http.StatusOK)
+
+		By("rejecting invalid token requests")
+		client.Request("POST", "/api/auth/tokens", client.JSONBuffer(&auth.TokenRequest{}), nil, nil, http.StatusBadRequest)
+		past := time.Now().Add(-time.Minute)
+		client.Request("POST", "/api/auth/tokens", client.JSONBuffer(&auth.TokenRequest{
+			Name:    "expired",
+			Expires: &past,
+		}), nil, nil, http.StatusBadRequest)
+
+		By("creating tokens")
+		future := time.Now().Add(time.Hour)
+		scoped := createToken(client, &auth.TokenRequest{
+			Name:    "scoped",
+			Scopes:  []string{auth.PermissionAdministerRoles},
+			Expires: &future,
+		})
+		unscoped := createToken(client, &auth.TokenRequest{
+			Name: "unscoped",
+		})
+		client.Request("GET", "/api/auth/tokens", nil, nil, func(resp *http.Response) {
+			tokens := []*auth.APIToken{}
+			client.AssertJSON(resp, &tokens, HaveLen(2))
+		}, http.StatusOK)
+
+		By("using the tokens without a session")
+		machine := clientFactory()
+		machine.Request("GET", "/api/auth/user", nil, bearer(scoped.Token), func(resp *http.Response) {
+			u := &auth.User{}
+			machine.AssertJSON(resp, u, PointTo(MatchFields(IgnoreExtras, Fields{
+				"Email": Equal(creds.Email),
+			})))
+		}, http.StatusOK)
+		machine.Request("GET", "/api/auth/permissions", nil, bearer(scoped.Token), nil, http.StatusOK)
+		machine.Request("GET", "/api/auth/permissions", nil, bearer(unscoped.Token), nil, http.StatusForbidden)
+		machine.Request("GET", "/api/auth/user", nil, bearer(auth.GenerateToken()), nil, http.StatusUnauthorized)
+
+		By("skipping the CSRF check, but not allowing token management")
+		machine.Request("POST", "/api/auth/tokens", machine.JSONBuffer(&auth.TokenRequest{
+			Name: "from token",
+		}), bearer(scoped.Token), nil, http.StatusForbidden)
+		machine.Request("POST", "/api/auth/logout", nil, bearer(auth.GenerateToken()), nil, http.StatusUnauthorized)
+
+		By("ignoring the session of the bearer requests")
+		client.Request("GET", "/api/auth/user", nil, bearer(auth.GenerateToken()), nil, http.StatusUnauthorized)
+
+		By("tracking the last use")
+		client.Request("GET", "/api/auth/tokens", nil, nil, func(resp *http.Response) {
+			tokens := []*auth.APIToken{}
+			client.AssertJSON(resp, &tokens, ContainElement(PointTo(MatchFields(IgnoreExtras, Fields{
+				"Name":     Equal("scoped"),
+				"LastUsed": Not(BeNil()),
+			}))))
+		}, http.StatusOK)
+
+		By("revoking a token")
+		client.Request("DELETE", "/api/auth/tokens/"+scoped.ID.String(), nil, nil, nil, http.StatusNoContent)
+		client.Request("DELETE", "/api/auth/tokens/"+scoped.ID.String(), nil, nil, nil, http.StatusNotFound)
+		machine.Request("GET", "/api/auth/user", nil, bearer(scoped.Token), nil, http.StatusUnauthorized)
+		machine.Request("GET", "/api/auth/user", nil, bearer(unscoped.Token), nil, http.StatusOK)
+	})
+})
@@ -0,0 +1,601 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alien-bunny/ab"
+	"github.com/alien-bunny/ab/lib/db"
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/lib/totp"
+	"github.com/alien-bunny/ab/lib/uuid"
+	"github.com/alien-bunny/ab/middlewares/configmw"
+	"github.com/alien-bunny/ab/middlewares/cryptmw"
+	"github.com/alien-bunny/ab/middlewares/dbmw"
+	"github.com/alien-bunny/ab/middlewares/errormw"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+	"github.com/alien-bunny/ab/middlewares/securitymw"
+	"github.com/alien-bunny/ab/middlewares/sessionmw"
+)
+
+const (
+	MiddlewareDependencyStepUp = "*auth.StepUpMiddleware"
+
+	// SessionKeyTwoFactorVerified is the session key of the user ID and the time of the last second factor
+	// verification, in the "<user id>:<unix time>" format.
+	SessionKeyTwoFactorVerified = "_2fa"
+
+	// RecoveryCodeCount is the number of the generated recovery codes.
+	RecoveryCodeCount = 10
+
+	// TwoFactorLoginTimeout is the time limit between the two steps of the login.
+	TwoFactorLoginTimeout = 5 * time.Minute
+
+	// TwoFactorIPRateLimit is the number of the allowed two-factor verification requests per minute from an IP address.
+	TwoFactorIPRateLimit = 30
+
+	// Route names of the two-factor endpoints.
+	RouteLoginTOTP         = "auth.login.totp"
+	RouteTOTPEnroll        = "auth.totp.enroll"
+	RouteTOTPConfirm       = "auth.totp.confirm"
+	RouteTOTPVerify        = "auth.totp.verify"
+	RouteTOTPDisable       = "auth.totp.disable"
+	RouteTOTPRecoveryCodes = "auth.totp.recoverycodes"
+
+	sessionKeyTwoFactorPending   = "_2fa_pending"
+	sessionKeyTwoFactorPendingAt = "_2fa_pending_at"
+	twoFactorConfigKey           = "twofactor"
+	twoFactorComponent           = "auth two-factor"
+
+	// recoveryCodeAlphabet omits the characters that are easy to confuse.
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+var (
+	ErrTwoFactorEnrolled    = errors.NewError("two-factor already enrolled", "Two-factor authentication is already enabled.", nil)
+	ErrTwoFactorNotEnrolled = errors.NewError("two-factor not enrolled", "Two-factor authentication is not enabled.", nil)
+	ErrInvalidCode          = errors.NewError("invalid code", "Invalid or expired verification code.", nil)
+	ErrStepUpRequired       = errors.NewError("step-up required", "Please verify your identity with your second factor.", nil)
+	ErrNoPendingLogin       = errors.NewError("no pending login", "The login is expired. Please log in again.", nil)
+)
+
+// TwoFactorRateLimit limits the second factor verification attempts of a user, regardless of the session and the IP
+// address of the client.
+var TwoFactorRateLimit = securitymw.RateLimit{
+	Requests: 10,
+	Period:   15 * time.Minute,
+}
+
+// TwoFactorConfig is the per-site configuration of the two-factor authentication under the "twofactor" key.
+type TwoFactorConfig struct {
+	// Issuer is shown in the authenticator apps. Defaults to the host name.
+	Issuer string
+	// Skew is the number of periods of clock drift that are tolerated. Defaults to totp.DefaultSkew.
+	Skew *uint
+}
+
+func (c TwoFactorConfig) options() totp.Options {
+	skew := uint(totp.DefaultSkew)
+	if c.Skew != nil {
+		skew = *c.Skew
+	}
+
+	return totp.Options{
+		Skew: skew,
+	}
+}
+
+func getTwoFactorConfig(r *http.Request) TwoFactorConfig {
+	c, err := configmw.GetConfig(r).Get(twoFactorConfigKey)
+	if err != nil {
+		logmw.Info(r, twoFactorComponent, configmw.CategoryConfigNotFound).Log("error", err)
+	}
+
+	tc, _ := c.(TwoFactorConfig)
+	if tc.Issuer == "" {
+		tc.Issuer = r.Host
+	}
+
+	return tc
+}
+
+// TwoFactor is the TOTP enrollment of a user.
+type TwoFactor struct {
+	UserID uuid.UUID
+	// Secret is encrypted with cryptmw.
+	Secret    string
+	Confirmed bool
+	// LastCounter is the time step of the last accepted code.
+	LastCounter int64
+}
+
+// TwoFactorEnrollment is the response of the enrollment endpoint.
+//
+// The URI can be shown as a QR code, the secret can be typed into the authenticator apps.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorCode is the payload of the verification endpoints. Either the code or a recovery code must be set.
+type TwoFactorCode struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// RecoveryCodes is the response of the endpoints that generate recovery codes. The codes are only shown once.
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactorChallenge is the response of the login endpoint when the user must verify the second factor.
+type TwoFactorChallenge struct {
+	TwoFactor bool `json:"two_factor"`
+}
+
+// LoadTwoFactor loads the TOTP enrollment of a user.
+func LoadTwoFactor(conn db.DB, userID uuid.UUID) (*TwoFactor, error) {
+	tf := &TwoFactor{}
+	err := conn.QueryRow(`SELECT user_id, secret, confirmed, last_counter FROM user_totp WHERE user_id = $1`, userID).
+		Scan(&tf.UserID, &tf.Secret, &tf.Confirmed, &tf.LastCounter)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tf, nil
+}
+
+// HasTwoFactor checks if a user has a confirmed TOTP enrollment.
+func HasTwoFactor(conn db.DB, userID uuid.UUID) (bool, error) {
+	tf, err := LoadTwoFactor(conn, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return tf != nil && tf.Confirmed, nil
+}
+
+// DeleteTwoFactor removes the TOTP enrollment and the recovery codes of a user.
+func DeleteTwoFactor(conn db.DB, userID uuid.UUID) error {
+	if _, err := conn.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	_, err := conn.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID)
+
+	return err
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of a user. The returned codes are stored hashed with
+// HashRecoveryCode().
+func GenerateRecoveryCodes(conn db.DB, userID uuid.UUID) ([]string, error) {
+	if _, err := conn.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		codes[i] = generateRecoveryCode()
+
+		h := HashRecoveryCode(codes[i])
+		if _, err := conn.Exec(`INSERT INTO user_recovery_codes(user_id, hash) VALUES($1, $2)`, userID, h); err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCode hashes a recovery code.
+//
+// The codes are random, so a fast hash is sufficient, and it keeps the unauthenticated login step cheap.
+func HashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// UseRecoveryCode checks a recovery code and deletes it if it is valid.
+//
+// The hashes are compared in constant time.
+func UseRecoveryCode(conn db.DB, userID uuid.UUID, code string) (bool, error) {
+	actual := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+
+	rows, err := conn.Query(`SELECT id, hash FROM user_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var match *uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		var h string
+		if err = rows.Scan(&id, &h); err != nil {
+			return false, err
+		}
+
+		expected, err := hex.DecodeString(h)
+		if err != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare(expected, actual[:]) == 1 && match == nil {
+			match = &id
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+
+	if match == nil {
+		return false, nil
+	}
+
+	res, err := conn.Exec(`DELETE FROM user_recovery_codes WHERE id = $1`, *match)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+
+	return affected == 1, err
+}
+
+func generateRecoveryCode() string {
+	code := make([]byte, 0, 11)
+	buf := make([]byte, 1)
+	for len(code) < 11 {
+		if len(code) == 5 {
+			code = append(code, '-')
+			continue
+		}
+
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
+		// Rejection sampling keeps the distribution uniform.
+		if int(buf[0]) < 256-256%len(recoveryCodeAlphabet) {
+			code = append(code, recoveryCodeAlphabet[int(buf[0])%len(recoveryCodeAlphabet)])
+		}
+	}
+
+	return string(code)
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if len(code) == 10 {
+		code = code[:5] + "-" + code[5:]
+	}
+
+	return code
+}
+
+// VerifySecondFactor checks a TOTP code or a recovery code of a user.
+//
+// The time step of an accepted TOTP code is recorded, so a code cannot be used twice.
+func VerifySecondFactor(r *http.Request, userID uuid.UUID, input TwoFactorCode) bool {
+	conn := ab.GetDB(r)
+
+	tf, err := LoadTwoFactor(conn, userID)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if tf == nil {
+		return false
+	}
+
+	if input.Code != "" {
+		return verifyTOTP(r, conn, tf, input.Code)
+	}
+
+	if input.RecoveryCode != "" && tf.Confirmed {
+		ok, err := UseRecoveryCode(conn, userID, input.RecoveryCode)
+		ab.MaybeFail(http.StatusInternalServerError, err)
+		if ok {
+			logmw.Info(r, twoFactorComponent, securitymw.CategoryAudit).Log("recovery code used", userID)
+		}
+
+		return ok
+	}
+
+	return false
+}
+
+func verifyTOTP(r *http.Request, conn db.DB, tf *TwoFactor, code string) bool {
+	secret, err := totp.DecodeSecret(cryptmw.DecryptString(r, tf.Secret))
+	if err != nil || len(secret) == 0 {
+		logmw.Error(r, twoFactorComponent, nil).Log("secret", "undecryptable", "user", tf.UserID)
+		return false
+	}
+
+	counter, ok := totp.Validate(secret, code, time.Now(), getTwoFactorConfig(r).options())
+	if !ok {
+		return false
+	}
+
+	res, err := conn.Exec(`UPDATE user_totp SET last_counter = $2 WHERE user_id = $1 AND last_counter < $2`, tf.UserID, counter)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	affected, err := res.RowsAffected()
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if affected == 0 {
+		logmw.Info(r, twoFactorComponent, logmw.CategoryValidationFailure).Log("replayed code", tf.UserID)
+		return false
+	}
+
+	return true
+}
+
+// MarkTwoFactorVerified records in the session that the logged in user verified the second factor.
+func MarkTwoFactorVerified(r *http.Request) {
+	sessionmw.GetSession(r)[SessionKeyTwoFactorVerified] = GetUserID(r) + ":" + strconv.FormatInt(time.Now().Unix(), 10)
+}
+
+// IsTwoFactorVerified checks if the user verified the second factor in the session within maxAge.
+//
+// Token authenticated requests are never verified, and neither are the verifications of other users in the same
+// session.
+func IsTwoFactorVerified(r *http.Request, maxAge time.Duration) bool {
+	userID := GetUserID(r)
+	if GetToken(r) != nil || userID == "" {
+		return false
+	}
+
+	parts := strings.SplitN(sessionmw.GetSession(r)[SessionKeyTwoFactorVerified], ":", 2)
+	if len(parts) != 2 || parts[0] != userID {
+		return false
+	}
+
+	verified, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return time.Since(time.Unix(verified, 0)) <= maxAge
+}
+
+var _ middleware.Middleware = &StepUpMiddleware{}
+
+// StepUpMiddleware requires a recent second factor verification in the session.
+//
+// Anonymous users are rejected with 401, users without a recent verification with 403. The clients can verify the
+// second factor on the /totp/verify endpoint and retry the request.
+type StepUpMiddleware struct {
+	maxAge time.Duration
+}
+
+// StepUp creates a StepUpMiddleware.
+func StepUp(maxAge time.Duration) *StepUpMiddleware {
+	return &StepUpMiddleware{
+		maxAge: maxAge,
+	}
+}
+
+func (m *StepUpMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetUser(r) == nil {
+			errors.Fail(http.StatusUnauthorized, ErrUnauthenticated)
+		}
+
+		if !IsTwoFactorVerified(r, m.maxAge) {
+			errors.Fail(http.StatusForbidden, ErrStepUpRequired)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *StepUpMiddleware) Dependencies() []string {
+	return []string{
+		sessionmw.MiddlewareDependencySession,
+		dbmw.MiddlewareDependencyDB,
+		errormw.MiddlewareDependencyError,
+	}
+}
+
+// StartTwoFactorLogin starts the two-factor login if the user has enabled it.
+//
+// The user is stored in the session until the second factor is verified with the /login/totp endpoint. It returns
+// false if the user has not enabled two-factor authentication, so the user can be logged in with Login().
+func StartTwoFactorLogin(r *http.Request, u *User) bool {
+	enrolled, err := HasTwoFactor(ab.GetDB(r), u.ID)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if !enrolled {
+		return false
+	}
+
+	sess := sessionmw.GetSession(r)
+	sess[sessionKeyTwoFactorPending] = u.ID.String()
+	sess[sessionKeyTwoFactorPendingAt] = strconv.FormatInt(time.Now().Unix(), 10)
+
+	return true
+}
+
+// limitTwoFactorAttempts caps the verification attempts of a user, so the codes cannot be guessed with new sessions.
+func limitTwoFactorAttempts(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	limitAttempts(w, r, "totp", userID.String(), TwoFactorRateLimit)
+}
+
+// pendingTwoFactorLogin returns the user who passed the first step of the login.
+func pendingTwoFactorLogin(r *http.Request) *User {
+	sess := sessionmw.GetSession(r)
+	id := sess[sessionKeyTwoFactorPending]
+	started, err := strconv.ParseInt(sess[sessionKeyTwoFactorPendingAt], 10, 64)
+	if id == "" || err != nil || time.Since(time.Unix(started, 0)) > TwoFactorLoginTimeout {
+		return nil
+	}
+
+	u, err := LoadUser(ab.GetDB(r), id)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+
+	return u
+}
+
+func clearTwoFactorLogin(r *http.Request) {
+	sess := sessionmw.GetSession(r)
+	delete(sess, sessionKeyTwoFactorPending)
+	delete(sess, sessionKeyTwoFactorPendingAt)
+}
+
+func (s *Service) registerTwoFactorEndpoints(g *server.Group) {
+	limit := func() *securitymw.RateLimitMiddleware {
+		return &securitymw.RateLimitMiddleware{
+			Requests: TwoFactorIPRateLimit,
+			Period:   "1m",
+			Scope:    "totp",
+		}
+	}
+
+	g.Post("/login/totp", ab.WrapHandlerFunc(s.loginTOTPHandler), limit()).SetName(RouteLoginTOTP)
+	g.Post("/totp/enroll", ab.WrapHandlerFunc(s.enrollTOTPHandler), Authenticated()).SetName(RouteTOTPEnroll)
+	g.Post("/totp/confirm", ab.WrapHandlerFunc(s.confirmTOTPHandler), Authenticated(), limit(), dbmw.Begin()).SetName(RouteTOTPConfirm)
+	g.Post("/totp/verify", ab.WrapHandlerFunc(s.verifyTOTPHandler), Authenticated(), limit()).SetName(RouteTOTPVerify)
+	g.Delete("/totp", ab.WrapHandlerFunc(s.disableTOTPHandler), StepUp(TwoFactorLoginTimeout), dbmw.Begin()).SetName(RouteTOTPDisable)
+	g.Post("/totp/recovery-codes", ab.WrapHandlerFunc(s.recoveryCodesHandler), StepUp(TwoFactorLoginTimeout), dbmw.Begin()).SetName(RouteTOTPRecoveryCodes)
+}
+
+func (s *Service) loginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	input := TwoFactorCode{}
+	ab.MustDecode(r, &input)
+
+	u := pendingTwoFactorLogin(r)
+	if u == nil {
+		clearTwoFactorLogin(r)
+		ab.Fail(http.StatusUnauthorized, ErrNoPendingLogin)
+	}
+
+	limitTwoFactorAttempts(w, r, u.ID)
+	if !VerifySecondFactor(r, u.ID, input) {
+		logmw.Info(r, twoFactorComponent, logmw.CategoryValidationFailure).Log("login", "failed", "user", u.ID)
+		ab.Fail(http.StatusUnauthorized, ErrInvalidCode)
+	}
+
+	clearTwoFactorLogin(r)
+	s.completeLogin(r, u)
+	MarkTwoFactorVerified(r)
+
+	u.Sanitize()
+	ab.Render(r).JSON(u)
+}
+
+func (s *Service) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	sessionOnly(r)
+	u := GetUser(r)
+	conn := ab.GetDB(r)
+
+	enrolled, err := HasTwoFactor(conn, u.ID)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if enrolled {
+		ab.Fail(http.StatusConflict, ErrTwoFactorEnrolled)
+	}
+
+	secret, err := totp.GenerateSecret()
+	ab.MaybeFail(http.StatusInternalServerError, err)
+
+	_, err = conn.Exec(`
+		INSERT INTO user_totp(user_id, secret) VALUES($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_counter = 0, created = now()
+	`, u.ID, cryptmw.EncryptString(r, totp.EncodeSecret(secret)))
+	ab.MaybeFail(http.StatusInternalServerError, err)
+
+	c := getTwoFactorConfig(r)
+	ab.Render(r).JSON(TwoFactorEnrollment{
+		Secret: totp.EncodeSecret(secret),
+		URI:    totp.URI(secret, c.Issuer, u.Email, c.options()),
+	})
+}
+
+func (s *Service) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	sessionOnly(r)
+	input := TwoFactorCode{}
+	ab.MustDecode(r, &input)
+
+	u := GetUser(r)
+	conn := ab.GetDB(r)
+
+	tf, err := LoadTwoFactor(conn, u.ID)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if tf == nil {
+		ab.Fail(http.StatusNotFound, ErrTwoFactorNotEnrolled)
+	}
+	if tf.Confirmed {
+		ab.Fail(http.StatusConflict, ErrTwoFactorEnrolled)
+	}
+
+	limitTwoFactorAttempts(w, r, u.ID)
+	if input.Code == "" || !verifyTOTP(r, conn, tf, input.Code) {
+		ab.Fail(http.StatusBadRequest, ErrInvalidCode)
+	}
+
+	_, err = conn.Exec(`UPDATE user_totp SET confirmed = true WHERE user_id = $1`, u.ID)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+
+	codes, err := GenerateRecoveryCodes(conn, u.ID)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+
+	MarkTwoFactorVerified(r)
+	logmw.Info(r, twoFactorComponent, securitymw.CategoryAudit).Log("enabled", u.ID)
+
+	ab.Render(r).JSON(RecoveryCodes{RecoveryCodes: codes})
+}
+
+func (s *Service) verifyTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	sessionOnly(r)
+	input := TwoFactorCode{}
+	ab.MustDecode(r, &input)
+
+	u := GetUser(r)
+	limitTwoFactorAttempts(w, r, u.ID)
+	if !VerifySecondFactor(r, u.ID, input) {
+		logmw.Info(r, twoFactorComponent, logmw.CategoryValidationFailure).Log("step-up", "failed", "user", u.ID)
+		ab.Fail(http.StatusUnauthorized, ErrInvalidCode)
+	}
+
+	MarkTwoFactorVerified(r)
+}
+
+func (s *Service) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	u := GetUser(r)
+	ab.MaybeFail(http.StatusInternalServerError, DeleteTwoFactor(ab.GetDB(r), u.ID))
+	delete(sessionmw.GetSession(r), SessionKeyTwoFactorVerified)
+	logmw.Info(r, twoFactorComponent, securitymw.CategoryAudit).Log("disabled", u.ID)
+}
+
+func (s *Service) recoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	u := GetUser(r)
+	conn := ab.GetDB(r)
+
+	enrolled, err := HasTwoFactor(conn, u.ID)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if !enrolled {
+		ab.Fail(http.StatusNotFound, ErrTwoFactorNotEnrolled)
+	}
+
+	codes, err := GenerateRecoveryCodes(conn, u.ID)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	logmw.Info(r, twoFactorComponent, securitymw.CategoryAudit).Log("recovery codes regenerated", u.ID)
+
+	ab.Render(r).JSON(RecoveryCodes{RecoveryCodes: codes})
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/totp"
+	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/services/auth"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+)
+
+func totpCode(secret []byte, offset int64) string {
+	return totp.Code(secret, totp.Counter(time.Now(), totp.Options{})+offset, totp.Options{})
+}
+
+func expectTwoFactorChallenge(client *abtest.TestClient, creds *auth.Credentials) {
+	client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, func(resp *http.Response) {
+		challenge := &auth.TwoFactorChallenge{}
+		client.AssertJSON(resp, challenge, PointTo(MatchFields(IgnoreExtras, Fields{
+			"TwoFactor": BeTrue(),
+		})))
+	}, http.StatusAccepted)
+	client.Request("GET", "/api/auth/user", nil, nil, nil, http.StatusUnauthorized)
+}
+
+var _ = Describe("Two-factor authentication", func() {
+	It("should enroll, log in with TOTP and recovery codes, and disable", func() {
+		client := clientFactory()
+		creds := &auth.Credentials{
+			Email:    util.RandomString(8) + "@example.com",
+			Password: util.RandomString(16),
+		}
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(creds), nil, nil, http.StatusCreated)
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, nil, http.StatusOK)
+
+		By("requiring step-up authentication")
+		client.Request("GET", "/api/test/stepup", nil, nil, nil, http.StatusForbidden)
+
+		By("enrolling")
+		enrollment := &auth.TwoFactorEnrollment{}
+		client.Request("POST", "/api/auth/totp/enroll", nil, nil, func(resp *http.Response) {
+			client.AssertJSON(resp, enrollment, PointTo(MatchFields(IgnoreExtras, Fields{
+				"Secret": Not(BeEmpty()),
+				"URI":    HavePrefix("otpauth://totp/"),
+			})))
+		}, http.StatusOK)
+		secret, err := totp.DecodeSecret(enrollment.Secret)
+		Expect(err).NotTo(HaveOccurred())
+
+		client.Request("POST", "/api/auth/totp/confirm", client.JSONBuffer(&auth.TwoFactorCode{
+			Code: "000000",
+		}), nil, nil, http.StatusBadRequest)
+
+		codes := &auth.RecoveryCodes{}
+		client.Request("POST", "/api/auth/totp/confirm", client.JSONBuffer(&auth.TwoFactorCode{
+			Code: totpCode(secret, 0),
+		}), nil, func(resp *http.Response) {
+			client.AssertJSON(resp, codes, PointTo(MatchFields(IgnoreExtras, Fields{
+				"RecoveryCodes": HaveLen(auth.RecoveryCodeCount),
+			})))
+		}, http.StatusOK)
+		client.Request("GET", "/api/test/stepup", nil, nil, nil, http.StatusOK)
+		client.Request("POST", "/api/auth/totp/enroll", nil, nil, nil, http.StatusConflict)
+
+		By("logging in with a TOTP code")
+		client.Request("POST", "/api/auth/logout", nil, nil, nil, http.StatusNoContent)
+		expectTwoFactorChallenge(client, creds)
+		client.Request("POST", "/api/auth/login/totp", client.JSONBuffer(&auth.TwoFactorCode{
+			Code: totpCode(secret, 0),
+		}), nil, nil, http.StatusUnauthorized)
+		client.Request("POST", "/api/auth/login/totp", client.JSONBuffer(&auth.TwoFactorCode{
+			Code: totpCode(secret, 1),
+		}), nil, nil, http.StatusOK)
+		client.Request("GET", "/api/auth/user", nil, nil, nil, http.StatusOK)
+		client.Request("GET", "/api/test/stepup", nil, nil, nil, http.StatusOK)
+
+		By("logging in with a recovery code")
+		client.Request("POST", "/api/auth/logout", nil, nil, nil, http.StatusNoContent)
+		client.Request("GET", "/api/test/stepup", nil, nil, nil, http.StatusUnauthorized)
+		expectTwoFactorChallenge(client, creds)
+		client.Request("POST", "/api/auth/login/totp", client.JSONBuffer(&auth.TwoFactorCode{
+			RecoveryCode: codes.RecoveryCodes[0],
+		}), nil, nil, http.StatusOK)
+
+		client.Request("POST", "/api/auth/logout", nil, nil, nil, http.StatusNoContent)
+		expectTwoFactorChallenge(client, creds)
+		client.Request("POST", "/api/auth/login/totp", client.JSONBuffer(&auth.TwoFactorCode{
+			RecoveryCode: codes.RecoveryCodes[0],
+		}), nil, nil, http.StatusUnauthorized)
+		client.Request("POST", "/api/auth/login/totp", client.JSONBuffer(&auth.TwoFactorCode{
+			RecoveryCode: codes.RecoveryCodes[1],
+		}), nil, nil, http.StatusOK)
+
+		By("disabling")
+		client.Request("DELETE", "/api/auth/totp", nil, nil, nil, http.StatusNoContent)
+		client.Request("GET", "/api/test/stepup", nil, nil, nil, http.StatusForbidden)
+		client.Request("POST", "/api/auth/logout", nil, nil, nil, http.StatusNoContent)
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, nil, http.StatusOK)
+	})
+
+	It("should limit the verification attempts of a user across sessions", func() {
+		client := clientFactory()
+		creds := &auth.Credentials{
+			Email:    util.RandomString(8) + "@example.com",
+			Password: util.RandomString(16),
+		}
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(creds), nil, nil, http.StatusCreated)
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(creds), nil, nil, http.StatusOK)
+
+		enrollment := &auth.TwoFactorEnrollment{}
+		client.Request("POST", "/api/auth/totp/enroll", nil, nil, func(resp *http.Response) {
+			client.AssertJSON(resp, enrollment, Not(BeNil()))
+		}, http.StatusOK)
+		secret, err := totp.DecodeSecret(enrollment.Secret)
+		Expect(err).NotTo(HaveOccurred())
+		client.Request("POST", "/api/auth/totp/confirm", client.JSONBuffer(&auth.TwoFactorCode{
+			Code: totpCode(secret, 0),
+		}), nil, nil, http.StatusOK)
+
+		By("guessing the code from new sessions")
+		var attacker *abtest.TestClient
+		for i := 1; i < auth.TwoFactorRateLimit.Requests; i++ {
+			attacker = clientFactory()
+			expectTwoFactorChallenge(attacker, creds)
+			attacker.Request("POST", "/api/auth/login/totp", attacker.JSONBuffer(&auth.TwoFactorCode{
+				Code: "000000",
+			}), nil, nil, http.StatusUnauthorized)
+		}
+
+		attacker.Request("POST", "/api/auth/login/totp", attacker.JSONBuffer(&auth.TwoFactorCode{
+			Code: totpCode(secret, 1),
+		}), nil, nil, http.StatusTooManyRequests)
+	})
+
+	It("should not share the verification with the next user of the session", func() {
+		client := clientFactory()
+		first := &auth.Credentials{
+			Email:    util.RandomString(8) + "@example.com",
+			Password: util.RandomString(16),
+		}
+		second := &auth.Credentials{
+			Email:    util.RandomString(8) + "@example.com",
+			Password: util.RandomString(16),
+		}
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(first), nil, nil, http.StatusCreated)
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(second), nil, nil, http.StatusCreated)
+
+		By("verifying the second factor of the first user")
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(first), nil, nil, http.StatusOK)
+		enrollment := &auth.TwoFactorEnrollment{}
+		client.Request("POST", "/api/auth/totp/enroll", nil, nil, func(resp *http.Response) {
+			client.AssertJSON(resp, enrollment, Not(BeNil()))
+		}, http.StatusOK)
+		secret, err := totp.DecodeSecret(enrollment.Secret)
+		Expect(err).NotTo(HaveOccurred())
+		client.Request("POST", "/api/auth/totp/confirm", client.JSONBuffer(&auth.TwoFactorCode{
+			Code: totpCode(secret, 0),
+		}), nil, nil, http.StatusOK)
+		client.Request("GET", "/api/test/stepup", nil, nil, nil, http.StatusOK)
+
+		By("logging in the second user without logging out")
+		client.Request("POST", "/api/auth/login", client.JSONBuffer(second), nil, nil, http.StatusOK)
+		client.Request("GET", "/api/test/stepup", nil, nil, nil, http.StatusForbidden)
+	})
+
+	It("should reject the second step without a pending login", func() {
+		client := clientFactory()
+		client.Request("POST", "/api/auth/login/totp", client.JSONBuffer(&auth.TwoFactorCode{
+			Code: "123456",
+		}), nil, nil, http.StatusUnauthorized)
+	})
+})
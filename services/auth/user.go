@@ -0,0 +1,174 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/db"
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/uuid"
+)
+
+// MinPasswordLength is the minimum length of the passwords on registration.
+var MinPasswordLength = 8
+
+var (
+	ErrInvalidEmail = errors.NewError("invalid email", "Invalid email address.", nil)
+)
+
+// User is a user account.
+type User struct {
+	ID       uuid.UUID `json:"id"`
+	Email    string    `json:"email"`
+	Password string    `json:"password,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// Sanitize removes the password hash.
+func (u *User) Sanitize() {
+	u.Password = ""
+}
+
+// Credentials is the request body of the register and login endpoints.
+type Credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Validate checks the credentials of a registration.
+func (c *Credentials) Validate() error {
+	c.Email = strings.TrimSpace(c.Email)
+	if c.Email == "" || !strings.Contains(c.Email, "@") {
+		return ErrInvalidEmail
+	}
+
+	if len(c.Password) < MinPasswordLength {
+		return errors.NewError("password too short", "The password must be at least @length characters long.", map[string]string{
+			"@length": strconv.Itoa(MinPasswordLength),
+		})
+	}
+
+	return nil
+}
+
+const userFields = "id, email, password, created"
+
+func scanUser(row interface {
+	Scan(dest ...interface{}) error
+}) (*User, error) {
+	u := &User{}
+	err := row.Scan(&u.ID, &u.Email, &u.Password, &u.Created)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// LoadUser loads a user by its ID. It returns nil if the user does not exist.
+func LoadUser(conn db.DB, id string) (*User, error) {
+	if uuid.FromStringOrNil(id).IsNil() {
+		return nil, nil
+	}
+
+	return scanUser(conn.QueryRow(`SELECT `+userFields+` FROM users WHERE id = $1`, id))
+}
+
+// LoadUserByEmail loads a user by its email address. It returns nil if the user does not exist.
+func LoadUserByEmail(conn db.DB, email string) (*User, error) {
+	return scanUser(conn.QueryRow(`SELECT `+userFields+` FROM users WHERE lower(email) = lower($1)`, strings.TrimSpace(email)))
+}
+
+// InsertUser saves a new user. The password field must contain the password hash.
+func InsertUser(conn db.DB, u *User) error {
+	return conn.QueryRow(`INSERT INTO users(email, password) VALUES($1, $2) RETURNING id, created`, u.Email, u.Password).
+		Scan(&u.ID, &u.Created)
+}
+
+// UpdatePassword replaces the password hash of a user.
+func UpdatePassword(conn db.DB, id uuid.UUID, passwordHash string) error {
+	_, err := conn.Exec(`UPDATE users SET password = $1 WHERE id = $2`, passwordHash, id)
+
+	return err
+}
+
+func usersSchema() db.SchemaGenerations {
+	return db.DefineSchemaGenerations(
+		func(conn db.DB) error {
+			_, err := conn.Exec(`
+				CREATE TABLE users(
+					id uuid NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+					email text NOT NULL,
+					password text NOT NULL,
+					created timestamp with time zone NOT NULL DEFAULT now()
+				);
+				CREATE UNIQUE INDEX users_email_key ON users(lower(email));
+			`)
+			return err
+		},
+		func(conn db.DB) error {
+			_, err := conn.Exec(`
+				CREATE TABLE user_roles(
+					user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					role text NOT NULL,
+					PRIMARY KEY(user_id, role)
+				);
+			`)
+			return err
+		},
+		func(conn db.DB) error {
+			_, err := conn.Exec(`
+				CREATE TABLE api_tokens(
+					id uuid NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+					user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					name text NOT NULL,
+					hash text NOT NULL,
+					scopes text[] NOT NULL DEFAULT '{}',
+					expires timestamp with time zone,
+					created timestamp with time zone NOT NULL DEFAULT now(),
+					last_used timestamp with time zone
+				);
+				CREATE UNIQUE INDEX api_tokens_hash_key ON api_tokens(hash);
+				CREATE INDEX api_tokens_user_id_idx ON api_tokens(user_id);
+			`)
+			return err
+		},
+		func(conn db.DB) error {
+			_, err := conn.Exec(`
+				CREATE TABLE user_totp(
+					user_id uuid NOT NULL PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					secret text NOT NULL,
+					confirmed boolean NOT NULL DEFAULT false,
+					last_counter bigint NOT NULL DEFAULT 0,
+					created timestamp with time zone NOT NULL DEFAULT now()
+				);
+				CREATE TABLE user_recovery_codes(
+					id uuid NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+					user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					hash text NOT NULL
+				);
+				CREATE INDEX user_recovery_codes_user_id_idx ON user_recovery_codes(user_id);
+			`)
+			return err
+		},
+	)
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package flash provides one-shot messages (e.g. "Saved successfully") that survive a redirect.
+package flash
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+
+	"github.com/alien-bunny/ab"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+	"github.com/alien-bunny/ab/middlewares/sessionmw"
+	"github.com/alien-bunny/ab/middlewares/translationmw"
+)
+
+// The levels of the messages.
+const (
+	LevelInfo    = "info"
+	LevelSuccess = "success"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+const (
+	// SessionKeyFlashes is the session key of the pending messages.
+	SessionKeyFlashes = "_flash"
+
+	// MaxFlashes is the maximum number of the pending messages. The oldest messages are dropped.
+	MaxFlashes = 16
+
+	// TemplateName is the name of the template that AddTemplate() defines.
+	TemplateName = "flashes"
+
+	flashComponent = "flash"
+)
+
+// RouteFlashes is the name of the route of the messages endpoint.
+const RouteFlashes = "flash.flashes"
+
+// flashTemplate renders a list of Messages.
+const flashTemplate = `{{define "` + TemplateName + `"}}{{range .}}<div class="flash flash-{{.Level}}" role="alert">{{.Message}}</div>{{end}}{{end}}`
+
+// pendingFlash is the untranslated form of a message in the session.
+type pendingFlash struct {
+	Level   string            `json:"l"`
+	Message string            `json:"m"`
+	Params  map[string]string `json:"p,omitempty"`
+}
+
+// Message is a translated flash message.
+type Message struct {
+	Level string `json:"level"`
+	// Message is the translated message. It is HTML, since the parameters are formatted by the translation
+	// middleware's formatter.
+	Message template.HTML `json:"message"`
+}
+
+// AddFlash adds a message to the session of the request.
+//
+// The message and the parameters are stored untranslated, and they are translated with translationmw.GetTranslate()
+// when the messages are consumed, so the messages appear in the language of the page that displays them.
+//
+// The parameter keys must start with '@' (escaped) or '#' (escaped and emphasized). Raw ('!') parameters are not
+// allowed, because the rendered messages are trusted as HTML. AddFlash panics on an invalid key.
+func AddFlash(r *http.Request, level, message string, params map[string]string) {
+	for k := range params {
+		if !validParam(k) {
+			panic("invalid flash parameter: " + k)
+		}
+	}
+
+	flashes := pending(r)
+	flashes = append(flashes, pendingFlash{
+		Level:   level,
+		Message: message,
+		Params:  params,
+	})
+	if len(flashes) > MaxFlashes {
+		flashes = flashes[len(flashes)-MaxFlashes:]
+	}
+
+	encoded, err := json.Marshal(flashes)
+	if err != nil {
+		logmw.Error(r, flashComponent, logmw.CategoryFormatError).Log("error", err)
+		return
+	}
+
+	sessionmw.GetSession(r)[SessionKeyFlashes] = string(encoded)
+}
+
+// ConsumeFlashes removes the messages from the session, and returns them translated.
+func ConsumeFlashes(r *http.Request) []Message {
+	flashes := pending(r)
+	delete(sessionmw.GetSession(r), SessionKeyFlashes)
+
+	t := translationmw.GetTranslate(r)
+	messages := make([]Message, 0, len(flashes))
+	for _, f := range flashes {
+		if !validParams(f.Params) {
+			logmw.Warn(r, flashComponent, logmw.CategoryFormatError).Log("message", f.Message, "error", "invalid parameters")
+			continue
+		}
+		messages = append(messages, Message{
+			Level:   f.Level,
+			Message: template.HTML(t(f.Message, f.Params)),
+		})
+	}
+
+	return messages
+}
+
+// validParam tells if k is a parameter key that is escaped by the translation formatters.
+func validParam(k string) bool {
+	return k != "" && (k[0] == '@' || k[0] == '#')
+}
+
+func validParams(params map[string]string) bool {
+	for k := range params {
+		if !validParam(k) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasFlashes tells if the session of the request has pending messages.
+func HasFlashes(r *http.Request) bool {
+	return sessionmw.GetSession(r)[SessionKeyFlashes] != ""
+}
+
+func pending(r *http.Request) []pendingFlash {
+	encoded := sessionmw.GetSession(r)[SessionKeyFlashes]
+	if encoded == "" {
+		return nil
+	}
+
+	var flashes []pendingFlash
+	if err := json.Unmarshal([]byte(encoded), &flashes); err != nil {
+		logmw.Warn(r, flashComponent, logmw.CategoryFormatError).Log("error", err)
+		return nil
+	}
+
+	return flashes
+}
+
+// AddTemplate defines the "flashes" template in t, and returns t. The template renders the result of
+// ConsumeFlashes():
+//
+//		{{template "flashes" .Flashes}}
+func AddTemplate(t *template.Template) (*template.Template, error) {
+	if _, err := t.New(TemplateName).Parse(flashTemplate); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+var _ server.Service = &Service{}
+
+// Service exposes the messages for the JavaScript frontends.
+//
+// The service registers the following endpoint under the prefix ("/api/flash" by default):
+//
+//		GET /: consumes the messages, and returns them as a list of Message
+type Service struct {
+	prefix string
+}
+
+// NewService creates a flash service.
+func NewService() *Service {
+	return &Service{
+		prefix: "/api/flash",
+	}
+}
+
+// SetPrefix sets the path prefix of the endpoint.
+func (s *Service) SetPrefix(prefix string) *Service {
+	s.prefix = prefix
+	return s
+}
+
+func (s *Service) Name() string {
+	return "flash"
+}
+
+func (s *Service) Register(srv *server.Server) error {
+	srv.GetF(s.prefix, func(w http.ResponseWriter, r *http.Request) {
+		ab.Render(r).JSON(ConsumeFlashes(r))
+	}).SetName(RouteFlashes)
+
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flash_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/alien-bunny/ab"
+	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/middlewares/errormw"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+	"github.com/alien-bunny/ab/middlewares/rendermw"
+	"github.com/alien-bunny/ab/middlewares/sessionmw"
+	"github.com/alien-bunny/ab/middlewares/translationmw"
+	"github.com/alien-bunny/ab/services/flash"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/text/language"
+)
+
+var _ = Describe("Flash", func() {
+	logger, conf, cmw := abtest.SetupConfigMiddleware()
+
+	smw := sessionmw.New("", time.Hour)
+	conf.MaybeRegisterSchema(smw)
+	_, saver, _ := conf.GetWritable("test").GetWritable("session")
+	saver.Save(sessionmw.Config{
+		Key: hex.EncodeToString(abtest.FakeKey),
+	})
+
+	s := server.NewServer(conf, logger)
+	s.Use(cmw)
+	s.Use(logmw.New(logger))
+	s.Use(smw)
+	s.Use(translationmw.New(logger, []language.Tag{language.English}))
+	s.Use(errormw.New(true))
+	s.Use(rendermw.New())
+	s.RegisterService(flash.NewService())
+	s.PostF("/add", func(w http.ResponseWriter, r *http.Request) {
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+		for i := 0; i < count; i++ {
+			flash.AddFlash(r, flash.LevelInfo, "Message #i", map[string]string{
+				"#i": strconv.Itoa(i),
+			})
+		}
+		flash.AddFlash(r, flash.LevelSuccess, "Saved @name.", map[string]string{
+			"@name": r.URL.Query().Get("name"),
+		})
+	})
+	s.GetF("/has", func(w http.ResponseWriter, r *http.Request) {
+		ab.Render(r).Text(strconv.FormatBool(flash.HasFlashes(r)))
+	})
+	s.GetF("/page", func(w http.ResponseWriter, r *http.Request) {
+		t, err := flash.AddTemplate(template.Must(template.New("page").Parse(`<main>{{template "flashes" .}}</main>`)))
+		Expect(err).NotTo(HaveOccurred())
+		ab.Render(r).HTML(t, flash.ConsumeFlashes(r))
+	})
+	handler := s.Handler()
+
+	request := func(jar *cookiejar.Jar, method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+
+		r, err := abtest.NewRequest(method, target, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		u, _ := url.Parse("http://test")
+		for _, cookie := range jar.Cookies(u) {
+			r.AddCookie(cookie)
+		}
+
+		handler.ServeHTTP(w, r)
+		jar.SetCookies(u, (&http.Response{Header: w.Header()}).Cookies())
+
+		return w
+	}
+
+	consume := func(jar *cookiejar.Jar) []map[string]string {
+		w := request(jar, "GET", "/api/flash")
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var messages []map[string]string
+		Expect(json.Unmarshal(bytes.TrimPrefix(w.Body.Bytes(), []byte(")]}',\n")), &messages)).To(Succeed())
+
+		return messages
+	}
+
+	var jar *cookiejar.Jar
+
+	BeforeEach(func() {
+		jar, _ = cookiejar.New(&cookiejar.Options{
+			PublicSuffixList: publicsuffix.List,
+		})
+	})
+
+	It("should return the messages once", func() {
+		Expect(request(jar, "GET", "/has").Body.String()).To(Equal("false"))
+		Expect(request(jar, "POST", "/add?name=test").Code).To(Equal(http.StatusNoContent))
+		Expect(request(jar, "GET", "/has").Body.String()).To(Equal("true"))
+
+		Expect(consume(jar)).To(Equal([]map[string]string{
+			{"level": flash.LevelSuccess, "message": "Saved test."},
+		}))
+		Expect(consume(jar)).To(BeEmpty())
+		Expect(request(jar, "GET", "/has").Body.String()).To(Equal("false"))
+	})
+
+	It("should format the parameters", func() {
+		request(jar, "POST", "/add?count=1&name=%3Cb%3E")
+
+		Expect(consume(jar)).To(Equal([]map[string]string{
+			{"level": flash.LevelInfo, "message": "Message <em>0</em>"},
+			{"level": flash.LevelSuccess, "message": "Saved &lt;b&gt;."},
+		}))
+	})
+
+	It("should drop the oldest messages", func() {
+		request(jar, "POST", "/add?count="+strconv.Itoa(flash.MaxFlashes))
+
+		messages := consume(jar)
+		Expect(messages).To(HaveLen(flash.MaxFlashes))
+		Expect(messages[0]["message"]).To(Equal("Message <em>1</em>"))
+		Expect(messages[flash.MaxFlashes-1]["level"]).To(Equal(flash.LevelSuccess))
+	})
+
+	It("should render the messages into a template", func() {
+		request(jar, "POST", "/add?count=1&name=%3Cb%3E")
+
+		Expect(request(jar, "GET", "/page").Body.String()).To(Equal(`<main>` +
+			`<div class="flash flash-info" role="alert">Message <em>0</em></div>` +
+			`<div class="flash flash-success" role="alert">Saved &lt;b&gt;.</div>` +
+			`</main>`))
+		Expect(consume(jar)).To(BeEmpty())
+	})
+
+	It("should reject the parameters that are not escaped", func() {
+		r := httptest.NewRequest("POST", "/add", nil)
+		for _, key := range []string{"!name", "name", ""} {
+			params := map[string]string{key: "<b>"}
+			Expect(func() {
+				flash.AddFlash(r, flash.LevelInfo, "Saved !name.", params)
+			}).To(Panic())
+		}
+	})
+})
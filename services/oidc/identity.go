@@ -0,0 +1,61 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"database/sql"
+
+	"github.com/alien-bunny/ab/lib/db"
+	"github.com/alien-bunny/ab/lib/uuid"
+	"github.com/alien-bunny/ab/services/auth"
+)
+
+// LoadIdentity loads the local user that is linked to an identity of a provider.
+func LoadIdentity(conn db.DB, issuer, subject string) (*auth.User, error) {
+	var id uuid.UUID
+	err := conn.QueryRow(`SELECT user_id FROM oidc_identities WHERE issuer = $1 AND subject = $2`, issuer, subject).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.LoadUser(conn, id.String())
+}
+
+// LinkIdentity links an identity of a provider to a local user.
+func LinkIdentity(conn db.DB, issuer, subject string, userID uuid.UUID) error {
+	_, err := conn.Exec(`INSERT INTO oidc_identities(issuer, subject, user_id) VALUES($1, $2, $3)`, issuer, subject, userID)
+	return err
+}
+
+func identitiesSchema() db.SchemaGenerations {
+	return db.DefineSchemaGenerations(
+		func(conn db.DB) error {
+			_, err := conn.Exec(`
+				CREATE TABLE oidc_identities(
+					issuer text NOT NULL,
+					subject text NOT NULL,
+					user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					created timestamp with time zone NOT NULL DEFAULT now(),
+					PRIMARY KEY(issuer, subject)
+				);
+				CREATE INDEX oidc_identities_user_id_idx ON oidc_identities(user_id);
+			`)
+			return err
+		},
+	)
+}
@@ -0,0 +1,413 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/alien-bunny/ab"
+	"github.com/alien-bunny/ab/lib/db"
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/event"
+	"github.com/alien-bunny/ab/lib/hash"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/middlewares/configmw"
+	"github.com/alien-bunny/ab/middlewares/dbmw"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+	"github.com/alien-bunny/ab/middlewares/sessionmw"
+	"github.com/alien-bunny/ab/services/auth"
+)
+
+const (
+	// Route names.
+	RouteLogin    = "oidc.login"
+	RouteCallback = "oidc.callback"
+
+	// DefaultTimeout is the timeout of the requests to the providers.
+	DefaultTimeout = 10 * time.Second
+
+	sessionKeyState       = "_oidc_state"
+	sessionKeyNonce       = "_oidc_nonce"
+	sessionKeyVerifier    = "_oidc_verifier"
+	sessionKeyDestination = "_oidc_destination"
+
+	oidcComponent = "oidc"
+	configKey     = "oidc"
+)
+
+var (
+	ErrNotConfigured  = errors.NewError("oidc not configured", "Single sign-on is not available on this site.", nil)
+	ErrInvalidState   = errors.NewError("invalid state", "The sign-on request is invalid or expired. Please try again.", nil)
+	ErrAccessDenied   = errors.NewError("access denied", "The identity provider denied the sign-on.", nil)
+	ErrProvider       = errors.NewError("provider error", "The identity provider is not available.", nil)
+	ErrInvalidIDToken = errors.NewError("invalid id token", "The identity provider returned an invalid token.", nil)
+	ErrUnknownUser    = errors.NewError("unknown user", "There is no account for this identity.", nil)
+	ErrMissingEmail   = errors.NewError("missing email", "The identity provider did not share an email address.", nil)
+
+	// DefaultScopes are requested when the Config does not specify the scopes.
+	DefaultScopes = []string{"openid", "email", "profile"}
+
+	emailConstraintConverter = db.ConstraintErrorConverter(map[string]string{
+		"users_email_key": "This email address is already registered.",
+	})
+)
+
+// Config is the per-site configuration of the identity provider under the "oidc" key.
+//
+//		{
+//			"oidc": {
+//				"Issuer": "https://login.example.com",
+//				"ClientID": "my-site",
+//				"ClientSecret": "...",
+//				"AutoRegister": true
+//			}
+//		}
+type Config struct {
+	// Issuer is the issuer identifier of the provider. The provider metadata is discovered from
+	// Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL that is registered at the provider. Defaults to the callback endpoint on the
+	// current host.
+	RedirectURL string
+	// Scopes are requested from the provider. Defaults to DefaultScopes.
+	Scopes []string
+	// AutoRegister creates a local account for the unknown identities.
+	AutoRegister bool
+	// LinkByEmail links the unknown identities to the local accounts with the same email address, if the provider
+	// verified the address.
+	LinkByEmail bool
+	// TwoFactorPath is the local page where the users who enabled two-factor authentication enter their code after
+	// the sign-on. The page must send the code to the /login/totp endpoint of the auth service. The destination of the
+	// login is passed in the destination query parameter. Defaults to "/".
+	TwoFactorPath string
+	// SkipTwoFactor logs the users in without verifying their second factor, e.g. when the provider already requires
+	// one.
+	SkipTwoFactor bool
+}
+
+func (c Config) configured() bool {
+	return c.Issuer != "" && c.ClientID != ""
+}
+
+func (c Config) scopes() string {
+	if len(c.Scopes) == 0 {
+		return strings.Join(DefaultScopes, " ")
+	}
+
+	return strings.Join(c.Scopes, " ")
+}
+
+var _ server.Service = &Service{}
+var _ db.DBSchemaProvider = &Service{}
+
+// Service is an OpenID Connect relying party.
+//
+// It logs the users in with the authorization code flow and PKCE, and maps the identities to the local users of the
+// auth service. The provider is configured per site, see Config. The service registers the following endpoints under
+// the prefix ("/api/oidc" by default):
+//
+//		GET /login: redirects to the provider. The destination query parameter sets the local path where the user
+//		            lands after the login.
+//		GET /callback: the redirect endpoint of the provider
+//
+// The service depends on the users table, so it must be registered after the auth service.
+type Service struct {
+	dispatcher *event.Dispatcher
+	prefix     string
+	providers  *providerCache
+
+	// Client is used for the requests to the providers.
+	Client *http.Client
+}
+
+// NewService creates an OpenID Connect service.
+func NewService(dispatcher *event.Dispatcher) *Service {
+	return &Service{
+		dispatcher: dispatcher,
+		prefix:     "/api/oidc",
+		providers:  newProviderCache(),
+		Client: &http.Client{
+			Timeout: DefaultTimeout,
+		},
+	}
+}
+
+// SetPrefix sets the path prefix of the endpoints.
+func (s *Service) SetPrefix(prefix string) *Service {
+	s.prefix = prefix
+	return s
+}
+
+func (s *Service) Name() string {
+	return "oidc"
+}
+
+func (s *Service) DBSchema() db.SchemaGenerations {
+	return identitiesSchema()
+}
+
+// ConfigSchema registers the Config.
+func (s *Service) ConfigSchema() map[string]reflect.Type {
+	return map[string]reflect.Type{
+		configKey: reflect.TypeOf(Config{}),
+	}
+}
+
+func (s *Service) Register(srv *server.Server) error {
+	g := srv.Group(s.prefix)
+
+	g.Get("/login", ab.WrapHandlerFunc(s.loginHandler)).SetName(RouteLogin)
+	g.Get("/callback", ab.WrapHandlerFunc(s.callbackHandler), dbmw.Begin()).SetName(RouteCallback)
+
+	return nil
+}
+
+func (s *Service) loginHandler(w http.ResponseWriter, r *http.Request) {
+	c := getConfig(r)
+	p := s.provider(r, c)
+
+	state := randomToken()
+	nonce := randomToken()
+	verifier := randomToken()
+
+	sess := sessionmw.GetSession(r)
+	sess[sessionKeyState] = state
+	sess[sessionKeyNonce] = nonce
+	sess[sessionKeyVerifier] = verifier
+	sess[sessionKeyDestination] = localDestination(r.URL.Query().Get("destination"))
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", c.ClientID)
+	query.Set("redirect_uri", redirectURL(r, c))
+	query.Set("scope", c.scopes())
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", pkceChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	target := p.metadata.AuthorizationEndpoint
+	if strings.Contains(target, "?") {
+		target += "&" + query.Encode()
+	} else {
+		target += "?" + query.Encode()
+	}
+
+	redirect(w, r, target)
+}
+
+func (s *Service) callbackHandler(w http.ResponseWriter, r *http.Request) {
+	c := getConfig(r)
+
+	sess := sessionmw.GetSession(r)
+	state := sess[sessionKeyState]
+	nonce := sess[sessionKeyNonce]
+	verifier := sess[sessionKeyVerifier]
+	destination := sess[sessionKeyDestination]
+	delete(sess, sessionKeyState)
+	delete(sess, sessionKeyNonce)
+	delete(sess, sessionKeyVerifier)
+	delete(sess, sessionKeyDestination)
+
+	query := r.URL.Query()
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		logmw.Info(r, oidcComponent, logmw.CategoryValidationFailure).Log("state", "mismatch")
+		ab.Fail(http.StatusBadRequest, ErrInvalidState)
+	}
+
+	if e := query.Get("error"); e != "" {
+		logmw.Info(r, oidcComponent, nil).Log("provider error", e, "description", query.Get("error_description"))
+		ab.Fail(http.StatusUnauthorized, ErrAccessDenied)
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		ab.Fail(http.StatusBadRequest, ErrInvalidState)
+	}
+
+	p := s.provider(r, c)
+
+	tr, err := p.exchange(r.Context(), s.Client, c, code, verifier, redirectURL(r, c))
+	if err != nil {
+		logmw.Error(r, oidcComponent, nil).Log("token exchange", err)
+		ab.Fail(http.StatusBadGateway, ErrProvider)
+	}
+
+	claims, err := s.verify(r, p, c, tr.IDToken, nonce)
+	if err != nil {
+		logmw.Warn(r, oidcComponent, logmw.CategoryValidationFailure).Log("id token", err)
+		ab.Fail(http.StatusUnauthorized, ErrInvalidIDToken)
+	}
+
+	u := s.mapUser(r, c, claims)
+
+	errs := s.dispatcher.Dispatch(auth.NewUserEvent(auth.EventBeforeLogin, r, u))
+	ab.MaybeFail(http.StatusForbidden, errors.NewMultiError(errs))
+
+	if !c.SkipTwoFactor && auth.StartTwoFactorLogin(r, u) {
+		redirect(w, r, localDestination(c.TwoFactorPath)+"?destination="+url.QueryEscape(localDestination(destination)))
+		return
+	}
+
+	auth.Login(r, u)
+
+	errs = s.dispatcher.Dispatch(auth.NewUserEvent(auth.EventLogin, r, u))
+	ab.MaybeFail(http.StatusInternalServerError, errors.NewMultiError(errs))
+
+	redirect(w, r, localDestination(destination))
+}
+
+// provider returns the discovered provider of the site.
+func (s *Service) provider(r *http.Request, c Config) *provider {
+	p, err := s.providers.get(r.Context(), s.Client, c.Issuer)
+	if err != nil {
+		logmw.Error(r, oidcComponent, nil).Log("discovery", err, "issuer", c.Issuer)
+		ab.Fail(http.StatusBadGateway, ErrProvider)
+	}
+
+	return p
+}
+
+// verify checks the signature and the claims of an ID token.
+func (s *Service) verify(r *http.Request, p *provider, c Config, token, nonce string) (*Claims, error) {
+	header, claims, signed, signature, err := parseIDToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.key(r.Context(), s.Client, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verifySignature(header.Alg, key, signed, signature); err != nil {
+		return nil, err
+	}
+
+	if err = claims.validate(c.Issuer, c.ClientID, nonce, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// mapUser finds or creates the local user of an identity.
+func (s *Service) mapUser(r *http.Request, c Config, claims *Claims) *auth.User {
+	conn := ab.GetDB(r)
+
+	u, err := LoadIdentity(conn, claims.Issuer, claims.Subject)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+	if u != nil {
+		return u
+	}
+
+	if c.LinkByEmail && claims.Email != "" && claims.EmailVerified {
+		u, err = auth.LoadUserByEmail(conn, claims.Email)
+		ab.MaybeFail(http.StatusInternalServerError, err)
+	}
+
+	if u == nil {
+		if !c.AutoRegister {
+			logmw.Info(r, oidcComponent, nil).Log("unknown identity", claims.Subject, "issuer", claims.Issuer)
+			ab.Fail(http.StatusForbidden, ErrUnknownUser)
+		}
+
+		if claims.Email == "" {
+			ab.Fail(http.StatusForbidden, ErrMissingEmail)
+		}
+
+		pw, err := hash.DefaultHashPassword(util.RandomSecret(32))
+		ab.MaybeFail(http.StatusInternalServerError, err)
+
+		u = &auth.User{
+			Email:    claims.Email,
+			Password: pw,
+		}
+		err = auth.InsertUser(conn, u)
+		ab.MaybeFail(http.StatusConflict, db.ConvertDBError(err, emailConstraintConverter))
+
+		errs := s.dispatcher.Dispatch(auth.NewUserEvent(auth.EventRegister, r, u))
+		ab.MaybeFail(http.StatusInternalServerError, errors.NewMultiError(errs))
+	}
+
+	ab.MaybeFail(http.StatusInternalServerError, LinkIdentity(conn, claims.Issuer, claims.Subject, u.ID))
+	logmw.Info(r, oidcComponent, nil).Log("identity linked", claims.Subject, "issuer", claims.Issuer, "user", u.ID)
+
+	return u
+}
+
+func getConfig(r *http.Request) Config {
+	ci, err := configmw.GetConfig(r).Get(configKey)
+	if err != nil {
+		logmw.Info(r, oidcComponent, configmw.CategoryConfigNotFound).Log("error", err)
+	}
+
+	c, _ := ci.(Config)
+	if !c.configured() {
+		ab.Fail(http.StatusNotFound, ErrNotConfigured)
+	}
+
+	return c
+}
+
+func redirectURL(r *http.Request, c Config) string {
+	if c.RedirectURL != "" {
+		return c.RedirectURL
+	}
+
+	u, err := server.URLFor(r, RouteCallback)
+	ab.MaybeFail(http.StatusInternalServerError, err)
+
+	return u
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, target string) {
+	w.Header().Set("Location", target)
+	ab.Render(r).SetCode(http.StatusFound)
+}
+
+// localDestination makes sure that the user is redirected to a local path after the login.
+func localDestination(destination string) string {
+	if !strings.HasPrefix(destination, "/") || strings.HasPrefix(destination, "//") || strings.HasPrefix(destination, "/\\") {
+		return "/"
+	}
+
+	return destination
+}
+
+func randomToken() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/config"
+	"github.com/alien-bunny/ab/lib/event"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/services/auth"
+	"github.com/alien-bunny/ab/services/oidc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOidc(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OIDC Suite")
+}
+
+const clientID = "ab-test"
+const clientSecret = "ab-test-secret"
+
+var provider = newTestProvider(clientID, clientSecret)
+
+var base, clientFactory = abtest.HopMock(func(conf *config.Store, s *server.Server, dispatcher *event.Dispatcher, base, schema string) (abtest.DataMockerFunc, error) {
+	s.RegisterService(auth.NewService(dispatcher))
+	s.RegisterService(oidc.NewService(dispatcher))
+
+	_, saver, err := conf.GetWritable(strings.TrimPrefix(base, "http://")).GetWritable("oidc")
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, saver.Save(oidc.Config{
+		Issuer:       provider.URL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AutoRegister: true,
+		LinkByEmail:  true,
+	})
+})
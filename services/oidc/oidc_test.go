@@ -0,0 +1,156 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/totp"
+	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/services/auth"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+)
+
+// startLogin starts the login and returns the callback URL that the provider redirects to.
+func startLogin(client *abtest.TestClient, id identity) string {
+	var location string
+	client.Request("GET", "/api/oidc/login?destination=/welcome", nil, nil, func(resp *http.Response) {
+		location = resp.Header.Get("Location")
+	}, http.StatusFound)
+	Expect(location).To(HavePrefix(provider.URL + "/authorize?"))
+
+	return provider.authorize(location, id)
+}
+
+func login(client *abtest.TestClient, id identity) *auth.User {
+	callback := startLogin(client, id)
+	client.Request("GET", callback, nil, nil, func(resp *http.Response) {
+		Expect(resp.Header.Get("Location")).To(Equal("/welcome"))
+	}, http.StatusFound)
+
+	u := &auth.User{}
+	client.Request("GET", "/api/auth/user", nil, nil, func(resp *http.Response) {
+		client.AssertJSON(resp, u, PointTo(MatchFields(IgnoreExtras, Fields{
+			"Email": Equal(id.Email),
+		})))
+	}, http.StatusOK)
+
+	return u
+}
+
+func newIdentity() identity {
+	return identity{
+		Subject:       util.RandomString(16),
+		Email:         util.RandomString(8) + "@example.com",
+		EmailVerified: true,
+	}
+}
+
+var _ = Describe("OIDC", func() {
+	It("should register and log in a new user", func() {
+		id := newIdentity()
+		u := login(clientFactory(), id)
+
+		By("logging in again")
+		Expect(login(clientFactory(), id).ID).To(Equal(u.ID))
+	})
+
+	It("should link an existing account by a verified email", func() {
+		client := clientFactory()
+		id := newIdentity()
+		registered := &auth.User{}
+		client.Request("POST", "/api/auth/register", client.JSONBuffer(&auth.Credentials{
+			Email:    id.Email,
+			Password: util.RandomString(16),
+		}), nil, func(resp *http.Response) {
+			client.AssertJSON(resp, registered, Not(BeNil()))
+		}, http.StatusCreated)
+
+		Expect(login(clientFactory(), id).ID).To(Equal(registered.ID))
+
+		By("rejecting an unverified email of an existing account")
+		unverified := newIdentity()
+		unverified.Email = id.Email
+		unverified.EmailVerified = false
+		client = clientFactory()
+		client.Request("GET", startLogin(client, unverified), nil, nil, nil, http.StatusConflict)
+	})
+
+	It("should reject invalid callbacks", func() {
+		client := clientFactory()
+
+		By("checking the state")
+		client.Request("GET", "/api/oidc/callback?code=abc&state=def", nil, nil, nil, http.StatusBadRequest)
+
+		By("checking the signature")
+		forged := newIdentity()
+		forged.Forge = true
+		client.Request("GET", startLogin(client, forged), nil, nil, nil, http.StatusUnauthorized)
+
+		By("checking the nonce")
+		replayed := newIdentity()
+		replayed.Nonce = util.RandomString(16)
+		client.Request("GET", startLogin(client, replayed), nil, nil, nil, http.StatusUnauthorized)
+
+		By("using the state only once")
+		callback := startLogin(client, newIdentity())
+		client.Request("GET", callback, nil, nil, nil, http.StatusFound)
+		client.Request("GET", callback, nil, nil, nil, http.StatusBadRequest)
+	})
+
+	It("should verify the second factor of the users who enabled it", func() {
+		client := clientFactory()
+		id := newIdentity()
+		login(client, id)
+
+		enrollment := &auth.TwoFactorEnrollment{}
+		client.Request("POST", "/api/auth/totp/enroll", nil, nil, func(resp *http.Response) {
+			client.AssertJSON(resp, enrollment, Not(BeNil()))
+		}, http.StatusOK)
+		secret, err := totp.DecodeSecret(enrollment.Secret)
+		Expect(err).NotTo(HaveOccurred())
+		client.Request("POST", "/api/auth/totp/confirm", client.JSONBuffer(&auth.TwoFactorCode{
+			Code: totp.Code(secret, totp.Counter(time.Now(), totp.Options{}), totp.Options{}),
+		}), nil, nil, http.StatusOK)
+
+		By("logging in again")
+		client = clientFactory()
+		client.Request("GET", startLogin(client, id), nil, nil, func(resp *http.Response) {
+			Expect(resp.Header.Get("Location")).To(Equal("/?destination=%2Fwelcome"))
+		}, http.StatusFound)
+		client.Request("GET", "/api/auth/user", nil, nil, nil, http.StatusUnauthorized)
+
+		client.Request("POST", "/api/auth/login/totp", client.JSONBuffer(&auth.TwoFactorCode{
+			Code: totp.Code(secret, totp.Counter(time.Now(), totp.Options{})+1, totp.Options{}),
+		}), nil, nil, http.StatusOK)
+		client.Request("GET", "/api/auth/user", nil, nil, nil, http.StatusOK)
+	})
+
+	It("should only redirect to local paths", func() {
+		client := clientFactory()
+		var location string
+		client.Request("GET", "/api/oidc/login?destination=//evil.example.com", nil, nil, func(resp *http.Response) {
+			location = resp.Header.Get("Location")
+		}, http.StatusFound)
+
+		client.Request("GET", provider.authorize(location, newIdentity()), nil, nil, func(resp *http.Response) {
+			Expect(resp.Header.Get("Location")).To(Equal("/"))
+		}, http.StatusFound)
+	})
+})
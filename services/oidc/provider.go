@@ -0,0 +1,223 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/errors"
+)
+
+const (
+	// providerTTL is how long the discovery document and the keys of a provider are cached.
+	providerTTL = time.Hour
+	// keyRefreshInterval limits how often the keys are refetched when a token is signed with an unknown key.
+	keyRefreshInterval = time.Minute
+	// maxResponseSize limits the size of the responses of the providers.
+	maxResponseSize = 1 << 20
+)
+
+// providerMetadata is the relevant part of the OpenID Provider discovery document.
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// provider is a discovered OpenID Provider with its signing keys.
+type provider struct {
+	metadata providerMetadata
+	fetched  time.Time
+
+	mtx         sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// providerCache caches the discovered providers by issuer.
+type providerCache struct {
+	mtx       sync.Mutex
+	providers map[string]*provider
+}
+
+func newProviderCache() *providerCache {
+	return &providerCache{
+		providers: make(map[string]*provider),
+	}
+}
+
+func (c *providerCache) get(ctx context.Context, client *http.Client, issuer string) (*provider, error) {
+	c.mtx.Lock()
+	p, ok := c.providers[issuer]
+	c.mtx.Unlock()
+
+	if ok && time.Since(p.fetched) < providerTTL {
+		return p, nil
+	}
+
+	p, err := discover(ctx, client, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	c.providers[issuer] = p
+	c.mtx.Unlock()
+
+	return p, nil
+}
+
+func discover(ctx context.Context, client *http.Client, issuer string) (*provider, error) {
+	p := &provider{
+		fetched: time.Now(),
+	}
+
+	if err := getJSON(ctx, client, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &p.metadata); err != nil {
+		return nil, err
+	}
+
+	if p.metadata.Issuer != issuer {
+		return nil, errors.New("issuer mismatch in the discovery document: " + p.metadata.Issuer)
+	}
+
+	if p.metadata.AuthorizationEndpoint == "" || p.metadata.TokenEndpoint == "" || p.metadata.JWKSURI == "" {
+		return nil, errors.New("incomplete discovery document")
+	}
+
+	return p, nil
+}
+
+// key returns the signing key with the given ID.
+//
+// The keys are refetched when the key is not found, because the provider might have rotated them.
+func (p *provider) key(ctx context.Context, client *http.Client, kid string) (crypto.PublicKey, error) {
+	p.mtx.RLock()
+	key, found := p.keys[kid]
+	fetched := p.keysFetched
+	p.mtx.RUnlock()
+
+	if found {
+		return key, nil
+	}
+
+	if time.Since(fetched) < keyRefreshInterval {
+		return nil, errors.New("unknown signing key: " + kid)
+	}
+
+	set := &jwkSet{}
+	if err := getJSON(ctx, client, p.metadata.JWKSURI, set); err != nil {
+		return nil, err
+	}
+
+	keys := set.publicKeys()
+
+	p.mtx.Lock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	p.mtx.Unlock()
+
+	if key, found = keys[kid]; !found {
+		return nil, errors.New("unknown signing key: " + kid)
+	}
+
+	return key, nil
+}
+
+// tokenResponse is the response of the token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchange redeems an authorization code at the token endpoint.
+func (p *provider) exchange(ctx context.Context, client *http.Client, c Config, code, verifier, redirectURL string) (*tokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", c.ClientID)
+
+	req, err := http.NewRequest(http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	tr := &tokenResponse{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(tr); err != nil {
+		return nil, err
+	}
+
+	if tr.Error != "" {
+		return nil, errors.New("token endpoint: " + tr.Error + " " + tr.ErrorDescription)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("token endpoint: unexpected status " + resp.Status)
+	}
+
+	if tr.IDToken == "" {
+		return nil, errors.New("token endpoint: missing id token")
+	}
+
+	return tr, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, target string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+		return errors.New(target + ": unexpected status " + resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
@@ -0,0 +1,210 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/alien-bunny/ab/lib/util"
+)
+
+// identity is a user of the testProvider.
+type identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+
+	// Nonce overrides the nonce of the ID token.
+	Nonce string
+	// Forge signs the ID token with a key that is not published by the provider.
+	Forge bool
+}
+
+type grant struct {
+	identity
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+// testProvider is a stand-in OpenID Provider.
+type testProvider struct {
+	*httptest.Server
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	forgedKey    *rsa.PrivateKey
+
+	mtx   sync.Mutex
+	codes map[string]grant
+}
+
+func newTestProvider(clientID, clientSecret string) *testProvider {
+	p := &testProvider{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		key:          mustGenerateKey(),
+		forgedKey:    mustGenerateKey(),
+		codes:        make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discoveryHandler)
+	mux.HandleFunc("/jwks", p.jwksHandler)
+	mux.HandleFunc("/token", p.tokenHandler)
+	p.Server = httptest.NewServer(mux)
+
+	return p
+}
+
+func mustGenerateKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+// authorize plays the authorization endpoint: it approves the request for the identity and returns the callback URL.
+func (p *testProvider) authorize(authorizationURL string, id identity) string {
+	u, err := url.Parse(authorizationURL)
+	if err != nil {
+		panic(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != p.clientID || q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+		panic("invalid authorization request: " + authorizationURL)
+	}
+
+	code := util.RandomString(16)
+	p.mtx.Lock()
+	p.codes[code] = grant{
+		identity:    id,
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	p.mtx.Unlock()
+
+	callback, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		panic(err)
+	}
+	cq := url.Values{}
+	cq.Set("code", code)
+	cq.Set("state", q.Get("state"))
+
+	return callback.Path + "?" + cq.Encode()
+}
+
+func (p *testProvider) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/jwks",
+	})
+}
+
+func (p *testProvider) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+			},
+		},
+	})
+}
+
+func (p *testProvider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != p.clientID || secret != p.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostFormValue("code")
+	p.mtx.Lock()
+	g, found := p.codes[code]
+	delete(p.codes, code)
+	p.mtx.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !found || g.redirectURI != r.PostFormValue("redirect_uri") || g.challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := g.nonce
+	if g.Nonce != "" {
+		nonce = g.Nonce
+	}
+	key := p.key
+	if g.Forge {
+		key = p.forgedKey
+	}
+
+	now := time.Now()
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": util.RandomString(32),
+		"token_type":   "Bearer",
+		"id_token": signToken(key, map[string]interface{}{
+			"iss":            p.URL,
+			"sub":            g.Subject,
+			"aud":            p.clientID,
+			"exp":            now.Add(time.Hour).Unix(),
+			"iat":            now.Unix(),
+			"nonce":          nonce,
+			"email":          g.Email,
+			"email_verified": g.EmailVerified,
+		}),
+	})
+}
+
+func signToken(key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/alien-bunny/ab"
 	"github.com/alien-bunny/ab/lib"
@@ -34,18 +35,29 @@ import (
 
 var ErrNoEndpoints = errors.New("no endpoints are enabled for this resource")
 
+// Resource operations.
+const (
+	OperationList   = "list"
+	OperationPost   = "post"
+	OperationGet    = "get"
+	OperationPut    = "put"
+	OperationDelete = "delete"
+)
+
 // Resource labels data for CRUD operation through API endpoints.
 type Resource interface {
 }
 
 // ResourceList is an extended list of resources.
 type ResourceList struct {
-	Items    []Resource               `json:"items"`
-	Page     int                      `json:"-"`
-	PageSize int                      `json:"-"`
-	BasePath string                   `json:"-"`
-	Curies   []hal.HALCurie           `json:"-"`
-	Rels     map[string][]interface{} `json:"-"`
+	Items    []Resource `json:"items"`
+	Page     int        `json:"-"`
+	PageSize int        `json:"-"`
+	BasePath string     `json:"-"`
+	// PageURL generates the URL of a page. If it is nil, the page links are generated from BasePath.
+	PageURL func(page int) string    `json:"-"`
+	Curies  []hal.HALCurie           `json:"-"`
+	Rels    map[string][]interface{} `json:"-"`
 }
 
 func (rl *ResourceList) Sanitize() {
@@ -78,16 +90,32 @@ func (rl *ResourceList) MarshalJSON() ([]byte, error) {
 }
 
 func (rl *ResourceList) links() map[string][]interface{} {
+	links := make(map[string][]interface{}, len(rl.Rels)+2)
+	for rel, l := range rl.Rels {
+		links[rel] = append([]interface{}{}, l...)
+	}
+
 	if rl.Page > 1 {
-		rl.Rels["page previous"] = append(rl.Rels["page previous"], fmt.Sprintf("%s?page=%d", rl.BasePath, rl.Page-1))
+		links["page previous"] = append(links["page previous"], rl.pageURL(rl.Page-1))
 	}
 	if len(rl.Items) == rl.PageSize {
-		rl.Rels["page next"] = append(rl.Rels["page next"], fmt.Sprintf("%s?page=%d", rl.BasePath, rl.Page+1))
+		links["page next"] = append(links["page next"], rl.pageURL(rl.Page+1))
+	}
+
+	return links
+}
+
+func (rl *ResourceList) pageURL(page int) string {
+	if rl.PageURL != nil {
+		return rl.PageURL(page)
 	}
 
-	return rl.Rels
+	return fmt.Sprintf("%s?page=%d", rl.BasePath, page)
 }
 
+// Authorizer checks if the current user can perform an operation. A non-nil error rejects the request.
+type Authorizer func(r *http.Request) error
+
 // ResourceListDelegate helps a ResourceController to list resources.
 type ResourceListDelegate interface {
 	List(r *http.Request, start, limit int) ([]Resource, error)
@@ -161,6 +189,10 @@ type ResourceController struct {
 	deleteDelegate    ResourceDeleteDelegate
 	deleteMiddlewares []middleware.Middleware
 
+	mount server.Registrar
+
+	authorizers map[string]Authorizer
+
 	ExtraEndpoints func(s *server.Server) error
 }
 
@@ -179,6 +211,20 @@ func NewResourceController(dispatcher *event.Dispatcher, delegate ResourceContro
 	}
 }
 
+// RouteName returns the name of the route of an operation, e.g. "article.list".
+//
+// If the controller is mounted on a group, the name is prefixed with the prefix of the group, e.g.
+// "/api/v2:article.list", so the same delegate can be mounted on multiple groups. The route names can be used to
+// generate URLs with server.URLFor().
+func (res *ResourceController) RouteName(operation string) string {
+	name := res.delegate.Name() + "." + operation
+	if res.mount != nil && res.mount.Prefix() != "" {
+		name = res.mount.Prefix() + ":" + name
+	}
+
+	return name
+}
+
 // ServiceName returns the name of this ResourceController.
 func (res *ResourceController) Name() string {
 	return res.delegate.Name()
@@ -224,11 +270,45 @@ func (res *ResourceController) Delete(d ResourceDeleteDelegate, middlewares ...m
 	return res
 }
 
+// Authorize sets the Authorizer of an operation.
+//
+// The authorizer runs before any of the operation's events fire, and the request fails with 403 if it returns an
+// error. See auth.PermissionAuthorizer() for a permission based authorizer:
+//
+//		res.Authorize(resource.OperationPut, auth.PermissionAuthorizer("edit articles"))
+func (res *ResourceController) Authorize(operation string, authorizer Authorizer) *ResourceController {
+	if res.authorizers == nil {
+		res.authorizers = make(map[string]Authorizer)
+	}
+	res.authorizers[operation] = authorizer
+
+	return res
+}
+
+func (res *ResourceController) authorize(r *http.Request, operation string) {
+	if authorizer, ok := res.authorizers[operation]; ok {
+		ab.MaybeFail(http.StatusForbidden, authorizer(r))
+	}
+}
+
+// Mount sets the router where the endpoints will be registered.
+//
+// By default the endpoints are registered on the server under "/api/". When a router (e.g. a server.Group) is set,
+// the endpoints are registered relative to it, so a resource named "article" mounted on a group with the "/api/v2"
+// prefix will be available under "/api/v2/article". The paths passed to ResourcePathOverrider are relative to the router as well.
+func (res *ResourceController) Mount(r server.Registrar) *ResourceController {
+	res.mount = r
+
+	return res
+}
+
 func (res *ResourceController) convertError(err error) error {
 	return db.ConvertDBError(err, res.errorConverter)
 }
 
 func (res *ResourceController) listHandler(w http.ResponseWriter, r *http.Request) {
+	res.authorize(r, OperationList)
+
 	limit := res.listDelegate.PageLength()
 	start := ab.Pager(r, limit)
 
@@ -237,11 +317,19 @@ func (res *ResourceController) listHandler(w http.ResponseWriter, r *http.Reques
 
 	list, err := res.listDelegate.List(r, start, limit)
 	ab.MaybeFail(http.StatusInternalServerError, res.convertError(err))
+	basePath, err := server.URLFor(r, res.RouteName(OperationList))
+	ab.MaybeFail(http.StatusInternalServerError, err)
+
 	reslist := &ResourceList{
 		Items:    list,
 		PageSize: limit,
-		Page:     start / limit,
-		BasePath: "/api/" + res.delegate.Name(),
+		// The pages are numbered from 1, see ab.Pager().
+		Page:     start/limit + 1,
+		BasePath: basePath,
+		PageURL: func(page int) string {
+			u, _ := server.URLFor(r, res.RouteName(OperationList), "page", strconv.Itoa(page))
+			return u
+		},
 	}
 
 	errs = res.dispatcher.Dispatch(NewAfterResourceListEvent(r, reslist))
@@ -251,6 +339,8 @@ func (res *ResourceController) listHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (res *ResourceController) postHandler(w http.ResponseWriter, r *http.Request) {
+	res.authorize(r, OperationPost)
+
 	d := res.postDelegate.Empty()
 	ab.MustDecode(r, d)
 
@@ -277,6 +367,8 @@ func (res *ResourceController) postHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (res *ResourceController) getHandler(w http.ResponseWriter, r *http.Request) {
+	res.authorize(r, OperationGet)
+
 	id := server.GetParams(r).ByName("id")
 
 	errs := res.dispatcher.Dispatch(NewResourceCRUDEvent(EventBeforeResourceGet, r, nil))
@@ -295,6 +387,8 @@ func (res *ResourceController) getHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (res *ResourceController) putHandler(w http.ResponseWriter, r *http.Request) {
+	res.authorize(r, OperationPut)
+
 	id := server.GetParams(r).ByName("id")
 
 	d := res.putDelegate.Empty()
@@ -327,6 +421,8 @@ func (res *ResourceController) putHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (res *ResourceController) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	res.authorize(r, OperationDelete)
+
 	id := server.GetParams(r).ByName("id")
 
 	errs := res.dispatcher.Dispatch(NewResourceCRUDEvent(EventBeforeResourceDelete, r, nil))
@@ -353,7 +449,12 @@ func (res *ResourceController) Register(srv *server.Server) error {
 		return ErrNoEndpoints
 	}
 
+	var router server.Registrar = srv
 	base := "/api/" + res.delegate.Name()
+	if res.mount != nil {
+		router = res.mount
+		base = "/" + res.delegate.Name()
+	}
 	id := base + "/:id"
 
 	if res.listDelegate != nil {
@@ -361,7 +462,7 @@ func (res *ResourceController) Register(srv *server.Server) error {
 		if po, ok := res.listDelegate.(ResourcePathOverrider); ok {
 			path = po.OverridePath(path)
 		}
-		srv.Get(path, ab.WrapHandlerFunc(res.listHandler), res.listMiddlewares...)
+		router.Get(path, ab.WrapHandlerFunc(res.listHandler), res.listMiddlewares...).SetName(res.RouteName(OperationList))
 	}
 
 	if res.postDelegate != nil {
@@ -369,7 +470,7 @@ func (res *ResourceController) Register(srv *server.Server) error {
 		if po, ok := res.postDelegate.(ResourcePathOverrider); ok {
 			path = po.OverridePath(path)
 		}
-		srv.Post(path, ab.WrapHandlerFunc(res.postHandler), res.postMiddlewares...)
+		router.Post(path, ab.WrapHandlerFunc(res.postHandler), res.postMiddlewares...).SetName(res.RouteName(OperationPost))
 	}
 
 	if res.getDelegate != nil {
@@ -377,7 +478,7 @@ func (res *ResourceController) Register(srv *server.Server) error {
 		if po, ok := res.getDelegate.(ResourcePathOverrider); ok {
 			path = po.OverridePath(path)
 		}
-		srv.Get(path, ab.WrapHandlerFunc(res.getHandler), res.getMiddlewares...)
+		router.Get(path, ab.WrapHandlerFunc(res.getHandler), res.getMiddlewares...).SetName(res.RouteName(OperationGet))
 	}
 
 	if res.putDelegate != nil {
@@ -385,7 +486,7 @@ func (res *ResourceController) Register(srv *server.Server) error {
 		if po, ok := res.putDelegate.(ResourcePathOverrider); ok {
 			path = po.OverridePath(path)
 		}
-		srv.Put(path, ab.WrapHandlerFunc(res.putHandler), res.putMiddlewares...)
+		router.Put(path, ab.WrapHandlerFunc(res.putHandler), res.putMiddlewares...).SetName(res.RouteName(OperationPut))
 	}
 
 	if res.deleteDelegate != nil {
@@ -393,7 +494,7 @@ func (res *ResourceController) Register(srv *server.Server) error {
 		if po, ok := res.deleteDelegate.(ResourcePathOverrider); ok {
 			path = po.OverridePath(path)
 		}
-		srv.Delete(path, ab.WrapHandlerFunc(res.deleteHandler), res.deleteMiddlewares...)
+		router.Delete(path, ab.WrapHandlerFunc(res.deleteHandler), res.deleteMiddlewares...).SetName(res.RouteName(OperationDelete))
 	}
 
 	if res.ExtraEndpoints != nil {
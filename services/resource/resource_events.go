@@ -96,6 +96,11 @@ func (e *ResourceCRUDEvent) Resource() Resource {
 	return e.resource
 }
 
+// Payload returns the resource. It is used when the event is forwarded to clients, e.g. with an sse.Broker.
+func (e *ResourceCRUDEvent) Payload() interface{} {
+	return e.resource
+}
+
 func NewResourceCRUDEvent(eventName string, r *http.Request, resource Resource) *ResourceCRUDEvent {
 	return &ResourceCRUDEvent{
 		resourceEventBase: resourceEventBase{r},
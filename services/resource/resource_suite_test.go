@@ -24,6 +24,7 @@ import (
 	"github.com/alien-bunny/ab/lib/abtest"
 	"github.com/alien-bunny/ab/lib/config"
 	"github.com/alien-bunny/ab/lib/db"
+	"github.com/alien-bunny/ab/lib/errors"
 	"github.com/alien-bunny/ab/lib/event"
 	"github.com/alien-bunny/ab/lib/server"
 	"github.com/alien-bunny/ab/lib/uuid"
@@ -37,6 +38,11 @@ func TestResource(t *testing.T) {
 	RunSpecs(t, "Resource Suite")
 }
 
+// mountedList is a list-only controller that shares its delegate with a controller mounted on another group.
+//
+// The list is forbidden for the requests with the X-Test-Forbidden header.
+var mountedList *resource.ResourceController
+
 var _, clientFactory = abtest.HopMock(func(conf *config.Store, s *server.Server, dispatcher *event.Dispatcher, base, schema string) (abtest.DataMockerFunc, error) {
 	d := &testResourceControllerDelegate{}
 
@@ -59,6 +65,30 @@ var _, clientFactory = abtest.HopMock(func(conf *config.Store, s *server.Server,
 
 	s.RegisterService(rc)
 
+	md := &mountedResourceControllerDelegate{}
+	mounted := resource.NewResourceController(dispatcher, md).
+		List(md).
+		Post(md).
+		Get(md).
+		Put(md).
+		Delete(md).
+		Mount(s.Group("/api/v2"))
+
+	s.RegisterService(mounted)
+
+	mountedList = resource.NewResourceController(dispatcher, md).
+		List(md).
+		Authorize(resource.OperationList, func(r *http.Request) error {
+			if r.Header.Get("X-Test-Forbidden") != "" {
+				return errors.New("forbidden")
+			}
+
+			return nil
+		}).
+		Mount(s.Group("/api/v3"))
+
+	s.RegisterService(mountedList)
+
 	return nil, nil
 })
 
@@ -100,6 +130,20 @@ func (t *testResourceControllerDelegate) DBSchema() db.SchemaGenerations {
 	)
 }
 
+// mountedResourceControllerDelegate serves the test resources under a different name. The table is created by
+// testResourceControllerDelegate.
+type mountedResourceControllerDelegate struct {
+	testResourceControllerDelegate
+}
+
+func (t *mountedResourceControllerDelegate) Name() string {
+	return "mounted"
+}
+
+func (t *mountedResourceControllerDelegate) DBSchema() db.SchemaGenerations {
+	return db.DefineSchemaGenerations()
+}
+
 func (t *testResourceControllerDelegate) List(r *http.Request, start, limit int) ([]resource.Resource, error) {
 	conn := ab.GetDB(r)
 	rows, rerr := conn.Query("SELECT uuid, a, b, updated FROM testresource ORDER BY updated DESC LIMIT $2 OFFSET $1", start, limit)
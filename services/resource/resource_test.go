@@ -72,6 +72,105 @@ var _ = Describe("Resource", func() {
 	})
 })
 
+var _ = Describe("Mounted resource", func() {
+	It("should register the endpoints under the prefix of the group", func() {
+		client := clientFactory()
+
+		By("creating a resource")
+		res := &testResource{
+			A: "mounted",
+			B: 6,
+		}
+		client.Request("POST", "/api/v2/mounted", client.JSONBuffer(res), nil, func(resp *http.Response) {
+			res = loadResource(client, resp, res)
+		}, http.StatusCreated)
+
+		By("listing the resources")
+		client.Request("GET", "/api/v2/mounted", nil, nil, func(resp *http.Response) {
+			Expect(client.ReadBody(resp, true)).To(ContainSubstring(res.UUID.String()))
+		}, http.StatusOK)
+
+		By("retrieving a resource")
+		client.Request("GET", "/api/v2/mounted/"+res.UUID.String(), nil, nil, func(resp *http.Response) {
+			loadResource(client, resp, res)
+		}, http.StatusOK)
+
+		By("updating a resource")
+		res.A += "qwerty"
+		client.Request("PUT", "/api/v2/mounted/"+res.UUID.String(), client.JSONBuffer(res), nil, func(resp *http.Response) {
+			loadResource(client, resp, res)
+		}, http.StatusOK)
+
+		By("checking that the endpoints are not registered under /api/")
+		client.Request("GET", "/api/mounted/"+res.UUID.String(), nil, nil, nil, http.StatusNotFound)
+
+		By("deleting a resource")
+		client.Request("DELETE", "/api/v2/mounted/"+res.UUID.String(), nil, nil, nil, http.StatusNoContent)
+		client.Request("GET", "/api/v2/mounted/"+res.UUID.String(), nil, nil, nil, http.StatusNotFound)
+	})
+})
+
+var _ = Describe("Resource list links", func() {
+	It("should link the pages of the list", func() {
+		client := clientFactory()
+		halJSON := func(r *http.Request) {
+			r.Header.Set("Accept", "application/hal+json")
+		}
+		type page struct {
+			Items []json.RawMessage              `json:"items"`
+			Links map[string][]map[string]string `json:"_links"`
+		}
+		readPage := func(resp *http.Response) page {
+			p := page{}
+			Expect(json.Unmarshal([]byte(client.ReadBody(resp, true)), &p)).To(Succeed())
+			return p
+		}
+
+		Expect(mountedList.RouteName(resource.OperationList)).To(Equal("/api/v3:mounted.list"))
+
+		By("creating more resources than the page length")
+		var created []*testResource
+		for i := 0; i < 7; i++ {
+			res := &testResource{
+				A: "page",
+				B: i,
+			}
+			client.Request("POST", "/api/v2/mounted", client.JSONBuffer(res), nil, func(resp *http.Response) {
+				created = append(created, loadResource(client, resp, res))
+			}, http.StatusCreated)
+		}
+
+		By("listing the first page")
+		client.Request("GET", "/api/v3/mounted", nil, halJSON, func(resp *http.Response) {
+			p := readPage(resp)
+			Expect(p.Items).To(HaveLen(6))
+			Expect(p.Links).NotTo(HaveKey("page previous"))
+			Expect(p.Links["page next"]).To(HaveLen(1))
+			Expect(p.Links["page next"][0]["href"]).To(HavePrefix("http"))
+			Expect(p.Links["page next"][0]["href"]).To(HaveSuffix("/api/v3/mounted?page=2"))
+		}, http.StatusOK)
+
+		By("listing the second page")
+		client.Request("GET", "/api/v3/mounted?page=2", nil, halJSON, func(resp *http.Response) {
+			p := readPage(resp)
+			Expect(p.Items).To(HaveLen(1))
+			Expect(p.Links).NotTo(HaveKey("page next"))
+			Expect(p.Links["page previous"]).To(HaveLen(1))
+			Expect(p.Links["page previous"][0]["href"]).To(HaveSuffix("/api/v3/mounted?page=1"))
+		}, http.StatusOK)
+
+		By("checking the authorizer")
+		client.Request("GET", "/api/v3/mounted", nil, func(r *http.Request) {
+			r.Header.Set("X-Test-Forbidden", "1")
+		}, nil, http.StatusForbidden)
+
+		By("deleting the resources")
+		for _, res := range created {
+			client.Request("DELETE", "/api/v2/mounted/"+res.UUID.String(), nil, nil, nil, http.StatusNoContent)
+		}
+	})
+})
+
 func loadResource(client *abtest.TestClient, resp *http.Response, res *testResource) *testResource {
 	loadedRes := &testResource{}
 	client.AssertJSON(resp, loadedRes, PointTo(MatchAllFields(Fields{
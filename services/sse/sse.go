@@ -0,0 +1,303 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sse fans out dispatcher events to browsers with Server-Sent Events.
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alien-bunny/ab"
+	"github.com/alien-bunny/ab/lib"
+	"github.com/alien-bunny/ab/lib/errors"
+	"github.com/alien-bunny/ab/lib/event"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/render"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/middlewares/configmw"
+	"github.com/alien-bunny/ab/middlewares/timeoutmw"
+)
+
+// ErrStopped is returned when a client connects after the broker has been stopped.
+var ErrStopped = errors.New("event stream is stopped")
+
+// DefaultBufferSize is the default number of events kept for resuming streams.
+const DefaultBufferSize = 256
+
+// clientBufferSize is the number of events that can wait for a slow client.
+//
+// When the buffer is full, the stream is closed, and the client resumes it after reconnecting.
+const clientBufferSize = 32
+
+// Payloader can be implemented by events to provide the data sent to the clients.
+//
+// The payload is encoded as JSON. Events without a payload are sent with null data.
+type Payloader interface {
+	Payload() interface{}
+}
+
+// requestEvent is an event that is dispatched while handling a request.
+type requestEvent interface {
+	Request() *http.Request
+}
+
+var _ server.Service = &Broker{}
+var _ server.Starter = &Broker{}
+var _ server.Stopper = &Broker{}
+var _ event.Subscriber = &Broker{}
+
+// Broker streams the events of a dispatcher to the connected clients.
+//
+// An event is only sent to the clients of the same site: the config namespace of the request that triggered the event
+// must match the config namespace of the stream. Events that are not dispatched during a request are dropped; they can
+// be sent to every client with Publish().
+//
+// The stream endpoint has no access control by default. Use Middlewares() to restrict who can connect, and Filter to
+// restrict which events a client receives.
+//
+// The Broker keeps the last BufferSize events, so clients can resume the stream with the Last-Event-ID header.
+//
+// Example:
+//
+//		s.RegisterService(sse.NewBroker(dispatcher, "/api/events",
+//			resource.EventAfterResourcePost,
+//			resource.EventAfterResourcePut,
+//			resource.EventAfterResourceDelete,
+//		).Middlewares(authMiddleware))
+type Broker struct {
+	path        string
+	srv         *server.Server
+	middlewares []middleware.Middleware
+
+	// BufferSize is the number of events kept for resuming streams.
+	BufferSize int
+	// Retry is the reconnection time sent to the clients. The browser default is used if it is 0.
+	Retry time.Duration
+	// Filter decides if an event can be sent to a client. The request is the one that opened the stream.
+	// Every event is sent if it is nil.
+	Filter func(r *http.Request, name string) bool
+
+	mtx     sync.Mutex
+	clients map[*client]struct{}
+	history []message
+	lastID  uint64
+	stopped bool
+}
+
+type message struct {
+	namespace string
+	id        uint64
+	event     render.Event
+}
+
+type client struct {
+	namespace string
+	r         *http.Request
+	events    chan render.Event
+}
+
+// NewBroker creates a Broker that subscribes to the given events of the dispatcher, and serves the stream on path.
+func NewBroker(dispatcher *event.Dispatcher, path string, events ...string) *Broker {
+	b := &Broker{
+		path:       path,
+		BufferSize: DefaultBufferSize,
+		clients:    make(map[*client]struct{}),
+	}
+
+	for _, name := range events {
+		dispatcher.Subscribe(name, b)
+	}
+
+	return b
+}
+
+// Middlewares sets the middlewares of the stream endpoint, e.g. to require an authenticated user.
+func (b *Broker) Middlewares(middlewares ...middleware.Middleware) *Broker {
+	b.middlewares = middlewares
+
+	return b
+}
+
+// Name returns the name of the service.
+func (b *Broker) Name() string {
+	return "sse"
+}
+
+// Register registers the stream endpoint. The streams are not limited by the request timeout.
+func (b *Broker) Register(srv *server.Server) error {
+	b.srv = srv
+	srv.GetF(b.path, b.streamHandler, append([]middleware.Middleware{timeoutmw.New(0)}, b.middlewares...)...)
+
+	return nil
+}
+
+// Start makes sure that the streams are closed when the server shuts down.
+//
+// Without this, the open streams would block the graceful shutdown until the timeout.
+func (b *Broker) Start(ctx context.Context) error {
+	if b.srv != nil && b.srv.HTTPServer != nil {
+		b.srv.HTTPServer.RegisterOnShutdown(b.closeAll)
+	}
+
+	return nil
+}
+
+// Stop closes all streams.
+func (b *Broker) Stop(ctx context.Context) error {
+	b.closeAll()
+
+	return nil
+}
+
+// Handle sends a dispatched event to the clients.
+//
+// Events without a config namespace are dropped.
+func (b *Broker) Handle(e event.Event) error {
+	namespace := ""
+	if re, ok := e.(requestEvent); ok && re.Request() != nil {
+		namespace = configmw.GetNamespace(re.Request())
+	}
+	if namespace == "" {
+		return nil
+	}
+
+	var data interface{}
+	if p, ok := e.(Payloader); ok {
+		data = p.Payload()
+	}
+
+	return b.Publish(namespace, e.Name(), data)
+}
+
+// Publish sends an event to the clients of a config namespace.
+//
+// If the namespace is empty, the event is sent to every client.
+func (b *Broker) Publish(namespace, name string, data interface{}) error {
+	if sanitizer, ok := data.(lib.Sanitizer); ok {
+		sanitizer.Sanitize()
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.lastID++
+	m := message{
+		namespace: namespace,
+		id:        b.lastID,
+		event: render.Event{
+			ID:    strconv.FormatUint(b.lastID, 10),
+			Event: name,
+			Data:  string(payload),
+		},
+	}
+
+	b.history = append(b.history, m)
+	if len(b.history) > b.BufferSize {
+		b.history = b.history[len(b.history)-b.BufferSize:]
+	}
+
+	for c := range b.clients {
+		if !b.visibleTo(m, c) {
+			continue
+		}
+
+		select {
+		case c.events <- m.event:
+		default:
+			b.removeClient(c)
+		}
+	}
+
+	return nil
+}
+
+func (b *Broker) streamHandler(w http.ResponseWriter, r *http.Request) {
+	b.mtx.Lock()
+
+	if b.stopped {
+		b.mtx.Unlock()
+		ab.Fail(http.StatusServiceUnavailable, ErrStopped)
+	}
+
+	c := &client{
+		namespace: configmw.GetNamespace(r),
+		r:         r,
+	}
+
+	var replay []render.Event
+	if b.Retry > 0 {
+		replay = append(replay, render.Event{Retry: b.Retry})
+	}
+	if lastID, err := strconv.ParseUint(render.LastEventID(r), 10, 64); err == nil {
+		for _, m := range b.history {
+			if m.id > lastID && b.visibleTo(m, c) {
+				replay = append(replay, m.event)
+			}
+		}
+	}
+
+	c.events = make(chan render.Event, len(replay)+clientBufferSize)
+	for _, e := range replay {
+		c.events <- e
+	}
+
+	b.clients[c] = struct{}{}
+	b.mtx.Unlock()
+
+	go func() {
+		<-r.Context().Done()
+		b.mtx.Lock()
+		b.removeClient(c)
+		b.mtx.Unlock()
+	}()
+
+	ab.Render(r).EventStream(c.events)
+}
+
+func (b *Broker) closeAll() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.stopped = true
+	for c := range b.clients {
+		b.removeClient(c)
+	}
+}
+
+// removeClient closes the stream of a client. The caller must hold the lock.
+func (b *Broker) removeClient(c *client) {
+	if _, ok := b.clients[c]; ok {
+		delete(b.clients, c)
+		close(c.events)
+	}
+}
+
+// visibleTo tells if a message can be sent to a client. The caller must hold the lock.
+func (b *Broker) visibleTo(m message, c *client) bool {
+	if m.namespace != "" && m.namespace != c.namespace {
+		return false
+	}
+
+	return b.Filter == nil || b.Filter(c.r, m.event.Event)
+}
@@ -0,0 +1,228 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sse_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/alien-bunny/ab/lib/abtest"
+	"github.com/alien-bunny/ab/lib/event"
+	"github.com/alien-bunny/ab/lib/middleware"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/middlewares/errormw"
+	"github.com/alien-bunny/ab/middlewares/logmw"
+	"github.com/alien-bunny/ab/middlewares/rendermw"
+	"github.com/alien-bunny/ab/middlewares/requestmw"
+	"github.com/alien-bunny/ab/middlewares/translationmw"
+	"github.com/alien-bunny/ab/services/sse"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/text/language"
+)
+
+const testEventName = "test-event"
+
+type testEvent struct {
+	r       *http.Request
+	payload interface{}
+}
+
+func (e *testEvent) Name() string {
+	return testEventName
+}
+
+func (e *testEvent) ErrorStrategy() event.ErrorStrategy {
+	return event.ErrorStrategyStop
+}
+
+func (e *testEvent) Request() *http.Request {
+	return e.r
+}
+
+func (e *testEvent) Payload() interface{} {
+	return e.payload
+}
+
+type stream struct {
+	resp    *http.Response
+	scanner *bufio.Scanner
+}
+
+// next reads the next event from the stream, skipping the comments.
+func (s *stream) next() string {
+	var lines []string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			if len(lines) > 0 {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var _ = Describe("Broker access control", func() {
+	logger, _, configMiddleware := abtest.SetupConfigMiddleware()
+	dispatcher := event.NewDispatcher()
+	broker := sse.NewBroker(dispatcher, "/events", testEventName).Middlewares(middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Test-User") == "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+	broker.Filter = func(r *http.Request, name string) bool {
+		return r.Header.Get("X-Test-User") == "admin"
+	}
+
+	s := server.NewServer(nil, logger)
+	s.Use(requestmw.NewRequestIDMiddleware())
+	s.Use(logmw.New(logger))
+	s.Use(configMiddleware)
+	s.Use(translationmw.New(logger, []language.Tag{language.English}))
+	s.Use(errormw.New(true))
+	s.Use(rendermw.New())
+	s.RegisterService(broker)
+	ts := httptest.NewServer(s.Handler())
+
+	request := func(user string) *http.Response {
+		req, err := http.NewRequest("GET", ts.URL+"/events", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Host = "test"
+		req.Header.Set("Accept", "text/event-stream")
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+
+		return resp
+	}
+
+	It("should apply the middlewares to the stream", func() {
+		resp := request("")
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+	})
+
+	It("should only send the events that pass the filter", func() {
+		user := request("user")
+		defer user.Body.Close()
+		Expect(user.StatusCode).To(Equal(http.StatusOK))
+		admin := request("admin")
+		defer admin.Body.Close()
+		Expect(admin.StatusCode).To(Equal(http.StatusOK))
+
+		Expect(broker.Publish("", testEventName, "secret")).To(Succeed())
+		Expect((&stream{resp: admin, scanner: bufio.NewScanner(admin.Body)}).next()).To(HaveSuffix("data: \"secret\""))
+
+		Expect(broker.Stop(context.Background())).To(Succeed())
+		Expect((&stream{resp: user, scanner: bufio.NewScanner(user.Body)}).next()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Broker", func() {
+	logger, _, configMiddleware := abtest.SetupConfigMiddleware()
+	dispatcher := event.NewDispatcher()
+	broker := sse.NewBroker(dispatcher, "/events", testEventName)
+
+	s := server.NewServer(nil, logger)
+	s.Use(requestmw.NewRequestIDMiddleware())
+	s.Use(logmw.New(logger))
+	s.Use(configMiddleware)
+	s.Use(translationmw.New(logger, []language.Tag{language.English}))
+	s.Use(errormw.New(true))
+	s.Use(rendermw.New())
+	s.RegisterService(broker)
+	ts := httptest.NewServer(s.Handler())
+
+	connect := func(lastEventID string) *stream {
+		req, err := http.NewRequest("GET", ts.URL+"/events", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Host = "test"
+		req.Header.Set("Accept", "text/event-stream")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+		return &stream{
+			resp:    resp,
+			scanner: bufio.NewScanner(resp.Body),
+		}
+	}
+
+	dispatch := func(payload interface{}) {
+		stack := middleware.NewStack(nil)
+		stack.Push(configMiddleware)
+		abtest.TestMiddleware(stack, func(w http.ResponseWriter, r *http.Request) {
+			Expect(dispatcher.Dispatch(&testEvent{r: r, payload: payload})).To(BeEmpty())
+		})
+	}
+
+	It("should stream the events of the same site, and resume the stream", func() {
+		st := connect("")
+		defer st.resp.Body.Close()
+
+		dispatch(map[string]string{"a": "b"})
+		Expect(broker.Publish("other", testEventName, "other")).To(Succeed())
+		Expect(broker.Publish("", testEventName, "all")).To(Succeed())
+
+		Expect(st.next()).To(Equal("id: 1\nevent: test-event\ndata: {\"a\":\"b\"}"))
+		Expect(st.next()).To(Equal("id: 3\nevent: test-event\ndata: \"all\""))
+
+		resumed := connect("1")
+		defer resumed.resp.Body.Close()
+
+		Expect(resumed.next()).To(Equal("id: 3\nevent: test-event\ndata: \"all\""))
+	})
+
+	It("should drop the dispatched events without a namespace", func() {
+		st := connect("")
+		defer st.resp.Body.Close()
+
+		Expect(dispatcher.Dispatch(&testEvent{payload: "none"})).To(BeEmpty())
+		Expect(broker.Publish("", testEventName, "all")).To(Succeed())
+
+		Expect(st.next()).To(HaveSuffix("data: \"all\""))
+	})
+
+	It("should close the streams when the broker stops", func() {
+		st := connect("")
+		defer st.resp.Body.Close()
+
+		Expect(broker.Stop(context.Background())).To(Succeed())
+		Expect(st.next()).To(BeEmpty())
+		Expect(st.scanner.Err()).NotTo(HaveOccurred())
+	})
+})
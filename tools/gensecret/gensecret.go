@@ -19,6 +19,7 @@ import (
 
 	"github.com/alien-bunny/ab/lib/log"
 	"github.com/alien-bunny/ab/lib/util"
+	"github.com/alien-bunny/ab/middlewares/securitymw"
 	"github.com/spf13/cobra"
 )
 
@@ -29,9 +30,14 @@ func CreateGenSecretCMD(logger log.Logger) *cobra.Command {
 	}
 
 	length := gscmd.Flags().Int("length", 32, "length of the secret value")
+	adminKey := gscmd.Flags().Bool("admin-key", false, "also print the hash of the secret for the AdminKeys setting")
 
 	gscmd.Run = func(c *cobra.Command, args []string) {
-		fmt.Println(util.RandomSecret(*length))
+		secret := util.RandomSecret(*length)
+		fmt.Println(secret)
+		if *adminKey {
+			fmt.Println(securitymw.HashAdminKey(secret))
+		}
 	}
 
 	return gscmd
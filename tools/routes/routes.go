@@ -0,0 +1,111 @@
+// Copyright 2018 Tamás Demeter-Haludka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routescmd
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alien-bunny/ab/lib/log"
+	"github.com/alien-bunny/ab/lib/server"
+	"github.com/alien-bunny/ab/middlewares/securitymw"
+	"github.com/spf13/cobra"
+)
+
+const jsonPrefix = ")]}',\n"
+
+func CreateRoutesCMD(logger log.Logger) *cobra.Command {
+	insecure := false
+
+	cmd := &cobra.Command{
+		Use:   "routes",
+		Short: "lists the routes of a running server",
+	}
+
+	cmd.Flags().BoolVarP(&insecure, "insecure", "k", false, "skip the verification of the TLS certificate")
+
+	cmd.RunE = func(c *cobra.Command, args []string) error {
+		if len(args) != 3 {
+			return errors.New("3 arguments: server url, admin key name and admin key")
+		}
+
+		routes, err := fetchRoutes(args[0], args[1], args[2], insecure)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "METHOD\tPATH\tSERVICE\tMIDDLEWARES\tDEPENDENCIES")
+		for _, route := range routes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+				route.Method,
+				route.Path,
+				route.Service,
+				strings.Join(route.Middlewares, ", "),
+				strings.Join(route.Dependencies, ", "),
+			)
+		}
+
+		return tw.Flush()
+	}
+
+	return cmd
+}
+
+func fetchRoutes(base, keyName, key string, insecure bool) ([]server.Route, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: insecure,
+			},
+		},
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(base, "/")+"/routes", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", securitymw.AdminKeyHeader(keyName, key))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	if prefix, err := body.Peek(len(jsonPrefix)); err == nil && string(prefix) == jsonPrefix {
+		body.Discard(len(jsonPrefix))
+	}
+
+	var routes []server.Route
+	if err := json.NewDecoder(body).Decode(&routes); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
@@ -35,28 +35,32 @@ func CreateSessionCMD(logger log.Logger) *cobra.Command {
 		Use:   "decode",
 		Short: "dumps and verifies a session",
 	}
+	decodeEncrypted := decode.Flags().Bool("encrypted", false, "only accept encrypted sessions")
 
 	decode.RunE = func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
-			return errors.New("first argument is the encoded session, second is the key (optional)")
+			return errors.New("first argument is the encoded session, the rest are the keys (optional)")
 		}
 
 		encoded := args[0]
-		var key session.SecretKey = nil
-		if len(args) > 1 {
-			decoded, err := hex.DecodeString(args[1])
-			if err != nil {
-				return err
-			}
+		keys, err := session.ParseKeys(args[1:]...)
+		if err != nil {
+			return err
+		}
 
-			key = session.SecretKey(decoded)
+		if *decodeEncrypted && session.Format(encoded) != session.FormatEncrypted {
+			return errors.New("the session is not encrypted")
 		}
 
-		sess, err := session.DecodeSession(encoded, key)
+		sess, keyIndex, err := session.DecodeSessionWithKeys(encoded, keys)
 		if err != nil {
 			return err
 		}
 
+		if keyIndex >= 0 {
+			fmt.Printf("verified with key #%d\n", keyIndex)
+		}
+
 		for k, v := range sess {
 			fmt.Println(k + "\t" + v)
 		}
@@ -68,6 +72,8 @@ func CreateSessionCMD(logger log.Logger) *cobra.Command {
 		Use:   "encode",
 		Short: "encodes and signs a flat JSON into a session",
 	}
+	encodeEncrypted := encode.Flags().Bool("encrypted", false, "encrypt the session")
+	encodeLegacy := encode.Flags().Bool("legacy", false, "use the legacy hex encoded format")
 
 	encode.RunE = func(cmd *cobra.Command, args []string) error {
 		if len(args) != 2 {
@@ -85,7 +91,18 @@ func CreateSessionCMD(logger log.Logger) *cobra.Command {
 		}
 		key := session.SecretKey(decoded)
 
-		fmt.Println(session.EncodeSession(data, key))
+		switch {
+		case *encodeEncrypted:
+			encoded, err := session.EncodeEncryptedSession(data, key)
+			if err != nil {
+				return err
+			}
+			fmt.Println(encoded)
+		case *encodeLegacy:
+			fmt.Println(session.EncodeSession(data, key))
+		default:
+			fmt.Println(session.EncodeSignedSession(data, key))
+		}
 
 		return nil
 	}